@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/cognicraft/serve/serve"
+)
+
+// secretFlagWords mark flags whose values must not be logged.
+var secretFlagWords = []string{"secret", "token", "password", "signing-key"}
+
+func isSecretFlag(name string) bool {
+	for _, w := range secretFlagWords {
+		if strings.Contains(name, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the value of f to be logged.
+func flagValue(f *flag.Flag) string {
+	value := f.Value.String()
+	if isSecretFlag(f.Name) && value != "" {
+		value = "***redacted***"
+	}
+	return value
+}
+
+// logConfig logs the version and the effective value of every flag of fs,
+// as text lines or, if format is json, as a single JSON record written to w.
+func logConfig(w io.Writer, logger *serve.Logger, format string, fs *flag.FlagSet) {
+	if format == "json" {
+		if !logger.Enabled(serve.LevelInfo) {
+			return
+		}
+		flags := map[string]string{}
+		fs.VisitAll(func(f *flag.Flag) {
+			flags[f.Name] = flagValue(f)
+		})
+		b, err := json.Marshal(struct {
+			Time    string            `json:"time"`
+			Msg     string            `json:"msg"`
+			Version string            `json:"version"`
+			Go      string            `json:"go"`
+			OS      string            `json:"os"`
+			Arch    string            `json:"arch"`
+			Flags   map[string]string `json:"flags"`
+		}{time.Now().Format(time.RFC3339), "serve starting", version, runtime.Version(), runtime.GOOS, runtime.GOARCH, flags})
+		if err != nil {
+			logger.Logf(serve.LevelError, "marshal config: %v", err)
+			return
+		}
+		w.Write(append(b, '\n'))
+		return
+	}
+	logger.Logf(serve.LevelInfo, "serve %s (%s %s/%s)", version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fs.VisitAll(func(f *flag.Flag) {
+		logger.Logf(serve.LevelInfo, "  -%s=%s", f.Name, flagValue(f))
+	})
+}
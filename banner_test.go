@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/cognicraft/serve/serve"
+)
+
+func testFlags() *flag.FlagSet {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	fs.String("addr", ":8080", "")
+	fs.Bool("gzip", true, "")
+	fs.String("admin-token", "s3cret", "")
+	fs.String("signing-key", "", "")
+	return fs
+}
+
+func TestLogConfigText(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	var out bytes.Buffer
+	logConfig(&out, &serve.Logger{Level: serve.LevelInfo}, "text", testFlags())
+	if out.Len() != 0 {
+		t.Errorf("got %q written directly", out.String())
+	}
+	got := buf.String()
+	for _, want := range []string{"serve " + version + " (", "  -addr=:8080\n", "  -gzip=true\n", "  -admin-token=***redacted***\n", "  -signing-key=\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %q in %q", want, got)
+		}
+	}
+	if strings.Contains(got, "s3cret") {
+		t.Errorf("secret logged: %q", got)
+	}
+}
+
+func TestLogConfigJSON(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	var out bytes.Buffer
+	logConfig(&out, &serve.Logger{Level: serve.LevelInfo}, "json", testFlags())
+	if buf.Len() != 0 {
+		t.Errorf("got %q logged as text", buf.String())
+	}
+	if strings.Count(out.String(), "\n") != 1 {
+		t.Fatalf("got %q, want a single line", out.String())
+	}
+	var record struct {
+		Time    string            `json:"time"`
+		Version string            `json:"version"`
+		Go      string            `json:"go"`
+		Flags   map[string]string `json:"flags"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &record); err != nil {
+		t.Fatalf("%v: %s", err, out.String())
+	}
+	if record.Time == "" || record.Version != version || record.Go == "" {
+		t.Errorf("got %+v", record)
+	}
+	want := map[string]string{"addr": ":8080", "gzip": "true", "admin-token": "***redacted***", "signing-key": ""}
+	if len(record.Flags) != len(want) {
+		t.Errorf("got flags %v, want %v", record.Flags, want)
+	}
+	for name, value := range want {
+		if got, ok := record.Flags[name]; !ok || got != value {
+			t.Errorf("got -%s=%q, want %q", name, got, value)
+		}
+	}
+
+	out.Reset()
+	logConfig(&out, &serve.Logger{Level: serve.LevelWarn}, "json", testFlags())
+	if out.Len() != 0 {
+		t.Errorf("got %q below the info level", out.String())
+	}
+}
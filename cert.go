@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cognicraft/serve/serve"
+)
+
+// certValidity is the validity of certificates made with -make-cert.
+const certValidity = 365 * 24 * time.Hour
+
+// splitHosts splits a comma separated list of host names and IP addresses.
+func splitHosts(s string) []string {
+	var hosts []string
+	for _, h := range strings.Split(s, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// makeCert writes a self-signed certificate for hosts and its key to the
+// files certFile and keyFile, refusing to overwrite existing files.
+func makeCert(hosts []string, certFile string, keyFile string) error {
+	if len(hosts) == 0 {
+		return fmt.Errorf("no hosts specified")
+	}
+	certPEM, keyPEM, err := serve.GenerateCert(hosts, certValidity)
+	if err != nil {
+		return err
+	}
+	if err := writeNewFile(keyFile, keyPEM, 0600); err != nil {
+		return err
+	}
+	if err := writeNewFile(certFile, certPEM, 0644); err != nil {
+		os.Remove(keyFile)
+		return err
+	}
+	fmt.Printf("Wrote %s and %s for %s.\n", certFile, keyFile, strings.Join(hosts, ", "))
+	return nil
+}
+
+// writeNewFile writes data to the file name, which must not exist yet.
+func writeNewFile(name string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
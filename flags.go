@@ -0,0 +1,15 @@
+package main
+
+import "strings"
+
+// stringsFlag is a flag that may be repeated to collect multiple values.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -1,9 +1,16 @@
 module github.com/cognicraft/serve
 
-go 1.13
+go 1.16
 
 require (
+	filippo.io/age v1.0.0
 	github.com/abbot/go-http-auth v0.4.0
-	golang.org/x/crypto v0.0.0-20200604202706-70a84ac30bf9 // indirect
-	golang.org/x/net v0.0.0-20200602114024-627f9648deb9 // indirect
+	github.com/golang-jwt/jwt/v4 v4.5.2
+	github.com/oschwald/maxminddb-golang v1.8.0
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/sys v0.0.0-20210903071746-97244b99971b
+	golang.org/x/term v0.0.0-20210615171337-6886f2dfbf5b
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -1,139 +1,380 @@
 package main
 
 import (
-	"compress/gzip"
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
-	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"syscall"
 	"time"
 
-	auth "github.com/abbot/go-http-auth"
+	"github.com/cognicraft/serve/serve"
+	"golang.org/x/term"
 )
 
-var version = "dev"
+// version, revision and buildTime are set at build time, e.g. with
+// -ldflags "-X main.version=1.2.0 -X main.revision=$(git rev-parse HEAD)".
+var (
+	version   = "dev"
+	revision  string
+	buildTime string
+)
 
 func main() {
+	c := serve.DefaultConfig()
 	bindFlag := flag.String("bind", "127.0.0.1:8080", "The address that will be bound.")
-	logFlag := flag.Bool("log", false, "Log reqests?")
-	corsFlag := flag.Bool("cors", false, "Add CORS headers?")
-	gzipFlag := flag.Bool("gzip", false, "GZIP content?")
-	authFlag := flag.String("auth", "", "Auth?")
+	autoPortFlag := flag.Int("auto-port", 0, "The number of following ports that are tried if the port of -bind is in use.")
+	reusePortFlag := flag.Bool("reuseport", false, "Enable SO_REUSEPORT to share the port between processes?")
+	backlogFlag := flag.Int("backlog", 0, "The size of the accept queue. Uses the system default if 0.")
+	keepAliveFlag := flag.Duration("keepalive", 3*time.Minute, "The TCP keep-alive period of client connections.")
+	keepAliveDisableFlag := flag.Bool("keepalive-disable", false, "Disable TCP keep-alives?")
+	maxConnsPerIPFlag := flag.Int("max-conns-per-ip", 0, "The maximum number of open connections per client IP. Unlimited if 0.")
+	trustedProxiesFlag := flag.String("trusted-proxies", "", "A comma separated list of IPs or CIDRs of trusted proxies.")
+	logFlag := flag.Bool("log", false, "Log reqests? (alias for -log-level=info)")
+	logLevelFlag := flag.String("log-level", "warn", "Log level: error, warn, info or debug.")
+	flag.StringVar(&c.LogFormat, "log-format", c.LogFormat, "The format of logged requests: text or json.")
+	flag.StringVar(&c.LogFields, "log-fields", c.LogFields, "A comma separated, ordered list of the logged request fields.")
+	flag.StringVar(&c.LogTemplate, "log-template", c.LogTemplate, "A text/template that renders each logged request as a whole line, e.g. '{{.Time.Format \"15:04:05\"}} {{.Method}} {{.Path}} {{.Status}}'.")
+	flag.StringVar(&c.LogRedactQuery, "log-redact-query", c.LogRedactQuery, "A comma separated list of query parameters whose values are redacted in the access log.")
+	var logFileFlag stringsFlag
+	flag.Var(&logFileFlag, "log-file", "A file of the form [format:]path, e.g. json:access.log, that additionally receives the access log in -log-format or the given format. May be repeated.")
+	flag.BoolVar(&c.LogNoQuery, "log-no-query", c.LogNoQuery, "Omit query strings from the access log?")
+	flag.StringVar(&c.LogExcludeStatus, "log-exclude-status", c.LogExcludeStatus, "A comma separated list of response statuses, e.g. 200,304, of requests that are not logged.")
+	flag.StringVar(&c.LogExcludePath, "log-exclude-path", c.LogExcludePath, "A comma separated list of path glob patterns, e.g. /healthz,/metrics/*, of requests that are not logged.")
+	flag.IntVar(&c.LogBuffer, "log-buffer", c.LogBuffer, "The size in bytes of the access log buffer. 0 writes every line immediately.")
+	flag.DurationVar(&c.LogFlushInterval, "log-flush-interval", c.LogFlushInterval, "The interval at which the access log buffer is flushed.")
+	flag.StringVar(&c.LogTimezone, "log-timezone", c.LogTimezone, "The time zone of logged request times: Local, UTC or an IANA name like Europe/Berlin.")
+	flag.StringVar(&c.LogTimeFormat, "log-time-format", c.LogTimeFormat, "The Go time layout of logged request times, e.g. 2006-01-02T15:04:05.000Z07:00.")
+	flag.BoolVar(&c.LogColor, "log-color", term.IsTerminal(int(os.Stderr.Fd())), "Colorize logged requests?")
+	flag.BoolVar(&c.DumpHeaders, "dump-headers", c.DumpHeaders, "Log request and response headers? (implied by -log-level=debug)")
+	flag.BoolVar(&c.DumpHeadersUnsafe, "dump-headers-unsafe", c.DumpHeadersUnsafe, "Do not redact credentials when dumping headers?")
+	flag.BoolVar(&c.NoListing, "no-listing", c.NoListing, "Disable directory listings?")
+	flag.DurationVar(&c.Delay, "delay", c.Delay, "Delay every response by this duration, for testing.")
+	flag.DurationVar(&c.DelayJitter, "delay-jitter", c.DelayJitter, "Delay every response by up to this random duration in addition to -delay.")
+	flag.StringVar(&c.DelayPath, "delay-path", c.DelayPath, "A comma separated list of path prefixes that -delay applies to. All paths if empty.")
+	flag.BoolVar(&c.Once, "once", c.Once, "Shut down after the first complete download of a file?")
+	flag.BoolVar(&c.Htaccess, "htaccess", c.Htaccess, "Apply the .serve.yaml files of served directories, overriding headers, auth and listings below them?")
+	flag.StringVar(&c.DefaultPage, "default-page", c.DefaultPage, "The page that is served for directories without an index.html.")
+	flag.StringVar(&c.ListingSort, "listing-sort", c.ListingSort, "The default order of directory listings: name, size or modified, prefixed by - for descending order.")
+	flag.BoolVar(&c.HumanSizes, "human-sizes", c.HumanSizes, "Show human readable sizes in directory listings?")
+	flag.StringVar(&c.ListingTheme, "listing-theme", c.ListingTheme, "The color theme of directory listings: light, dark or auto.")
+	flag.BoolVar(&c.NoRedirect, "no-redirect", c.NoRedirect, "Serve content directly instead of redirecting to canonical paths?")
+	flag.BoolVar(&c.NoDirRedirect, "no-dir-redirect", c.NoDirRedirect, "Serve directories requested without a trailing slash instead of redirecting?")
+	flag.IntVar(&c.RedirectCode, "redirect-code", c.RedirectCode, "The status code of canonical host and trailing slash redirects, e.g. 302 while testing.")
+	flag.StringVar(&c.CanonicalHost, "canonical-host", c.CanonicalHost, "The canonical host that all other hosts are redirected to.")
+	flag.StringVar(&c.DefaultHost, "default-host", c.DefaultHost, "The host of HTTP/1.0 requests without a Host header.")
+	flag.BoolVar(&c.StrictHost, "strict-host", c.StrictHost, "Answer requests without a host other than HTTP/1.0 with 400?")
+	var headerFlag stringsFlag
+	flag.Var(&headerFlag, "header", "A header of the form Name=value that is added to all responses. May be repeated.")
+	var headerPathFlag stringsFlag
+	flag.Var(&headerPathFlag, "header-path", "A header of the form /prefix:Name=value that is added to responses below the prefix. May be repeated.")
+	var pushFlag stringsFlag
+	flag.Var(&pushFlag, "push", "A rule of the form /path:/asset1,/asset2 listing assets that are pushed over HTTP/2. May be repeated.")
+	var proxyFlag stringsFlag
+	var ignoreFlag stringsFlag
+	var faultFlag stringsFlag
+	var authForFlag stringsFlag
+	flag.Var(&authForFlag, "auth-for", "A realm of the form /prefix=urn, with urn as for -auth, authenticating requests below the prefix instead of -auth. May be repeated.")
+	flag.Var(&faultFlag, "fault", "A rule of the form [/prefix:]status:probability, e.g. 500:0.1, answering that fraction of requests with the status, for testing. May be repeated.")
+	flag.Var(&ignoreFlag, "ignore", "A glob pattern, e.g. *.map or node_modules, of files and directories that are neither listed nor served. May be repeated.")
+	flag.Var(&proxyFlag, "proxy", "A rule of the form /prefix=http://upstream forwarding requests below the prefix. May be repeated.")
+	flag.StringVar(&c.AllowMethods, "allow-methods", c.AllowMethods, "A comma separated list of the allowed methods. All methods are allowed if empty.")
+	flag.DurationVar(&c.CacheTTL, "cache-ttl", c.CacheTTL, "Cache files and directory listings in memory for this duration. Disabled if 0.")
+	flag.Int64Var(&c.CacheMaxBytes, "cache-max-bytes", c.CacheMaxBytes, "The maximum total size of the cache.")
+	flag.BoolVar(&c.Coalesce, "coalesce", c.Coalesce, "Let concurrent requests for a file share reading it into the cache, decrypting it and computing its digest, and those for an archive building it?")
+	flag.DurationVar(&c.NegativeCacheTTL, "negative-cache-ttl", c.NegativeCacheTTL, "Remember missing paths for this duration. Disabled if 0.")
+	flag.BoolVar(&c.CaseInsensitive, "case-insensitive", c.CaseInsensitive, "Resolve missing paths ignoring case and a single trailing dot, as case-insensitive servers do?")
+	flag.StringVar(&c.Decrypt, "decrypt", c.Decrypt, "An age identity file used to decrypt name.age when name is requested. Requires -auth.")
+	flag.DurationVar(&c.DecryptTTL, "decrypt-ttl", c.DecryptTTL, "Keep decrypted files in memory for this duration.")
+	flag.Int64Var(&c.MaxBodySize, "max-body-size", c.MaxBodySize, "The size in bytes above which request bodies are refused with 413. Unlimited if 0.")
+	flag.Int64Var(&c.MinBodyRate, "min-body-rate", c.MinBodyRate, "The rate in bytes per second below which request bodies are answered with 408 and their connection closed. Unlimited if 0.")
+	flag.DurationVar(&c.MinBodyRateGrace, "min-body-rate-grace", c.MinBodyRateGrace, "The time request bodies may take before -min-body-rate applies.")
+	flag.Int64Var(&c.MaxFileSize, "max-file-size", c.MaxFileSize, "The size in bytes above which files are not served. Unlimited if 0.")
+	flag.BoolVar(&c.Scan, "scan", c.Scan, "Log the number and total size of the served files at startup?")
+	flag.StringVar(&c.Sitemap, "sitemap", c.Sitemap, "The base URL, e.g. https://example.com, of a sitemap of the served HTML pages generated at startup and served at /sitemap.xml.")
+	flag.IntVar(&c.WalkWorkers, "walk-workers", c.WalkWorkers, "The number of directories read concurrently by -scan and -sitemap.")
+	flag.DurationVar(&c.WalkTimeout, "walk-timeout", c.WalkTimeout, "The maximum duration of the walks of -scan and -sitemap. 0 means no limit.")
+	flag.StringVar(&c.ContentType, "stdin-type", c.ContentType, "The content type of content read from stdin. Sniffed if empty.")
+	stdinMaxSizeFlag := flag.Int64("stdin-max-size", 64<<20, "The maximum size in bytes of content read from stdin.")
+	flag.IntVar(&c.MaxOpenFiles, "max-open-files", c.MaxOpenFiles, "The maximum number of files served at the same time. Also raises the open file limit on Unix. Unlimited if 0.")
+	flag.StringVar(&c.StatsPath, "stats-path", c.StatsPath, "The path at which statistics are served as JSON.")
+	flag.StringVar(&c.AdminPath, "admin-path", c.AdminPath, "The path below which the admin endpoints, e.g. POST /_admin/cache/purge, are served. Requires -auth or -admin-token.")
+	flag.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "The token admin requests must send in the X-Admin-Token header. Without it, admin requests must be authenticated by -auth.")
+	flag.StringVar(&c.InfoPath, "info-path", c.InfoPath, "The path at which the version, build and runtime information is served as JSON. It is authenticated like any other path with -auth.")
+	flag.StringVar(&c.HealthPath, "health-path", c.HealthPath, "The path at which liveness is served. Readiness is served at <path>/ready.")
+	flag.BoolVar(&c.DebugRootHeader, "debug-root-header", c.DebugRootHeader, "Add an X-Serve-Root header naming the served directory?")
+	flag.BoolVar(&c.ETag, "etag", c.ETag, "Add ETags derived from modification time and size of served files?")
+	flag.BoolVar(&c.Digest, "digest", c.Digest, "Add a Digest header with the SHA-256 of served files?")
+	flag.BoolVar(&c.SignedURLs, "signed-urls", c.SignedURLs, "Only serve requests with a valid URL signature?")
+	flag.StringVar(&c.SigningKey, "signing-key", c.SigningKey, "The secret key used to sign URLs.")
+	signFlag := flag.String("sign", "", "Print a signed URL for this path and exit.")
+	signTTLFlag := flag.Duration("sign-ttl", 24*time.Hour, "The validity of URLs signed with -sign, and of the URL printed with -once and -signed-urls.")
+	flag.StringVar(&c.Robots, "robots", c.Robots, "The robots.txt policy: allow-all, disallow-all or the path of a robots.txt file.")
+	flag.BoolVar(&c.Precompressed, "precompressed", c.Precompressed, "Serve precompressed .br and .gz sidecar files?")
+	flag.StringVar(&c.CompressionDict, "compression-dict", c.CompressionDict, "A shared dictionary file; serve .dcb and .dcz sidecars compressed with it to clients that have it.")
+	flag.StringVar(&c.CompressionDictPath, "compression-dict-path", c.CompressionDictPath, "The path the -compression-dict dictionary is served at.")
+	flag.StringVar(&c.CompressionDictMatch, "compression-dict-match", c.CompressionDictMatch, "The URL pattern, e.g. /api/*, of the responses the -compression-dict dictionary is used for.")
+	flag.BoolVar(&c.I18N, "i18n", c.I18N, "Serve localized variants like index.fr.html of requested files to clients accepting their language?")
+	flag.BoolVar(&c.ImageNegotiation, "image-negotiation", c.ImageNegotiation, "Serve .avif or .webp variants of images to clients accepting them?")
+	flag.StringVar(&c.Throttle, "throttle", c.Throttle, "Limit the throughput of each response, e.g. 1MB/s.")
+	flag.BoolVar(&c.ThrottlePerIP, "throttle-per-ip", c.ThrottlePerIP, "Share the throughput limit between all responses to a client IP?")
+	tlsCertFlag := flag.String("tls-cert", "", "The path of a PEM encoded certificate. Serves HTTPS together with -tls-key.")
+	tlsKeyFlag := flag.String("tls-key", "", "The path of the PEM encoded private key of -tls-cert.")
+	tlsSessionTicketsFlag := flag.Bool("tls-session-tickets", true, "Allow TLS session resumption with session tickets?")
+	tlsTicketRotationFlag := flag.Duration("tls-ticket-rotation", 0, "The interval at which session ticket keys are rotated. 0 keeps the key for the lifetime of the process.")
+	tlsSelfSignedFlag := flag.Bool("tls-self-signed", false, "Serve HTTPS with a self-signed certificate for -make-cert-host if -tls-cert or -tls-key does not exist?")
+	tlsSelfSignedSaveFlag := flag.Bool("tls-self-signed-save", false, "Write the certificate of -tls-self-signed to -tls-cert and -tls-key, so later starts reuse it?")
+	flag.StringVar(&c.GeoIPDB, "geoip-db", c.GeoIPDB, "The path of a MaxMind GeoLite2 country database used by -geo-allow and -geo-deny.")
+	flag.StringVar(&c.GeoAllow, "geo-allow", c.GeoAllow, "A comma separated list of ISO country codes of allowed clients.")
+	flag.StringVar(&c.GeoDeny, "geo-deny", c.GeoDeny, "A comma separated list of ISO country codes of denied clients.")
+	flag.StringVar(&c.ErrorFormat, "error-format", c.ErrorFormat, "The format of error responses for clients preferring JSON: text or json.")
+	flag.StringVar(&c.Page429, "429-page", c.Page429, "An HTML template served with 429 responses. {{.RetryAfter}} renders the seconds until clients may retry.")
+	flag.StringVar(&c.MaintenanceFile, "maintenance-file", c.MaintenanceFile, "A file whose existence switches maintenance mode on, answering requests with 503.")
+	flag.StringVar(&c.MaintenancePage, "maintenance-page", c.MaintenancePage, "An HTML template served in maintenance mode instead of the -503-page.")
+	flag.StringVar(&c.MaintenanceAllow, "maintenance-allow", c.MaintenanceAllow, "A comma separated list of CIDRs served normally in maintenance mode.")
+	flag.DurationVar(&c.MaintenanceRetryAfter, "maintenance-retry-after", c.MaintenanceRetryAfter, "The Retry-After of responses in maintenance mode.")
+	flag.StringVar(&c.Page503, "503-page", c.Page503, "An HTML template served with 503 responses. {{.RetryAfter}} renders the seconds until clients may retry.")
+	flag.StringVar(&c.DefaultType, "default-type", c.DefaultType, "The content type of text files without an extension, e.g. text/plain; charset=utf-8.")
+	var sniffRuleFlag stringsFlag
+	flag.Var(&sniffRuleFlag, "sniff-rule", "A rule of the form .ext=type or 0xhex=type, e.g. 0x0061736d=application/wasm, setting the content type of files by extension or by their first bytes. May be repeated.")
+	flag.StringVar(&c.Charset, "charset", c.Charset, "The charset added to textual content types without one. Empty disables it.")
+	flag.BoolVar(&c.ZipDownload, "zip-download", c.ZipDownload, "Serve directories as zip or tar.gz archives with ?download=zip or ?download=tar.gz?")
+	flag.BoolVar(&c.ArchiveCompress, "archive-compress", c.ArchiveCompress, "Send ?download=tar archives gzip encoded to clients accepting it?")
+	flag.StringVar(&c.RefererAllow, "referer-allow", c.RefererAllow, "A comma separated list of referer host patterns allowed to link protected files, e.g. *.example.com.")
+	flag.StringVar(&c.RefererProtect, "referer-protect", c.RefererProtect, "A comma separated list of file extensions protected from hotlinking, e.g. .jpg,.png.")
+	flag.BoolVar(&c.RefererAllowEmpty, "referer-allow-empty", c.RefererAllowEmpty, "Allow requests for protected files without a referer?")
+	flag.BoolVar(&c.CORS, "cors", c.CORS, "Add CORS headers?")
+	flag.StringVar(&c.CORSOrigins, "cors-origins", c.CORSOrigins, "A comma separated list of the origins allowed by -cors. * allows all origins.")
+	flag.BoolVar(&c.CORSCredentials, "cors-credentials", c.CORSCredentials, "Allow credentialed cross-origin requests from -cors-origins? Requires origins other than *.")
+	flag.StringVar(&c.CORSExpose, "cors-expose", c.CORSExpose, "A comma separated list of response headers exposed to cross-origin scripts.")
+	flag.BoolVar(&c.GRPCWebCORS, "grpc-web-cors", c.GRPCWebCORS, "Add CORS headers allowing gRPC-Web requests? Implies -cors.")
+	flag.BoolVar(&c.GZIP, "gzip", c.GZIP, "GZIP content?")
+	flag.StringVar(&c.RewriteBase, "rewrite-base", c.RewriteBase, "The path, e.g. /app/, below which a proxy makes the site reachable. Root-absolute URLs of HTML documents are prefixed with it and a <base href> is inserted.")
+	flag.StringVar(&c.GZIPSkipUA, "gzip-skip-ua", c.GZIPSkipUA, "A regular expression of User-Agents served uncompressed, e.g. 'MSIE [4-6]\\.'.")
+	flag.StringVar(&c.Auth, "auth", c.Auth, "Auth?")
+	flag.StringVar(&c.AuthNoChallenge, "auth-no-challenge", c.AuthNoChallenge, "When 401 responses omit WWW-Authenticate, so browsers do not prompt for credentials: never, xhr (requests with X-Requested-With: XMLHttpRequest) or always.")
+	flag.StringVar(&c.SessionSecret, "session-secret", c.SessionSecret, "The secret used to sign session cookies issued after authentication.")
+	flag.DurationVar(&c.SessionTTL, "session-ttl", c.SessionTTL, "The validity of session cookies.")
+	flag.StringVar(&c.MiddlewareOrder, "middleware-order", c.MiddlewareOrder, "A comma separated list of the middleware in the order requests pass through them. Defaults to the order listed in the README.")
+	ftpFlag := flag.String("ftp", "", "The address, e.g. :2121, of a read-only FTP server of the served directory. Plaintext: use on trusted networks only.")
+	ftpPassivePortsFlag := flag.String("ftp-passive-ports", "", "The range of ports, e.g. 30000-30009, of passive FTP data connections. Any free port if empty.")
+	qrFlag := flag.Bool("qr", false, "Print a QR code of the Network URL, or the Local URL if there is none, at startup?")
+	openFlag := flag.Bool("open", false, "Open the served URL in the default browser?")
+	checkFlag := flag.Bool("check", false, "Validate the configuration and exit without serving?")
+	makeCertFlag := flag.Bool("make-cert", false, "Write a self-signed certificate and key to cert.pem and key.pem and exit.")
+	makeCertHostFlag := flag.String("make-cert-host", "localhost,127.0.0.1", "A comma separated list of the host names and IP addresses of -make-cert and -tls-self-signed.")
 	vFlag := flag.Bool("version", false, "Version")
 	flag.Parse()
+	c.Headers = headerFlag
+	c.HeaderPaths = headerPathFlag
+	c.Push = pushFlag
+	c.Proxy = proxyFlag
+	c.Ignore = ignoreFlag
+	c.Fault = faultFlag
+	c.AuthFor = authForFlag
+	c.LogFiles = logFileFlag
+	c.SniffRules = sniffRuleFlag
+	c.Build = serve.BuildInfo{Version: version, Revision: revision, Time: buildTime}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "allow-methods" {
+			c.AllowMethodsExplicit = true
+		}
+	})
 
 	if *vFlag {
 		fmt.Printf("%s\n", version)
 		os.Exit(0)
 	}
 
-	dir := "."
+	if *signFlag != "" {
+		if c.SigningKey == "" {
+			log.Fatalf("sign: no signing key specified")
+		}
+		fmt.Println(serve.Sign([]byte(c.SigningKey), *signFlag, time.Now().Add(*signTTLFlag)))
+		os.Exit(0)
+	}
 
-	args := flag.Args()
-	if len(args) > 0 {
-		dir = args[0]
+	if *makeCertFlag {
+		if err := makeCert(splitHosts(*makeCertHostFlag), "cert.pem", "key.pem"); err != nil {
+			log.Fatalf("make cert: %v", err)
+		}
+		os.Exit(0)
 	}
 
-	var h http.Handler = http.FileServer(http.Dir(dir))
-	if *corsFlag {
-		h = CORS(h)
+	level, err := serve.ParseLevel(*logLevelFlag)
+	if err != nil {
+		log.Fatalf("parse log level: %v", err)
 	}
-	if *logFlag {
-		h = LogRequests(h)
+	if *logFlag && level < serve.LevelInfo {
+		level = serve.LevelInfo
 	}
-	if *gzipFlag {
-		h = GZIP(h)
+	logger := &serve.Logger{Level: level}
+	c.Logger = logger
+	logConfig(os.Stderr, logger, c.LogFormat, flag.CommandLine)
+
+	if args := flag.Args(); len(args) > 0 {
+		c.Root = args[0]
 	}
-	if *authFlag != "" {
-		authenticator, err := loadAuthenticator(*authFlag)
-		if err != nil {
-			log.Fatalf("load authenticator: %v", err)
+	if c.Root == "-" {
+		if c.Content, err = readStdin(*stdinMaxSizeFlag); err != nil {
+			log.Fatalf("read stdin: %v", err)
 		}
-		h = Auth(authenticator, h)
 	}
 
-	log.Printf("Serving [%s] at [%s].", dir, *bindFlag)
-	log.Fatal(http.ListenAndServe(*bindFlag, h))
-}
-
-func Auth(authenticator auth.Authenticator, h http.Handler) http.Handler {
-	handle := func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
-		h.ServeHTTP(w, &r.Request)
+	h, err := serve.New(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for name, v := range h.Vars() {
+		expvar.Publish(name, v)
 	}
-	return http.HandlerFunc(authenticator(handle))
-}
-
-func CORS(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Access-Control-Allow-Origin", "*")
-		w.Header().Add("Access-Control-Allow-Methods", "GET")
-		w.Header().Add("Access-Control-Allow-Headers", "Accept")
-		h.ServeHTTP(w, r)
-	})
-}
 
-func LogRequests(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		h.ServeHTTP(w, r)
-		log.Printf("%s %s from %s took %s\n", r.Method, r.URL, r.RemoteAddr, time.Since(start))
-	})
-}
+	trustedProxies, err := serve.ParseCIDRs(*trustedProxiesFlag)
+	if err != nil {
+		log.Fatalf("parse trusted proxies: %v", err)
+	}
+	lo := serve.ListenOptions{
+		ReusePort:      *reusePortFlag,
+		Backlog:        *backlogFlag,
+		KeepAlive:      *keepAliveFlag,
+		MaxConnsPerIP:  *maxConnsPerIPFlag,
+		TrustedProxies: trustedProxies,
+		AutoPort:       *autoPortFlag,
+		Logger:         logger,
+	}
+	if *keepAliveDisableFlag {
+		lo.KeepAlive = -1
+	}
+	if *tlsCertFlag != "" || *tlsKeyFlag != "" || *tlsSelfSignedFlag {
+		lo.TLS, err = serve.NewTLSConfig(serve.TLSOptions{
+			Cert:           *tlsCertFlag,
+			Key:            *tlsKeyFlag,
+			SessionTickets: *tlsSessionTicketsFlag,
+			TicketRotation: *tlsTicketRotationFlag,
+			SelfSigned:     *tlsSelfSignedFlag,
+			SaveSelfSigned: *tlsSelfSignedSaveFlag,
+			Hosts:          splitHosts(*makeCertHostFlag),
+			Logger:         logger,
+		})
+		if err != nil {
+			log.Fatalf("load tls certificate: %v", err)
+		}
+	}
 
-func GZIP(h http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
-			h.ServeHTTP(w, r)
-			return
+	var ftpSrv *serve.FTPServer
+	if *ftpFlag != "" {
+		ftpSrv, err = h.FTP(serve.FTPOptions{PassivePorts: *ftpPassivePortsFlag})
+		if err != nil {
+			log.Fatalf("ftp: %v", err)
 		}
-		w.Header().Set("Content-Encoding", "gzip")
-		gz := gzip.NewWriter(w)
-		defer gz.Close()
-		gzr := gzipResponseWriter{Writer: gz, ResponseWriter: w}
-		h.ServeHTTP(gzr, r)
-	})
-}
+	}
 
-type gzipResponseWriter struct {
-	io.Writer
-	http.ResponseWriter
-}
+	if *checkFlag {
+		if c.Root != "-" {
+			fi, err := os.Stat(c.Root)
+			if err != nil {
+				log.Fatalf("check: %v", err)
+			}
+			if !fi.IsDir() {
+				log.Fatalf("check: %s is not a directory", c.Root)
+			}
+		}
+		fmt.Printf("Configuration is valid. Would serve [%s] at [%s].\n", c.Root, *bindFlag)
+		os.Exit(0)
+	}
 
-func (w gzipResponseWriter) Write(b []byte) (int, error) {
-	if "" == w.Header().Get("Content-Type") {
-		// If no content type, apply sniffing algorithm to un-gzipped body.
-		w.Header().Set("Content-Type", http.DetectContentType(b))
+	ln, err := serve.Listen(*bindFlag, lo)
+	if err != nil {
+		log.Fatalf("listen: %v", err)
 	}
-	return w.Writer.Write(b)
-}
 
-func loadAuthenticator(urn string) (auth.Authenticator, error) {
-	i := strings.IndexRune(urn, '?')
-	if i <= 0 {
-		return nil, fmt.Errorf("no auth type specified")
+	var ftpLn net.Listener
+	if ftpSrv != nil {
+		if ftpLn, err = net.Listen("tcp", *ftpFlag); err != nil {
+			log.Fatalf("listen ftp: %v", err)
+		}
+		go func() {
+			if err := ftpSrv.Serve(ftpLn); err != nil {
+				logger.Logf(serve.LevelError, "serve ftp: %v", err)
+			}
+		}()
 	}
-	typ := urn[:i]
-	rest := urn[i+1:]
 
-	switch typ {
-	case "basic":
-		params, err := url.ParseQuery(rest)
-		if err != nil {
-			return nil, err
+	srv := &http.Server{Handler: h, ConnContext: serve.ConnContext}
+	stopped := make(chan struct{})
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		select {
+		case <-sig:
+		case <-h.Done():
+		}
+		logger.Logf(serve.LevelInfo, "shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if ftpSrv != nil {
+			ftpSrv.Close()
 		}
-		realm := params.Get("realm")
-		secrets := params.Get("secrets")
-		if secrets == "" {
-			return nil, fmt.Errorf("no htpasswd file specified")
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Logf(serve.LevelWarn, "shut down: %v", err)
 		}
-		sp := auth.HtpasswdFileProvider(secrets)
-		a := auth.NewBasicAuthenticator(realm, sp)
-		return a.Wrap, nil
-	default:
-		return nil, fmt.Errorf("unknown auth type specified")
+		close(stopped)
+	}()
+
+	h.SetReady()
+	log.Printf("Serving [%s] at [%s].", c.Root, ln.Addr())
+	local, lan := serveURLs(ln.Addr(), lo.TLS != nil)
+	log.Printf("Local: %s", local)
+	if lan != "" {
+		log.Printf("Network: %s", lan)
+	}
+	if ftpLn != nil {
+		log.Printf("FTP: ftp://%s (plaintext, read-only)", ftpLn.Addr())
+	}
+	if *qrFlag {
+		printQR(os.Stderr, local, lan, logger)
+	}
+	if c.Once {
+		var key []byte
+		if c.SignedURLs {
+			key = []byte(c.SigningKey)
+		}
+		log.Printf("Once: %s", onceURL(local, lan, key, *signTTLFlag))
+		log.Printf("The first complete download of a file shuts serve down.")
+	}
+	if *openFlag {
+		if err := openBrowser(local); err != nil {
+			logger.Logf(serve.LevelWarn, "open browser: %v", err)
+		}
+	}
+	err = srv.Serve(ln)
+	if err != http.ErrServerClosed {
+		h.Close()
+		log.Fatal(err)
+	}
+	<-stopped
+	h.Close()
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to complete
+// after an interrupt.
+const shutdownTimeout = 10 * time.Second
+
+// readStdin reads all of stdin, failing if it exceeds max bytes.
+func readStdin(max int64) ([]byte, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(os.Stdin, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("stdin exceeds %d bytes", max)
 	}
+	return data, nil
 }
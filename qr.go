@@ -0,0 +1,468 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/cognicraft/serve/serve"
+)
+
+// printQR writes a QR code of the lan URL, or of the local one if there is
+// none, followed by the URL itself for terminals that cannot render it.
+func printQR(w io.Writer, local string, lan string, logger *serve.Logger) {
+	url := lan
+	if url == "" {
+		url = local
+	}
+	q, err := encodeQR(url)
+	if err != nil {
+		logger.Logf(serve.LevelWarn, "qr: %v", err)
+		return
+	}
+	fmt.Fprint(w, renderQR(q))
+	fmt.Fprintln(w, url)
+}
+
+// qrVersion describes a QR code version at error correction level L, which
+// suffices for URLs shown on screen. Versions 1 to 9 use a single group of
+// equally sized blocks and an 8 bit character count.
+type qrVersion struct {
+	dataPerBlock int
+	blocks       int
+	ecPerBlock   int
+	// align are the coordinates of the centers of alignment patterns.
+	align []int
+}
+
+var qrVersions = []qrVersion{
+	1: {19, 1, 7, nil},
+	2: {34, 1, 10, []int{6, 18}},
+	3: {55, 1, 15, []int{6, 22}},
+	4: {80, 1, 20, []int{6, 26}},
+	5: {108, 1, 26, []int{6, 30}},
+	6: {68, 2, 18, []int{6, 34}},
+	7: {78, 2, 20, []int{6, 22, 38}},
+	8: {97, 2, 24, []int{6, 24, 42}},
+	9: {116, 2, 30, []int{6, 26, 46}},
+}
+
+// qrCode is a matrix of dark modules.
+type qrCode struct {
+	size     int
+	dark     [][]bool
+	function [][]bool
+}
+
+// encodeQR encodes text in byte mode into the smallest QR code version that
+// fits it.
+func encodeQR(text string) (*qrCode, error) {
+	data := []byte(text)
+	version := 0
+	for v := 1; v < len(qrVersions); v++ {
+		// Mode and count take 12 bits, i.e. 2 codewords rounded up.
+		if len(data)+2 <= qrVersions[v].dataPerBlock*qrVersions[v].blocks {
+			version = v
+			break
+		}
+	}
+	if version == 0 {
+		return nil, fmt.Errorf("%d bytes do not fit a QR code", len(data))
+	}
+	qv := qrVersions[version]
+	q := newQRCode(version)
+	q.setCodewords(qrInterleave(qrData(data, qv.dataPerBlock*qv.blocks), qv))
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.setFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.setFormat(best)
+	return q, nil
+}
+
+// qrData returns the data codewords of data in byte mode, padded to n.
+func qrData(data []byte, n int) []byte {
+	bits := &qrBits{}
+	bits.append(0x4, 4)
+	bits.append(len(data), 8)
+	for _, b := range data {
+		bits.append(int(b), 8)
+	}
+	for i := 0; i < 4 && len(bits.bits) < n*8; i++ {
+		bits.append(0, 1)
+	}
+	for len(bits.bits)%8 != 0 {
+		bits.append(0, 1)
+	}
+	out := bits.bytes()
+	for pad := 0; len(out) < n; pad++ {
+		out = append(out, []byte{0xEC, 0x11}[pad%2])
+	}
+	return out
+}
+
+type qrBits struct {
+	bits []bool
+}
+
+func (b *qrBits) append(v int, n int) {
+	for i := n - 1; i >= 0; i-- {
+		b.bits = append(b.bits, v>>uint(i)&1 == 1)
+	}
+}
+
+func (b *qrBits) bytes() []byte {
+	out := make([]byte, len(b.bits)/8)
+	for i, bit := range b.bits {
+		if bit {
+			out[i/8] |= 0x80 >> uint(i%8)
+		}
+	}
+	return out
+}
+
+// qrInterleave splits data into the blocks of qv, adds their error correction
+// codewords and interleaves them.
+func qrInterleave(data []byte, qv qrVersion) []byte {
+	var blocks, ecs [][]byte
+	for i := 0; i < qv.blocks; i++ {
+		block := data[i*qv.dataPerBlock : (i+1)*qv.dataPerBlock]
+		blocks = append(blocks, block)
+		ecs = append(ecs, reedSolomon(block, qv.ecPerBlock))
+	}
+	var out []byte
+	for i := 0; i < qv.dataPerBlock; i++ {
+		for _, block := range blocks {
+			out = append(out, block[i])
+		}
+	}
+	for i := 0; i < qv.ecPerBlock; i++ {
+		for _, ec := range ecs {
+			out = append(out, ec[i])
+		}
+	}
+	return out
+}
+
+var gfExp, gfLog = func() ([512]byte, [256]byte) {
+	var exp [512]byte
+	var log [256]byte
+	x := 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(x)
+		log[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11D
+		}
+	}
+	for i := 255; i < 512; i++ {
+		exp[i] = exp[i-255]
+	}
+	return exp, log
+}()
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// reedSolomon returns the n error correction codewords of data.
+func reedSolomon(data []byte, n int) []byte {
+	gen := []byte{1}
+	for i := 0; i < n; i++ {
+		next := make([]byte, len(gen)+1)
+		for j, c := range gen {
+			next[j] ^= c
+			next[j+1] ^= gfMul(c, gfExp[i])
+		}
+		gen = next
+	}
+	msg := append(append([]byte(nil), data...), make([]byte, n)...)
+	for i := range data {
+		if c := msg[i]; c != 0 {
+			for j := 1; j < len(gen); j++ {
+				msg[i+j] ^= gfMul(gen[j], c)
+			}
+		}
+	}
+	return msg[len(data):]
+}
+
+// newQRCode returns a code of the version with its function patterns drawn.
+func newQRCode(version int) *qrCode {
+	size := 17 + 4*version
+	q := &qrCode{size: size, dark: make([][]bool, size), function: make([][]bool, size)}
+	for i := range q.dark {
+		q.dark[i] = make([]bool, size)
+		q.function[i] = make([]bool, size)
+	}
+	for i := 0; i < size; i++ {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+	for _, c := range [][2]int{{3, 3}, {size - 4, 3}, {3, size - 4}} {
+		for dy := -4; dy <= 4; dy++ {
+			for dx := -4; dx <= 4; dx++ {
+				x, y := c[0]+dx, c[1]+dy
+				if x >= 0 && x < size && y >= 0 && y < size {
+					d := max(abs(dx), abs(dy))
+					q.set(x, y, d != 2 && d != 4)
+				}
+			}
+		}
+	}
+	align := qrVersions[version].align
+	last := len(align) - 1
+	for i, ax := range align {
+		for j, ay := range align {
+			if i == 0 && j == 0 || i == 0 && j == last || i == last && j == 0 {
+				continue
+			}
+			for dy := -2; dy <= 2; dy++ {
+				for dx := -2; dx <= 2; dx++ {
+					q.set(ax+dx, ay+dy, max(abs(dx), abs(dy)) != 1)
+				}
+			}
+		}
+	}
+	if version >= 7 {
+		rem := version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := version<<12 | rem
+		for i := 0; i < 18; i++ {
+			a, b := size-11+i%3, i/3
+			q.set(a, b, bits>>uint(i)&1 == 1)
+			q.set(b, a, bits>>uint(i)&1 == 1)
+		}
+	}
+	// Reserve the format areas, which are set once the mask is chosen.
+	q.setFormat(0)
+	return q
+}
+
+// set sets the function module at column x and row y.
+func (q *qrCode) set(x, y int, dark bool) {
+	q.dark[y][x] = dark
+	q.function[y][x] = true
+}
+
+// setCodewords places the codewords in the zigzag order of the standard,
+// skipping function modules.
+func (q *qrCode) setCodewords(codewords []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		for vert := 0; vert < q.size; vert++ {
+			for j := 0; j < 2; j++ {
+				x := right - j
+				y := vert
+				if (right+1)&2 == 0 {
+					y = q.size - 1 - vert
+				}
+				if !q.function[y][x] && i < len(codewords)*8 {
+					q.dark[y][x] = codewords[i/8]>>uint(7-i%8)&1 == 1
+					i++
+				}
+			}
+		}
+	}
+}
+
+// applyMask inverts the data modules selected by the mask pattern. Applying
+// it twice restores the modules.
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			var invert bool
+			switch mask {
+			case 0:
+				invert = (x+y)%2 == 0
+			case 1:
+				invert = y%2 == 0
+			case 2:
+				invert = x%3 == 0
+			case 3:
+				invert = (x+y)%3 == 0
+			case 4:
+				invert = (x/3+y/2)%2 == 0
+			case 5:
+				invert = x*y%2+x*y%3 == 0
+			case 6:
+				invert = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				invert = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if invert && !q.function[y][x] {
+				q.dark[y][x] = !q.dark[y][x]
+			}
+		}
+	}
+}
+
+// setFormat draws both copies of the format information for level L and the
+// mask, and the dark module.
+func (q *qrCode) setFormat(mask int) {
+	data := 1<<3 | mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>uint(i)&1 == 1 }
+	for i := 0; i <= 5; i++ {
+		q.set(8, i, bit(i))
+	}
+	q.set(8, 7, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(14-i, 8, bit(i))
+	}
+	for i := 0; i < 8; i++ {
+		q.set(q.size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(8, q.size-15+i, bit(i))
+	}
+	q.set(8, q.size-8, true)
+}
+
+// penalty scores how hard the code is to scan; the mask with the lowest
+// score is used.
+func (q *qrCode) penalty() int {
+	p := 0
+	finder := []bool{true, false, true, true, true, false, true}
+	for _, line := range q.lines() {
+		run := 1
+		for i := 1; i <= len(line); i++ {
+			if i < len(line) && line[i] == line[i-1] {
+				run++
+				continue
+			}
+			if run >= 5 {
+				p += 3 + run - 5
+			}
+			run = 1
+		}
+		for i := 0; i+len(finder) <= len(line); i++ {
+			if !matches(line[i:], finder) {
+				continue
+			}
+			if lightRun(line, i-4, i) || lightRun(line, i+7, i+11) {
+				p += 40
+			}
+		}
+	}
+	dark := 0
+	for y := 0; y < q.size; y++ {
+		for x := 0; x < q.size; x++ {
+			if q.dark[y][x] {
+				dark++
+			}
+			if x > 0 && y > 0 {
+				c := q.dark[y][x]
+				if q.dark[y-1][x] == c && q.dark[y][x-1] == c && q.dark[y-1][x-1] == c {
+					p += 3
+				}
+			}
+		}
+	}
+	percent := dark * 100 / (q.size * q.size)
+	p += abs(percent-50) / 5 * 10
+	return p
+}
+
+// lines returns the rows and columns of the code.
+func (q *qrCode) lines() [][]bool {
+	var lines [][]bool
+	for y := 0; y < q.size; y++ {
+		lines = append(lines, q.dark[y])
+	}
+	for x := 0; x < q.size; x++ {
+		col := make([]bool, q.size)
+		for y := 0; y < q.size; y++ {
+			col[y] = q.dark[y][x]
+		}
+		lines = append(lines, col)
+	}
+	return lines
+}
+
+func matches(line []bool, pattern []bool) bool {
+	for i, p := range pattern {
+		if line[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+// lightRun reports whether the modules of line from i to j are light, with
+// modules outside the code counting as light.
+func lightRun(line []bool, i, j int) bool {
+	for ; i < j; i++ {
+		if i >= 0 && i < len(line) && line[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// qrQuietZone is the width in modules of the light border around a code.
+const qrQuietZone = 2
+
+// renderQR renders q with Unicode half blocks, two rows of modules per line,
+// in black on white regardless of the colors of the terminal.
+func renderQR(q *qrCode) string {
+	darkAt := func(x, y int) bool {
+		x, y = x-qrQuietZone, y-qrQuietZone
+		return x >= 0 && x < q.size && y >= 0 && y < q.size && q.dark[y][x]
+	}
+	b := &strings.Builder{}
+	n := q.size + 2*qrQuietZone
+	for y := 0; y < n; y += 2 {
+		b.WriteString("\x1b[30;107m")
+		for x := 0; x < n; x++ {
+			top, bottom := darkAt(x, y), darkAt(x, y+1)
+			switch {
+			case top && bottom:
+				b.WriteString("█")
+			case top:
+				b.WriteString("▀")
+			case bottom:
+				b.WriteString("▄")
+			default:
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString("\x1b[0m\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReedSolomon(t *testing.T) {
+	// The 1-M example of ISO/IEC 18004, "01234567" in numeric mode.
+	data := []byte{0x10, 0x20, 0x0C, 0x56, 0x61, 0x80, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11, 0xEC, 0x11}
+	want := []byte{0xA5, 0x24, 0xD4, 0xC1, 0xED, 0x36, 0xC7, 0x87, 0x2C, 0x55}
+	if got := reedSolomon(data, 10); !bytes.Equal(got, want) {
+		t.Errorf("got % X, want % X", got, want)
+	}
+}
+
+func TestQRFormat(t *testing.T) {
+	// The format strings of level L by mask.
+	want := []string{
+		"111011111000100",
+		"111001011110011",
+		"111110110101010",
+		"111100010011101",
+		"110011000101111",
+		"110001100011000",
+		"110110001000001",
+		"110100101110110",
+	}
+	for mask, w := range want {
+		q := newQRCode(1)
+		q.setFormat(mask)
+		if got := readFormat(q, false); got != w {
+			t.Errorf("mask %d: got %s around the top left, want %s", mask, got, w)
+		}
+		if got := readFormat(q, true); got != w {
+			t.Errorf("mask %d: got %s in the other corners, want %s", mask, got, w)
+		}
+		if !q.dark[q.size-8][8] {
+			t.Errorf("mask %d: dark module missing", mask)
+		}
+	}
+}
+
+// readFormat returns the format string of q, most significant bit first,
+// from the copy around the top left finder or the one split between the
+// other two.
+func readFormat(q *qrCode, other bool) string {
+	var modules [15]bool
+	for i := 0; i < 15; i++ {
+		var x, y int
+		switch {
+		case other && i < 8:
+			x, y = q.size-1-i, 8
+		case other:
+			x, y = 8, q.size-15+i
+		case i <= 5:
+			x, y = 8, i
+		case i <= 7:
+			x, y = 8, i+1
+		case i == 8:
+			x, y = 7, 8
+		default:
+			x, y = 14-i, 8
+		}
+		modules[14-i] = q.dark[y][x]
+	}
+	b := &strings.Builder{}
+	for _, dark := range modules {
+		if dark {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func TestQRVersionInfo(t *testing.T) {
+	for version, want := range map[int]int{7: 0x07C94, 8: 0x085BC, 9: 0x09A99} {
+		q := newQRCode(version)
+		var right, bottom int
+		for i := 0; i < 18; i++ {
+			a, b := q.size-11+i%3, i/3
+			if q.dark[b][a] {
+				right |= 1 << uint(i)
+			}
+			if q.dark[a][b] {
+				bottom |= 1 << uint(i)
+			}
+		}
+		if right != want || bottom != want {
+			t.Errorf("version %d: got %05X and %05X, want %05X", version, right, bottom, want)
+		}
+	}
+}
+
+func TestQRVersionChoice(t *testing.T) {
+	tests := []struct {
+		n    int
+		size int
+	}{
+		{17, 21},
+		{18, 25},
+		{134, 41},
+		{135, 45},
+		{230, 53},
+	}
+	for _, tt := range tests {
+		q, err := encodeQR(strings.Repeat("x", tt.n))
+		if err != nil {
+			t.Errorf("%d bytes: %v", tt.n, err)
+			continue
+		}
+		if q.size != tt.size {
+			t.Errorf("%d bytes: got size %d, want %d", tt.n, q.size, tt.size)
+		}
+	}
+	if _, err := encodeQR(strings.Repeat("x", 231)); err == nil {
+		t.Error("231 bytes: got no error")
+	}
+}
+
+func TestQRDecode(t *testing.T) {
+	for _, text := range []string{
+		"http://192.168.1.20:8080/",
+		"https://192.168.178.101:8443/?sig=0123456789abcdef&exp=1700000000",
+		strings.Repeat("https://example.com/", 10),
+	} {
+		q, err := encodeQR(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := decodeQR(q); err != "" || got != text {
+			t.Errorf("%q: decoded %q: %s", text, got, err)
+		}
+	}
+}
+
+// decodeQR reads the text back from q, reporting what is wrong otherwise.
+func decodeQR(q *qrCode) (string, string) {
+	format := 0
+	for i, c := range readFormat(q, false) {
+		if c == '1' {
+			format |= 1 << uint(14-i)
+		}
+	}
+	format ^= 0x5412
+	if format>>13 != 1 {
+		return "", "not level L"
+	}
+	version := (q.size - 17) / 4
+	qv := qrVersions[version]
+	q.applyMask(format >> 10 & 7)
+	defer q.applyMask(format >> 10 & 7)
+	// Read the modules up and down pairs of columns from the right.
+	var bits []bool
+	up := true
+	for right := q.size - 1; right > 0; right -= 2 {
+		if right == 6 {
+			right--
+		}
+		for i := 0; i < q.size; i++ {
+			y := i
+			if up {
+				y = q.size - 1 - i
+			}
+			for x := right; x > right-2; x-- {
+				if !q.function[y][x] {
+					bits = append(bits, q.dark[y][x])
+				}
+			}
+		}
+		up = !up
+	}
+	codewords := (&qrBits{bits: bits}).bytes()
+	n := qv.blocks * (qv.dataPerBlock + qv.ecPerBlock)
+	if len(codewords) < n {
+		return "", "too few codewords"
+	}
+	var data []byte
+	for b := 0; b < qv.blocks; b++ {
+		var block, ec []byte
+		for i := 0; i < qv.dataPerBlock; i++ {
+			block = append(block, codewords[i*qv.blocks+b])
+		}
+		for i := 0; i < qv.ecPerBlock; i++ {
+			ec = append(ec, codewords[qv.blocks*qv.dataPerBlock+i*qv.blocks+b])
+		}
+		if !bytes.Equal(reedSolomon(block, qv.ecPerBlock), ec) {
+			return "", "error correction mismatch"
+		}
+		data = append(data, block...)
+	}
+	if data[0]>>4 != 0x4 {
+		return "", "not byte mode"
+	}
+	count := int(data[0]&0xF)<<4 | int(data[1]>>4)
+	text := make([]byte, count)
+	for i := range text {
+		text[i] = data[1+i]<<4 | data[2+i]>>4
+	}
+	return string(text), ""
+}
+
+func TestPrintQR(t *testing.T) {
+	var buf bytes.Buffer
+	printQR(&buf, "http://localhost:8080/", "http://192.168.1.20:8080/", nil)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if got := lines[len(lines)-1]; got != "http://192.168.1.20:8080/" {
+		t.Errorf("got URL %q", got)
+	}
+	// Version 2 with a quiet zone of 2, two rows per line.
+	if got, want := len(lines)-1, (25+2*qrQuietZone+1)/2; got != want {
+		t.Errorf("got %d lines of the code, want %d", got, want)
+	}
+	buf.Reset()
+	printQR(&buf, "http://localhost:8080/", "", nil)
+	if !strings.HasSuffix(buf.String(), "\nhttp://localhost:8080/\n") {
+		t.Errorf("got %q without a LAN URL", buf.String())
+	}
+}
@@ -0,0 +1,432 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	ansiReset  = "\x1b[0m"
+	ansiDim    = "\x1b[2m"
+	ansiRed    = "\x1b[31m"
+	ansiGreen  = "\x1b[32m"
+	ansiYellow = "\x1b[33m"
+	ansiCyan   = "\x1b[36m"
+)
+
+// AccessLog configures how requests are logged.
+type AccessLog struct {
+	// sinks receive every logged request, the first one being the console.
+	sinks []*logSink
+	// fields are the names of the logged fields in order. The text format
+	// uses a fixed line if empty.
+	fields []string
+	// template renders each request, superseding format and fields.
+	template *template.Template
+	// location is the time zone of logged times, local time if nil.
+	location *time.Location
+	// timeFormat is the layout of logged times. The text format defaults to
+	// the layout of the log package and the time field to RFC 3339.
+	timeFormat string
+	// redactQuery are the names of query parameters whose values are
+	// replaced with *** when logged.
+	redactQuery map[string]bool
+	// noQuery omits query strings from the log.
+	noQuery bool
+	// excludeStatus and excludePaths select requests that are not logged,
+	// by their response status or glob patterns of their path.
+	excludeStatus map[int]bool
+	excludePaths  []string
+	// logger reports failures to render the template.
+	logger *Logger
+}
+
+// logSink is a destination of the access log with its own format.
+type logSink struct {
+	// format is either "text" or "json".
+	format string
+	// color colorizes the text format.
+	color bool
+	// out receives the JSON format.
+	out io.Writer
+	// logger writes the text format at info level.
+	logger *log.Logger
+}
+
+func newLogSink(format string, w io.Writer) (*logSink, error) {
+	if format != "text" && format != "json" {
+		return nil, fmt.Errorf("unknown log format: %s", format)
+	}
+	return &logSink{format: format, out: w, logger: log.New(w, "", 0)}, nil
+}
+
+// accessLogEntry holds everything known about a handled request. It is the
+// data passed to log templates.
+type accessLogEntry struct {
+	Time       time.Time
+	Method     string
+	Path       string
+	Query      string
+	Proto      string
+	Host       string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+	RemoteAddr string
+	UserAgent  string
+	Referer    string
+	RequestID  string
+
+	// UncompressedBytes is the size of the response body before
+	// compression, equal to Bytes if the response is not compressed.
+	UncompressedBytes int64
+	// Disconnected is set if the client went away before the response was
+	// written completely.
+	Disconnected bool
+	// UpstreamDuration is the time waited for the upstream of a proxied
+	// request, 0 if the request was not proxied.
+	UpstreamDuration time.Duration
+
+	// timeFormat is the layout of the time field.
+	timeFormat string
+}
+
+// accessLogFields maps field names to their values.
+var accessLogFields = map[string]func(e *accessLogEntry) interface{}{
+	"time":               func(e *accessLogEntry) interface{} { return e.Time.Format(e.timeFormat) },
+	"method":             func(e *accessLogEntry) interface{} { return e.Method },
+	"path":               func(e *accessLogEntry) interface{} { return e.Path },
+	"query":              func(e *accessLogEntry) interface{} { return e.Query },
+	"proto":              func(e *accessLogEntry) interface{} { return e.Proto },
+	"host":               func(e *accessLogEntry) interface{} { return e.Host },
+	"status":             func(e *accessLogEntry) interface{} { return e.Status },
+	"bytes":              func(e *accessLogEntry) interface{} { return e.Bytes },
+	"bytes_uncompressed": func(e *accessLogEntry) interface{} { return e.UncompressedBytes },
+	"duration":           func(e *accessLogEntry) interface{} { return e.Duration.String() },
+	"upstream_ms":        func(e *accessLogEntry) interface{} { return float64(e.UpstreamDuration.Microseconds()) / 1000 },
+	"remote":             func(e *accessLogEntry) interface{} { return e.RemoteAddr },
+	"user_agent":         func(e *accessLogEntry) interface{} { return e.UserAgent },
+	"referer":            func(e *accessLogEntry) interface{} { return e.Referer },
+	"request_id":         func(e *accessLogEntry) interface{} { return e.RequestID },
+	"disconnected":       func(e *accessLogEntry) interface{} { return e.Disconnected },
+}
+
+// defaultJSONFields are logged by the JSON format if no fields are selected.
+// The upstream time is omitted for requests that were not proxied.
+var defaultJSONFields = []string{"time", "method", "path", "query", "status", "bytes", "duration", "upstream_ms", "remote", "user_agent", "referer"}
+
+// NewAccessLog creates an access log writing to stderr in format, "text" or
+// "json", after validating it, the comma separated fields and the template.
+func NewAccessLog(format string, fields string, tmpl string) (*AccessLog, error) {
+	console, err := newLogSink(format, os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+	al := &AccessLog{sinks: []*logSink{console}}
+	if al.fields, err = parseLogFields(fields); err != nil {
+		return nil, err
+	}
+	if tmpl != "" {
+		if al.template, err = template.New("log").Parse(tmpl); err != nil {
+			return nil, err
+		}
+	}
+	return al, nil
+}
+
+// setOutput directs the console sink to w.
+func (al *AccessLog) setOutput(w io.Writer) {
+	al.sinks[0].out = w
+	al.sinks[0].logger = log.New(w, "", 0)
+}
+
+// addSink additionally logs requests to w in format.
+func (al *AccessLog) addSink(format string, w io.Writer) error {
+	sink, err := newLogSink(format, w)
+	if err != nil {
+		return err
+	}
+	al.sinks = append(al.sinks, sink)
+	return nil
+}
+
+// SetTime sets the time zone and the layout of logged times. An empty layout
+// keeps the default layouts.
+func (al *AccessLog) SetTime(location *time.Location, layout string) {
+	al.location = location
+	al.timeFormat = layout
+}
+
+// now returns the current time in the configured time zone.
+func (al *AccessLog) now() time.Time {
+	return al.in(time.Now())
+}
+
+func (al *AccessLog) in(t time.Time) time.Time {
+	if al.location == nil {
+		return t
+	}
+	return t.In(al.location)
+}
+
+// fieldTimeFormat returns the layout of the time field.
+func (al *AccessLog) fieldTimeFormat() string {
+	if al.timeFormat == "" {
+		return time.RFC3339
+	}
+	return al.timeFormat
+}
+
+// printf logs a line of the text format to sink.
+func (al *AccessLog) printf(sink *logSink, format string, args ...interface{}) {
+	layout := al.timeFormat
+	if layout == "" {
+		layout = "2006/01/02 15:04:05"
+	}
+	sink.logger.Printf("%s "+format, append([]interface{}{al.now().Format(layout)}, args...)...)
+}
+
+// SetExclude sets the comma separated response statuses and path glob
+// patterns, as accepted by path.Match, of requests that are not logged.
+func (al *AccessLog) SetExclude(statuses string, paths string) error {
+	al.excludeStatus = nil
+	for _, s := range splitList(statuses) {
+		status, err := strconv.Atoi(s)
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid status: %s", s)
+		}
+		if al.excludeStatus == nil {
+			al.excludeStatus = map[int]bool{}
+		}
+		al.excludeStatus[status] = true
+	}
+	al.excludePaths = splitList(paths)
+	for _, p := range al.excludePaths {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid path pattern: %s", p)
+		}
+	}
+	return nil
+}
+
+// excluded reports whether a request for urlPath answered with status is not
+// logged.
+func (al *AccessLog) excluded(status int, urlPath string) bool {
+	if al.excludeStatus[status] {
+		return true
+	}
+	for _, p := range al.excludePaths {
+		if ok, _ := path.Match(p, urlPath); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// parseLogFields parses a comma separated list of field names.
+func parseLogFields(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if _, ok := accessLogFields[f]; !ok {
+			return nil, fmt.Errorf("unknown log field: %s", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// requestLog collects information about a request from the handlers wrapped
+// by LogRequests.
+type requestLog struct {
+	// compressed is set if the response was compressed.
+	compressed bool
+	// uncompressed is the size of the response body before compression.
+	uncompressed int64
+	// upstream is the time waited for the response of a proxied upstream.
+	upstream time.Duration
+}
+
+type requestLogKey struct{}
+
+// requestLogFrom returns the requestLog of r, or nil if r is not logged.
+func requestLogFrom(r *http.Request) *requestLog {
+	rl, _ := r.Context().Value(requestLogKey{}).(*requestLog)
+	return rl
+}
+
+// LogRequests logs handled requests. It should wrap any compressing handler,
+// so that the logged bytes are the bytes sent on the wire.
+func LogRequests(al *AccessLog, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		rl := &requestLog{}
+		h.ServeHTTP(withOptional(rec, w), r.WithContext(context.WithValue(r.Context(), requestLogKey{}, rl)))
+		uncompressed := rec.bytes
+		if rl.compressed {
+			uncompressed = rl.uncompressed
+		}
+		disconnected := false
+		if rec.err != nil {
+			if disconnected = isClientDisconnect(rec.err, r); disconnected {
+				logOf(r).debugf("client %s disconnected during %s %s: %v", r.RemoteAddr, r.Method, r.URL, rec.err)
+			} else {
+				logOf(r).warnf("write response for %s %s: %v", r.Method, r.URL, rec.err)
+			}
+		}
+		if al.excluded(rec.status, r.URL.Path) {
+			return
+		}
+		al.log(&accessLogEntry{
+			Time:              al.in(start),
+			Method:            r.Method,
+			Path:              r.URL.Path,
+			Query:             al.query(r.URL.RawQuery),
+			Proto:             r.Proto,
+			Host:              r.Host,
+			Status:            rec.status,
+			Bytes:             rec.bytes,
+			UncompressedBytes: uncompressed,
+			Duration:          time.Since(start),
+			RemoteAddr:        r.RemoteAddr,
+			UserAgent:         r.UserAgent(),
+			Referer:           r.Referer(),
+			RequestID:         r.Header.Get("X-Request-Id"),
+			Disconnected:      disconnected,
+			UpstreamDuration:  rl.upstream,
+			timeFormat:        al.fieldTimeFormat(),
+		})
+	})
+}
+
+// query returns the raw query as it is logged, with the values of redacted
+// parameters replaced.
+func (al *AccessLog) query(raw string) string {
+	if al.noQuery {
+		return ""
+	}
+	if len(al.redactQuery) == 0 || raw == "" {
+		return raw
+	}
+	params := strings.Split(raw, "&")
+	for i, param := range params {
+		key := param
+		if j := strings.IndexByte(param, '='); j >= 0 {
+			key = param[:j]
+		}
+		if name, err := url.QueryUnescape(key); err == nil && al.redactQuery[name] {
+			params[i] = key + "=***"
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func (al *AccessLog) log(e *accessLogEntry) {
+	for _, sink := range al.sinks {
+		al.logTo(sink, e)
+	}
+}
+
+func (al *AccessLog) logTo(sink *logSink, e *accessLogEntry) {
+	switch {
+	case al.template != nil:
+		// The template renders the whole line, time included if wanted.
+		b := &bytes.Buffer{}
+		if err := al.template.Execute(b, e); err != nil {
+			al.logger.errorf("execute log template: %v", err)
+			return
+		}
+		if b.Len() == 0 || b.Bytes()[b.Len()-1] != '\n' {
+			b.WriteByte('\n')
+		}
+		sink.out.Write(b.Bytes())
+	case sink.format == "json":
+		sink.out.Write(al.formatJSON(e))
+	case len(al.fields) > 0:
+		al.printf(sink, "%s", al.formatFields(sink, e))
+	default:
+		status := strconv.Itoa(e.Status)
+		took := e.Duration.String()
+		if e.UpstreamDuration > 0 {
+			took += " (upstream " + e.UpstreamDuration.String() + ")"
+		}
+		if sink.color {
+			status = statusColor(e.Status) + status + ansiReset
+			took = ansiDim + took + ansiReset
+		}
+		uri := e.Path
+		if e.Query != "" {
+			uri += "?" + e.Query
+		}
+		al.printf(sink, "%s %s %s from %s took %s\n", status, e.Method, uri, e.RemoteAddr, took)
+	}
+}
+
+func (al *AccessLog) formatJSON(e *accessLogEntry) []byte {
+	fields := al.fields
+	if len(fields) == 0 {
+		fields = defaultJSONFields
+	}
+	b := &bytes.Buffer{}
+	b.WriteByte('{')
+	for _, f := range fields {
+		if f == "upstream_ms" && e.UpstreamDuration == 0 && len(al.fields) == 0 {
+			continue
+		}
+		if b.Len() > 1 {
+			b.WriteByte(',')
+		}
+		k, _ := json.Marshal(f)
+		v, _ := json.Marshal(accessLogFields[f](e))
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+func (al *AccessLog) formatFields(sink *logSink, e *accessLogEntry) string {
+	parts := make([]string, len(al.fields))
+	for i, f := range al.fields {
+		v := fmt.Sprint(accessLogFields[f](e))
+		if v == "" || strings.ContainsAny(v, " \"=") {
+			v = strconv.Quote(v)
+		}
+		if f == "status" && sink.color {
+			v = statusColor(e.Status) + v + ansiReset
+		}
+		parts[i] = f + "=" + v
+	}
+	return strings.Join(parts, " ")
+}
+
+// statusColor returns the ANSI color used to render a status of the given class.
+func statusColor(status int) string {
+	switch {
+	case status >= 500:
+		return ansiRed
+	case status >= 400:
+		return ansiYellow
+	case status >= 300:
+		return ansiCyan
+	default:
+		return ansiGreen
+	}
+}
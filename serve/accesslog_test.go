@@ -0,0 +1,284 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLogTemplate(t *testing.T) {
+	for _, tmpl := range []string{"{{.Method}} {{.Path}} {{.Status}}", "{{.Method}} {{.Path}} {{.Status}}\n"} {
+		al, err := NewAccessLog("text", "", tmpl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		al.setOutput(&buf)
+		h := LogRequests(al, http.NotFoundHandler())
+		get(h, "/a")
+		get(h, "/b")
+		// No time is prefixed, the template renders the whole line.
+		if got, want := buf.String(), "GET /a 404\nGET /b 404\n"; got != want {
+			t.Errorf("%q: got %q, want %q", tmpl, got, want)
+		}
+	}
+}
+
+func TestAccessLogTemplateError(t *testing.T) {
+	al, err := NewAccessLog("text", "", "{{.Missing}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	al.setOutput(&buf)
+	get(LogRequests(al, http.NotFoundHandler()), "/a")
+	if buf.Len() != 0 {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestAccessLogCompressedBytes(t *testing.T) {
+	var buf bytes.Buffer
+	c := DefaultConfig()
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogOutput = &buf
+	c.LogFormat = "json"
+	c.LogFields = "path,bytes,bytes_uncompressed"
+	c.GZIP = true
+	body := strings.Repeat("compressible ", 1000)
+	h := newTestHandler(t, c, map[string]string{"a.txt": body})
+	for _, tt := range []struct {
+		accept     string
+		compressed bool
+	}{
+		{"gzip", true},
+		{"", false},
+	} {
+		buf.Reset()
+		w := get(h, "/a.txt", "Accept-Encoding", tt.accept)
+		var e struct {
+			Bytes             int64 `json:"bytes"`
+			BytesUncompressed int64 `json:"bytes_uncompressed"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+			t.Fatalf("%v: %s", err, buf.String())
+		}
+		if e.Bytes != int64(w.Body.Len()) {
+			t.Errorf("%q: got bytes %d, sent %d", tt.accept, e.Bytes, w.Body.Len())
+		}
+		if e.BytesUncompressed != int64(len(body)) {
+			t.Errorf("%q: got bytes_uncompressed %d, want %d", tt.accept, e.BytesUncompressed, len(body))
+		}
+		if tt.compressed && e.Bytes >= e.BytesUncompressed {
+			t.Errorf("%q: got %d compressed bytes of %d", tt.accept, e.Bytes, e.BytesUncompressed)
+		}
+	}
+}
+
+func TestAccessLogQuery(t *testing.T) {
+	tests := []struct {
+		redact  string
+		noQuery bool
+		target  string
+		query   string
+	}{
+		{"", false, "/a?token=s3cret&page=2", "token=s3cret&page=2"},
+		{"token,key", false, "/a?token=s3cret&page=2&key=k&token=again", "token=***&page=2&key=***&token=***"},
+		{"token", false, "/a?to%6Ben=s3cret&token", "to%6Ben=***&token=***"},
+		{"token", true, "/a?token=s3cret&page=2", ""},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		c := DefaultConfig()
+		c.Logger = &Logger{Level: LevelInfo}
+		c.LogOutput = &buf
+		c.LogFormat = "json"
+		c.LogFields = "path,query"
+		c.LogRedactQuery = tt.redact
+		c.LogNoQuery = tt.noQuery
+		h := newTestHandler(t, c, map[string]string{"a": "a"})
+		r := newRequest(t, tt.target)
+		serveRequest(h, r)
+		var e struct {
+			Query string `json:"query"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+			t.Fatalf("%v: %s", err, buf.String())
+		}
+		if e.Query != tt.query {
+			t.Errorf("%s: got logged query %q, want %q", tt.target, e.Query, tt.query)
+		}
+		if want := tt.target[strings.IndexByte(tt.target, '?')+1:]; r.URL.RawQuery != want {
+			t.Errorf("%s: request query changed to %q", tt.target, r.URL.RawQuery)
+		}
+	}
+}
+
+func TestAccessLogTime(t *testing.T) {
+	const layout = "2006-01-02T15:04:05 MST"
+	for _, zone := range []string{"UTC", "Asia/Tokyo"} {
+		location, err := time.LoadLocation(zone)
+		if err != nil {
+			t.Logf("skipping %s: %v", zone, err)
+			continue
+		}
+		for _, format := range []string{"text", "json"} {
+			var buf bytes.Buffer
+			c := DefaultConfig()
+			c.Logger = &Logger{Level: LevelInfo}
+			c.LogOutput = &buf
+			c.LogFormat = format
+			c.LogFields = "time,path"
+			c.LogTimezone = zone
+			c.LogTimeFormat = layout
+			h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+			get(h, "/a.txt")
+			var logged string
+			if format == "json" {
+				var e struct {
+					Time string `json:"time"`
+				}
+				if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+					t.Fatalf("%v: %s", err, buf.String())
+				}
+				logged = e.Time
+			} else if len(buf.String()) >= len(layout) {
+				logged = buf.String()[:len(layout)]
+			}
+			tm, err := time.ParseInLocation(layout, logged, location)
+			if err != nil {
+				t.Errorf("%s %s: %v in %q", zone, format, err, buf.String())
+				continue
+			}
+			if name, _ := tm.Zone(); name != time.Now().In(location).Format("MST") {
+				t.Errorf("%s %s: got zone %s", zone, format, name)
+			}
+			if d := time.Since(tm); d < -time.Minute || d > time.Minute {
+				t.Errorf("%s %s: got time %s, %s off", zone, format, logged, d)
+			}
+		}
+	}
+}
+
+func TestAccessLogTimezoneInvalid(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogTimezone = "Nowhere/Invalid"
+	if _, err := New(c); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestAccessLogSinks(t *testing.T) {
+	var console bytes.Buffer
+	dir := t.TempDir()
+	jsonFile, textFile := filepath.Join(dir, "access.json"), filepath.Join(dir, "access.log")
+	c := DefaultConfig()
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogOutput = &console
+	c.LogFormat = "text"
+	c.LogFiles = []string{"json:" + jsonFile, textFile}
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	get(h, "/a.txt")
+	get(h, "/missing")
+	h.Close()
+
+	lines := strings.Split(strings.TrimSuffix(console.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "/a.txt") || !strings.Contains(lines[1], "/missing") || strings.HasPrefix(lines[0], "{") {
+		t.Errorf("console: got %q", console.String())
+	}
+	b, err := ioutil.ReadFile(textFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != 2 || !strings.Contains(string(b), "/missing") || strings.HasPrefix(string(b), "{") {
+		t.Errorf("text file: got %q", b)
+	}
+	b, err = ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	type entry struct {
+		Path   string `json:"path"`
+		Status int    `json:"status"`
+	}
+	var entries []entry
+	for dec.More() {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("json file: %v in %q", err, b)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 || entries[0].Path != "/a.txt" || entries[0].Status != 200 || entries[1].Path != "/missing" || entries[1].Status != 404 {
+		t.Errorf("json file: got %+v", entries)
+	}
+}
+
+func TestAccessLogExclude(t *testing.T) {
+	var buf bytes.Buffer
+	c := DefaultConfig()
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogOutput = &buf
+	c.LogFormat = "json"
+	c.LogFields = "path,status"
+	c.LogExcludeStatus = "304, 404"
+	c.LogExcludePath = "/health,/metrics/*"
+	c.ETag = true
+	h := newTestHandler(t, c, map[string]string{
+		"health":      "ok",
+		"metrics/a":   "1",
+		"metrics/b/c": "2",
+		"a.txt":       "a",
+		"b.txt":       "b",
+	})
+	etag := get(h, "/a.txt").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+	get(h, "/health")
+	get(h, "/metrics/a")
+	get(h, "/missing")
+	get(h, "/a.txt", "If-None-Match", etag)
+	// A glob star does not match the separator.
+	get(h, "/metrics/b/c")
+	get(h, "/b.txt")
+	var paths []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var e struct {
+			Path   string `json:"path"`
+			Status int    `json:"status"`
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("%v: %s", err, line)
+		}
+		paths = append(paths, e.Path)
+	}
+	if got, want := strings.Join(paths, " "), "/a.txt /metrics/b/c /b.txt"; got != want {
+		t.Errorf("got logged paths %q, want %q", got, want)
+	}
+}
+
+func TestAccessLogExcludeInvalid(t *testing.T) {
+	for _, tt := range []struct{ statuses, paths string }{
+		{"ok", ""},
+		{"99", ""},
+		{"600", ""},
+		{"", "/a/["},
+	} {
+		al, err := NewAccessLog("text", "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := al.SetExclude(tt.statuses, tt.paths); err == nil {
+			t.Errorf("%q, %q: got no error", tt.statuses, tt.paths)
+		}
+	}
+}
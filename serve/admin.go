@@ -0,0 +1,120 @@
+package serve
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// maxPurgeBody bounds the request body of a purge, which holds at most a
+// path prefix.
+const maxPurgeBody = 4 << 10
+
+var errPurgeBody = errors.New("body must be empty or a path prefix starting with /")
+
+// purger is an in-memory cache keyed by path.
+type purger interface {
+	// purge removes the entries with paths starting with prefix and returns
+	// their number.
+	purge(prefix string) int
+}
+
+// namedCache is a cache purged by Admin, named in its summary.
+type namedCache struct {
+	name  string
+	cache purger
+}
+
+// purgeSummary is the JSON answer of a purge.
+type purgeSummary struct {
+	Prefix string         `json:"prefix"`
+	Purged map[string]int `json:"purged"`
+	Total  int            `json:"total"`
+}
+
+// Admin serves the admin endpoints below path and passes all other requests
+// to h. POST path/cache/purge clears the entries of caches with paths
+// starting with the prefix in the body, plain or as JSON {"prefix": ...}, or
+// all entries if the body is empty. Requests must carry token in the
+// X-Admin-Token header or, if token is empty, have been authenticated.
+func Admin(path string, token string, caches []namedCache, h http.Handler) http.Handler {
+	path = strings.TrimSuffix(path, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path && !strings.HasPrefix(r.URL.Path, path+"/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !adminAllowed(r, token) {
+			logOf(r).warnf("admin request %s %s from %s refused", r.Method, r.URL.Path, r.RemoteAddr)
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		if r.URL.Path != path+"/cache/purge" {
+			httpError(w, r, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httpError(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		prefix, err := readPurgePrefix(r)
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusBadRequest)
+			return
+		}
+		summary := purgeSummary{Prefix: prefix, Purged: map[string]int{}}
+		for _, c := range caches {
+			n := c.cache.purge(prefix)
+			summary.Purged[c.name] = n
+			summary.Total += n
+		}
+		logOf(r).infof("purged %d cache entries of prefix %q", summary.Total, prefix)
+		b, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(append(b, '\n'))
+	})
+}
+
+func adminAllowed(r *http.Request, token string) bool {
+	if token == "" {
+		return authenticated(r)
+	}
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(token)) == 1
+}
+
+// readPurgePrefix returns the path prefix in the body of r, or "" for all
+// paths.
+func readPurgePrefix(r *http.Request) (string, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r.Body, maxPurgeBody+1))
+	if err != nil {
+		return "", err
+	}
+	if len(b) > maxPurgeBody {
+		return "", errPurgeBody
+	}
+	prefix := strings.TrimSpace(string(b))
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" && prefix != "" {
+		var body struct {
+			Prefix string `json:"prefix"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			return "", errPurgeBody
+		}
+		prefix = body.Prefix
+	}
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		return "", errPurgeBody
+	}
+	return prefix, nil
+}
@@ -0,0 +1,100 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// purge posts body to the purge endpoint of h with the header fields given
+// as name and value pairs.
+func purge(h http.Handler, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/_admin/cache/purge", strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return serveRequest(h, r)
+}
+
+func TestAdminPurge(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.CacheTTL = time.Hour
+	c.AdminPath = "/_admin"
+	c.AdminToken = "token"
+	writeFiles(t, c.Root, map[string]string{"a/x.txt": "old", "b/y.txt": "old"})
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetReady()
+	get(h, "/a/x.txt")
+	get(h, "/b/y.txt")
+	writeFiles(t, c.Root, map[string]string{"a/x.txt": "new", "b/y.txt": "new"})
+	if w := get(h, "/a/x.txt"); w.Body.String() != "old" {
+		t.Fatalf("got %q before the purge, want the cached file", w.Body)
+	}
+
+	w := purge(h, `{"prefix": "/a/"}`, "X-Admin-Token", "token", "Content-Type", "application/json")
+	var summary purgeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("got status %d and body %s", w.Code, w.Body)
+	}
+	if summary.Prefix != "/a/" || summary.Purged["files"] != 1 || summary.Total != 1 {
+		t.Errorf("got summary %+v", summary)
+	}
+	if w := get(h, "/a/x.txt"); w.Body.String() != "new" {
+		t.Errorf("got %q after the purge", w.Body)
+	}
+	if w := get(h, "/b/y.txt"); w.Body.String() != "old" {
+		t.Errorf("got %q of another prefix", w.Body)
+	}
+	if w := purge(h, "", "X-Admin-Token", "token"); w.Code != http.StatusOK {
+		t.Errorf("purge all: got status %d", w.Code)
+	}
+	if w := get(h, "/b/y.txt"); w.Body.String() != "new" {
+		t.Errorf("got %q after purging all", w.Body)
+	}
+}
+
+func TestAdminRefused(t *testing.T) {
+	c := DefaultConfig()
+	c.AdminPath = "/_admin"
+	c.AdminToken = "token"
+	h := newTestHandler(t, c, nil)
+	tests := []struct {
+		name   string
+		w      *httptest.ResponseRecorder
+		status int
+	}{
+		{"no token", purge(h, ""), http.StatusForbidden},
+		{"wrong token", purge(h, "", "X-Admin-Token", "guess"), http.StatusForbidden},
+		{"GET", get(h, "/_admin/cache/purge", "X-Admin-Token", "token"), http.StatusMethodNotAllowed},
+		{"unknown endpoint", get(h, "/_admin/reload", "X-Admin-Token", "token"), http.StatusNotFound},
+		{"relative prefix", purge(h, "a/", "X-Admin-Token", "token"), http.StatusBadRequest},
+		{"malformed JSON", purge(h, "{", "X-Admin-Token", "token", "Content-Type", "application/json"), http.StatusBadRequest},
+		{"large body", purge(h, "/"+strings.Repeat("a", maxPurgeBody), "X-Admin-Token", "token"), http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if tt.w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, tt.w.Code, tt.status)
+		}
+	}
+	if w := get(h, "/_admin/cache/purge", "X-Admin-Token", "token"); w.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("got Allow %q", w.Header().Get("Allow"))
+	}
+}
+
+func TestAdminRequiresCredentials(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.AdminPath = "/_admin"
+	if h, err := New(c); err == nil {
+		h.Close()
+		t.Error("got no error without -auth or -admin-token")
+	}
+}
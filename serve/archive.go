@@ -0,0 +1,269 @@
+package serve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+)
+
+// maxSharedArchiveSize bounds the archives built once in memory for
+// concurrent requests. Larger archives are streamed to each request.
+const maxSharedArchiveSize = 64 << 20
+
+// errArchiveTooLarge is returned by a shared build exceeding
+// maxSharedArchiveSize.
+var errArchiveTooLarge = errors.New("archive too large to share")
+
+// archiveWriter adds files to an archive written to an underlying writer.
+type archiveWriter interface {
+	add(name string, fi os.FileInfo, r io.Reader) error
+	Close() error
+}
+
+// archiveFormat is a format that directories can be downloaded in.
+type archiveFormat struct {
+	ext         string
+	contentType string
+	new         func(w io.Writer) archiveWriter
+}
+
+// archiveFormats are the formats by the value of the download query
+// parameter.
+var archiveFormats = map[string]archiveFormat{
+	"zip":    {ext: ".zip", contentType: "application/zip", new: newZipArchive},
+	"tar.gz": {ext: ".tar.gz", contentType: "application/gzip", new: newTarGzArchive},
+	"tar":    {ext: ".tar", contentType: "application/x-tar", new: newTarArchive},
+}
+
+// Archive streams a directory as an archive when it is requested with
+// ?download=zip, ?download=tar.gz or ?download=tar. Unless shared, the
+// archive is built while it is sent, so only the file being added is held
+// open. Files hidden or refused by fs are left out. If compress is set, tar
+// archives are sent with a gzip Content-Encoding to clients accepting it. If
+// coalesce is set, concurrent requests for the same archive share one build
+// of it in memory, up to 64 MiB; larger archives are streamed to each of them.
+func Archive(fs http.FileSystem, compress bool, coalesce bool, h http.Handler) http.Handler {
+	return archiveHandler(fs, compress, newFlightGroup(coalesce, nil), h)
+}
+
+// archiveHandler is Archive sharing builds through flights, unless nil.
+func archiveHandler(fs http.FileSystem, compress bool, flights *flightGroup, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format, ok := archiveFormats[r.URL.Query().Get("download")]
+		if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		f, err := fs.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		fi, err := f.Stat()
+		f.Close()
+		if err != nil || !fi.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		base := path.Base(name)
+		if base == "/" {
+			base = "root"
+		}
+		w.Header().Set("Content-Type", format.contentType)
+		w.Header().Set("Content-Disposition", contentDisposition(base+format.ext))
+		var out io.Writer = w
+		var gz *gzip.Writer
+		if compress && format.ext == ".tar" {
+			addVary(w.Header(), "Accept-Encoding")
+			if accepts(parseQualityList(r.Header.Get("Accept-Encoding")), "gzip") > 0 {
+				w.Header().Set("Content-Encoding", "gzip")
+				gz = gzip.NewWriter(w)
+				out = gz
+			}
+		}
+		if r.Method == http.MethodHead {
+			return
+		}
+		if flights != nil {
+			v, err, _ := flights.do(name+"\x00"+format.ext, func() (interface{}, error) {
+				return buildArchive(format, fs, name, base)
+			})
+			if err == nil {
+				b := v.([]byte)
+				if gz == nil {
+					w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+				}
+				_, err = out.Write(b)
+				if err == nil && gz != nil {
+					err = gz.Close()
+				}
+				if err != nil {
+					logOf(r).debugf("archive %s: %v", name, err)
+				}
+				return
+			}
+			if !errors.Is(err, errArchiveTooLarge) {
+				w.Header().Del("Content-Encoding")
+				w.Header().Del("Content-Disposition")
+				logOf(r).warnf("archive %s: %v", name, err)
+				httpError(w, r, "Failed to build archive", http.StatusInternalServerError)
+				return
+			}
+		}
+		a := format.new(out)
+		err = addDir(a, fs, name, base)
+		if err == nil {
+			err = a.Close()
+		}
+		if err == nil && gz != nil {
+			err = gz.Close()
+		}
+		if err != nil {
+			if isClientDisconnect(err, r) {
+				logOf(r).debugf("archive %s: client disconnected", name)
+			} else {
+				logOf(r).warnf("archive %s: %v", name, err)
+			}
+		}
+	})
+}
+
+// buildArchive returns the archive of the directory name of fs in format,
+// or errArchiveTooLarge once it exceeds maxSharedArchiveSize. It does not
+// depend on a request, since concurrent requests share it.
+func buildArchive(format archiveFormat, fs http.FileSystem, name string, base string) ([]byte, error) {
+	var buf bytes.Buffer
+	a := format.new(&limitedWriter{w: &buf, n: maxSharedArchiveSize})
+	err := addDir(a, fs, name, base)
+	if err == nil {
+		err = a.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// limitedWriter writes up to n bytes to w and fails with errArchiveTooLarge
+// beyond.
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > l.n {
+		return 0, errArchiveTooLarge
+	}
+	l.n -= int64(len(b))
+	return l.w.Write(b)
+}
+
+// addDir adds the content of the directory name of fs to a below prefix.
+func addDir(a archiveWriter, fs http.FileSystem, name string, prefix string) error {
+	d, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	infos, err := d.Readdir(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	for _, fi := range infos {
+		child := path.Join(name, fi.Name())
+		entry := path.Join(prefix, fi.Name())
+		if fi.IsDir() {
+			if err := a.add(entry+"/", fi, nil); err != nil {
+				return err
+			}
+			if err := addDir(a, fs, child, entry); err != nil {
+				return err
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		f, err := fs.Open(child)
+		if err != nil {
+			// Refused by the file system, e.g. too large.
+			continue
+		}
+		err = a.add(entry, fi, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type zipArchive struct {
+	*zip.Writer
+}
+
+func newZipArchive(w io.Writer) archiveWriter {
+	return zipArchive{zip.NewWriter(w)}
+}
+
+func (a zipArchive) add(name string, fi os.FileInfo, r io.Reader) error {
+	hdr, err := zip.FileInfoHeader(fi)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if r != nil {
+		hdr.Method = zip.Deflate
+	}
+	w, err := a.CreateHeader(hdr)
+	if err != nil || r == nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+type tarArchive struct {
+	*tar.Writer
+	// closer, if set, is closed after the tar writer, e.g. to finish
+	// compression.
+	closer io.Closer
+}
+
+func newTarArchive(w io.Writer) archiveWriter {
+	return tarArchive{Writer: tar.NewWriter(w)}
+}
+
+func newTarGzArchive(w io.Writer) archiveWriter {
+	gz := gzip.NewWriter(w)
+	return tarArchive{Writer: tar.NewWriter(gz), closer: gz}
+}
+
+func (a tarArchive) add(name string, fi os.FileInfo, r io.Reader) error {
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := a.WriteHeader(hdr); err != nil || r == nil {
+		return err
+	}
+	_, err = io.Copy(a.Writer, r)
+	return err
+}
+
+func (a tarArchive) Close() error {
+	if err := a.Writer.Close(); err != nil || a.closer == nil {
+		return err
+	}
+	return a.closer.Close()
+}
@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+// tarFiles returns the contents of the tar archive r by name.
+func tarFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		files[hdr.Name] = string(b)
+	}
+}
+
+func TestArchiveCompress(t *testing.T) {
+	c := DefaultConfig()
+	c.ZipDownload = true
+	c.ArchiveCompress = true
+	// The gzip middleware must not compress archives a second time.
+	c.GZIP = true
+	h := newTestHandler(t, c, map[string]string{"d/a.txt": "a", "d/b.txt": "b"})
+	download := func(format string, acceptEncoding string) *http.Response {
+		r := newRequest(t, "/d/?download="+format)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		return serveRequest(h, r).Result()
+	}
+
+	resp := download("tar", "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("tar: got Content-Encoding %q", got)
+	}
+	if got := resp.Header.Get("Content-Type"); got != "application/x-tar" {
+		t.Errorf("tar: got Content-Type %q", got)
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := tarFiles(t, zr); len(files) != 2 || files["d/a.txt"] != "a" || files["d/b.txt"] != "b" {
+		t.Errorf("tar: got files %v", files)
+	}
+
+	resp = download("tar", "")
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("tar without gzip accepted: got Content-Encoding %q", got)
+	}
+	if files := tarFiles(t, resp.Body); len(files) != 2 {
+		t.Errorf("tar without gzip accepted: got files %v", files)
+	}
+
+	resp = download("zip", "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("zip: got Content-Encoding %q", got)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	if _, err := zip.NewReader(bytes.NewReader(b), int64(len(b))); err != nil {
+		t.Errorf("zip: %v", err)
+	}
+
+	resp = download("tar.gz", "gzip")
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("tar.gz: got Content-Encoding %q", got)
+	}
+	zr, err = gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files := tarFiles(t, zr); len(files) != 2 {
+		t.Errorf("tar.gz: got files %v", files)
+	}
+}
+
+func TestArchiveNoCompress(t *testing.T) {
+	c := DefaultConfig()
+	c.ZipDownload = true
+	h := newTestHandler(t, c, map[string]string{"d/a.txt": "a"})
+	r := newRequest(t, "/d/?download=tar")
+	r.Header.Set("Accept-Encoding", "gzip")
+	resp := serveRequest(h, r).Result()
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Errorf("got Content-Encoding %q", got)
+	}
+	if files := tarFiles(t, resp.Body); files["d/a.txt"] != "a" {
+		t.Errorf("got files %v", files)
+	}
+}
@@ -0,0 +1,198 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+// authRealm requires the requests below prefix to be authenticated by
+// authenticator. If sessions is not nil, clients receive a session cookie
+// after authenticating, which skips the authenticator on subsequent requests.
+type authRealm struct {
+	prefix        string
+	authenticator auth.Authenticator
+	sessions      *sessions
+	// noChallenge selects the requests whose 401 responses omit the
+	// WWW-Authenticate header, so browsers do not prompt for credentials.
+	noChallenge func(r *http.Request) bool
+}
+
+// parseNoChallenge parses when 401 responses omit the WWW-Authenticate
+// header: never, for xhr requests sent with X-Requested-With: XMLHttpRequest,
+// or always.
+func parseNoChallenge(s string) (func(r *http.Request) bool, error) {
+	switch s {
+	case "", "never":
+		return nil, nil
+	case "xhr":
+		return func(r *http.Request) bool { return r.Header.Get("X-Requested-With") == "XMLHttpRequest" }, nil
+	case "always":
+		return func(r *http.Request) bool { return true }, nil
+	default:
+		return nil, fmt.Errorf("unknown auth challenge mode: %s", s)
+	}
+}
+
+// Auth requires requests to be authenticated by the realm with the longest
+// prefix of the request path. The path is matched as cleaned by the file
+// server, so that dot segments and repeated slashes cannot leave a realm.
+// Requests outside of all realms are forbidden if directory rules require
+// authentication and passed to h as is otherwise, as are those that the rules
+// exempt.
+func Auth(realms []authRealm, h http.Handler) http.Handler {
+	realms = append([]authRealm(nil), realms...)
+	sort.SliceStable(realms, func(i, j int) bool { return len(realms[i].prefix) > len(realms[j].prefix) })
+	handlers := make([]http.HandlerFunc, len(realms))
+	for i, realm := range realms {
+		handlers[i] = realmHandler(realm, h)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authRequired(r) {
+			name := path.Clean("/" + r.URL.Path)
+			for i, realm := range realms {
+				if inRealm(name, realm.prefix) {
+					handlers[i](w, r)
+					return
+				}
+			}
+			if authForced(r) {
+				httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// inRealm reports whether the cleaned path name is below the realm prefix,
+// matching whole segments: /admin/ covers /admin, which is redirected to, or
+// served as, /admin/, but not /administration.
+func inRealm(name string, prefix string) bool {
+	dir := strings.TrimSuffix(prefix, "/")
+	return name == dir || strings.HasPrefix(name, dir+"/")
+}
+
+type authenticatedKey struct{}
+
+// authenticated reports whether r passed an authenticator or carried a valid
+// session cookie.
+func authenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(authenticatedKey{}).(bool)
+	return ok
+}
+
+func withAuthenticated(r *http.Request) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), authenticatedKey{}, true))
+}
+
+func realmHandler(realm authRealm, h http.Handler) http.HandlerFunc {
+	handle := func(w http.ResponseWriter, r *auth.AuthenticatedRequest) {
+		if realm.sessions != nil {
+			realm.sessions.issue(w, &r.Request, r.Username)
+		}
+		h.ServeHTTP(w, withAuthenticated(&r.Request))
+	}
+	a := realm.authenticator(handle)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if realm.sessions != nil && realm.sessions.valid(r) {
+			h.ServeHTTP(w, withAuthenticated(r))
+			return
+		}
+		if realm.noChallenge != nil && realm.noChallenge(r) {
+			w = withOptional(&noChallengeWriter{ResponseWriter: w}, w)
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		a(withOptional(rec, w), r)
+		if rec.status == http.StatusUnauthorized {
+			logOf(r).debugf("auth failed for %s %s from %s", r.Method, r.URL, r.RemoteAddr)
+		}
+	}
+}
+
+// noChallengeWriter removes the WWW-Authenticate header from 401 responses.
+type noChallengeWriter struct {
+	http.ResponseWriter
+}
+
+func (w *noChallengeWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized {
+		w.Header().Del("WWW-Authenticate")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// parseAuthRealms parses realms of the form /prefix=urn, where urn is as
+// accepted by loadAuthenticator.
+func parseAuthRealms(specs []string) ([]authRealm, error) {
+	var realms []authRealm
+	for _, spec := range specs {
+		i := strings.IndexRune(spec, '=')
+		if i <= 0 || !strings.HasPrefix(spec, "/") {
+			return nil, fmt.Errorf("invalid auth realm: %s", spec)
+		}
+		a, err := loadAuthenticator(spec[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", spec[:i], err)
+		}
+		realms = append(realms, authRealm{prefix: spec[:i], authenticator: a})
+	}
+	return realms, nil
+}
+
+// splitAuthURN splits an auth urn of the form type?params.
+func splitAuthURN(urn string) (typ string, rest string, err error) {
+	i := strings.IndexRune(urn, '?')
+	if i <= 0 {
+		return "", "", fmt.Errorf("no auth type specified")
+	}
+	return urn[:i], urn[i+1:], nil
+}
+
+// newBasicAuth parses the parameters of a basic auth urn.
+func newBasicAuth(rest string) (*auth.BasicAuth, error) {
+	params, err := url.ParseQuery(rest)
+	if err != nil {
+		return nil, err
+	}
+	realm := params.Get("realm")
+	secrets := params.Get("secrets")
+	if secrets == "" {
+		return nil, fmt.Errorf("no htpasswd file specified")
+	}
+	if _, err := os.Stat(secrets); err != nil {
+		return nil, err
+	}
+	sp := auth.HtpasswdFileProvider(secrets)
+	return auth.NewBasicAuthenticator(realm, sp), nil
+}
+
+func loadAuthenticator(urn string) (auth.Authenticator, error) {
+	typ, rest, err := splitAuthURN(urn)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case "basic":
+		a, err := newBasicAuth(rest)
+		if err != nil {
+			return nil, err
+		}
+		return a.Wrap, nil
+	case "jwt":
+		a, err := newJWTAuth(rest)
+		if err != nil {
+			return nil, err
+		}
+		return a.Wrap, nil
+	default:
+		return nil, fmt.Errorf("unknown auth type specified")
+	}
+}
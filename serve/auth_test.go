@@ -0,0 +1,183 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthRealmsCleanPath(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.AuthFor = []string{"/admin/=basic?realm=admin&secrets=" + secrets}
+	h := newTestHandler(t, c, map[string]string{
+		"pub/index.html": "public",
+		"admin/s.txt":    "protected",
+	})
+	for _, path := range []string{
+		"/admin/s.txt",
+		"/pub/../admin/s.txt",
+		"//admin/s.txt",
+		"/./admin/s.txt",
+		"/admin//s.txt",
+		"/admin",
+	} {
+		w := get(h, path)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", path, w.Code, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Basic realm="admin"` {
+			t.Errorf("%s: got challenge %q", path, got)
+		}
+	}
+	if w := get(h, "/pub/"); w.Code != http.StatusOK {
+		t.Errorf("/pub/: got status %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+func TestAuthRealmsEncodedDotSegments(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.AuthFor = []string{"/admin/=basic?realm=admin&secrets=" + secrets}
+	h := newTestHandler(t, c, map[string]string{
+		"pub/index.html": "public",
+		"admin/s.txt":    "protected",
+	})
+	// A request line of /pub/%2e%2e/admin/s.txt arrives decoded.
+	r := newRequest(t, "/pub/%2e%2e/admin/s.txt")
+	if r.URL.Path != "/pub/../admin/s.txt" {
+		t.Fatalf("got path %q", r.URL.Path)
+	}
+	w := serveRequest(h, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	r = newRequest(t, "/pub/%2e%2e/admin/s.txt")
+	r.SetBasicAuth("admin", "secret")
+	if w := serveRequest(h, r); w.Code != http.StatusOK || w.Body.String() != "protected" {
+		t.Errorf("authenticated: got status %d and body %q", w.Code, w.Body)
+	}
+}
+
+func TestAuthDirRulesOutsideRealms(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.Htaccess = true
+	c.AuthFor = []string{"/admin/=basic?realm=admin&secrets=" + secrets}
+	h := newTestHandler(t, c, map[string]string{
+		"admin/s.txt":             "protected",
+		"private/.serve.yaml":     "auth: true\n",
+		"private/s.txt":           "private",
+		"private/pub/.serve.yaml": "auth: false\n",
+		"private/pub/p.txt":       "public",
+	})
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/private/s.txt", http.StatusForbidden},
+		{"/private/pub/p.txt", http.StatusOK},
+		{"/admin/s.txt", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := get(h, tt.path); w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+}
+
+func TestAuthClaimsStripped(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Auth-Claims")))
+	}))
+	defer upstream.Close()
+	c := DefaultConfig()
+	c.Proxy = []string{"/api=" + upstream.URL}
+	h := newTestHandler(t, c, nil)
+	for _, path := range []string{"/api/x", "/api/../api/x"} {
+		w := get(h, path, "X-Auth-Claims", `{"sub":"admin"}`)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("%s: got status %d and claims %q", path, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAuthNoChallenge(t *testing.T) {
+	tests := []struct {
+		mode      string
+		xhr       bool
+		challenge bool
+	}{
+		{"never", false, true},
+		{"never", true, true},
+		{"xhr", false, true},
+		{"xhr", true, false},
+		{"always", false, false},
+		{"always", true, false},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+		c := DefaultConfig()
+		c.AuthFor = []string{"/admin/=basic?realm=admin&secrets=" + secrets}
+		c.AuthNoChallenge = tt.mode
+		h := newTestHandler(t, c, map[string]string{"admin/s.txt": "protected"})
+		var header []string
+		if tt.xhr {
+			header = []string{"X-Requested-With", "XMLHttpRequest"}
+		}
+		w := get(h, "/admin/s.txt", header...)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s, xhr %v: got status %d", tt.mode, tt.xhr, w.Code)
+		}
+		if got := w.Header().Get("WWW-Authenticate") != ""; got != tt.challenge {
+			t.Errorf("%s, xhr %v: got challenge %v, want %v", tt.mode, tt.xhr, got, tt.challenge)
+		}
+		r := newRequest(t, "/admin/s.txt")
+		r.SetBasicAuth("admin", "secret")
+		if w := serveRequest(h, r); w.Code != http.StatusOK {
+			t.Errorf("%s, authenticated: got status %d", tt.mode, w.Code)
+		}
+	}
+}
+
+func TestAuthNoChallengeInvalid(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.AuthNoChallenge = "sometimes"
+	if _, err := New(c); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestAuthRealmsSegmentBoundary(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	for _, prefix := range []string{"/admin", "/admin/"} {
+		c := DefaultConfig()
+		c.AuthFor = []string{prefix + "=basic?realm=admin&secrets=" + secrets}
+		h := newTestHandler(t, c, map[string]string{
+			"admin/s.txt":          "protected",
+			"administration/s.txt": "public",
+			"admin.txt":            "public",
+		})
+		tests := []struct {
+			path   string
+			status int
+		}{
+			{"/admin/s.txt", http.StatusUnauthorized},
+			{"/admin", http.StatusUnauthorized},
+			{"/admin/", http.StatusUnauthorized},
+			{"/administration/s.txt", http.StatusOK},
+			{"/admin.txt", http.StatusOK},
+		}
+		for _, tt := range tests {
+			if w := get(h, tt.path); w.Code != tt.status {
+				t.Errorf("%s realm, %s: got status %d, want %d", prefix, tt.path, w.Code, tt.status)
+			}
+		}
+	}
+}
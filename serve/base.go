@@ -0,0 +1,250 @@
+package serve
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	xhtml "golang.org/x/net/html"
+)
+
+// maxRewriteSize bounds the HTML documents rewritten by RewriteBase. Larger
+// documents are served unchanged.
+const maxRewriteSize = 8 << 20
+
+// urlAttrs are the attributes holding a URL, as element.attribute,
+// rewritten by RewriteBase.
+var urlAttrs = map[string]bool{
+	"a.href":            true,
+	"area.href":         true,
+	"audio.src":         true,
+	"base.href":         true,
+	"button.formaction": true,
+	"embed.src":         true,
+	"form.action":       true,
+	"iframe.src":        true,
+	"img.src":           true,
+	"img.srcset":        true,
+	"input.formaction":  true,
+	"input.src":         true,
+	"link.href":         true,
+	"object.data":       true,
+	"script.src":        true,
+	"source.src":        true,
+	"source.srcset":     true,
+	"track.src":         true,
+	"video.poster":      true,
+	"video.src":         true,
+}
+
+// parseRewriteBase returns the base path, with a leading and a trailing
+// slash, a site is mounted at.
+func parseRewriteBase(s string) (string, error) {
+	if !strings.HasPrefix(s, "/") || strings.HasPrefix(s, "//") {
+		return "", fmt.Errorf("base must be a path starting with /: %s", s)
+	}
+	return strings.TrimSuffix(path.Clean(s), "/") + "/", nil
+}
+
+// RewriteBase adapts the HTML documents of a site built for / to be served
+// below base, e.g. by a proxy forwarding /app/ to serve: root-absolute URLs
+// of links, scripts, images and forms are prefixed with base, and a
+// <base href> is inserted into documents without one, so that relative URLs
+// resolve below base as well. Range requests for documents are answered in
+// full, since the rewritten content differs from the file.
+func RewriteBase(base string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ext := path.Ext(r.URL.Path); strings.HasSuffix(r.URL.Path, "/") || ext == ".html" || ext == ".htm" {
+			r.Header.Del("Range")
+		}
+		bw := &baseWriter{ResponseWriter: w, base: base, head: r.Method == http.MethodHead}
+		defer bw.finish()
+		h.ServeHTTP(withOptional(bw, w), r)
+	})
+}
+
+// baseWriter holds back successful HTML responses and writes them rewritten
+// by finish. Other responses pass through.
+type baseWriter struct {
+	http.ResponseWriter
+	base string
+	// head responses have no body to rewrite, only their length changes.
+	head        bool
+	wroteHeader bool
+	buffering   bool
+	status      int
+	buf         bytes.Buffer
+}
+
+func (w *baseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	header := w.Header()
+	ctype := strings.TrimSpace(strings.SplitN(header.Get("Content-Type"), ";", 2)[0])
+	size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	if status == http.StatusOK && ctype == "text/html" && header.Get("Content-Encoding") == "" && (err != nil || size <= maxRewriteSize) {
+		header.Del("Content-Length")
+		weakenETag(header)
+		if !w.head {
+			w.buffering = true
+			w.status = status
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *baseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.buffering {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.buf.Len()+len(b) <= maxRewriteSize {
+		return w.buf.Write(b)
+	}
+	// Too large to rewrite: send what is held back unchanged.
+	w.buffering = false
+	w.ResponseWriter.WriteHeader(w.status)
+	if _, err := w.ResponseWriter.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf.Reset()
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush is a no-op while the document is held back.
+func (w *baseWriter) Flush() {
+	if !w.buffering {
+		flush(w.ResponseWriter)
+	}
+}
+
+func (w *baseWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	w.buffering = false
+	out := rewriteHTML(w.buf.Bytes(), w.base)
+	w.Header().Set("Content-Length", strconv.Itoa(len(out)))
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(out)
+}
+
+// rewriteHTML prefixes the root-absolute URLs of doc with base and inserts
+// <base href="base"> unless doc has a base element with an href. The base
+// element goes right after <head>, or before the first element other than
+// <html> in documents without a head. Documents without elements have no
+// URLs to resolve and get none.
+func rewriteHTML(doc []byte, base string) []byte {
+	insert := !hasBaseHref(doc)
+	baseTag := `<base href="` + html.EscapeString(base) + `">`
+	var out bytes.Buffer
+	z := xhtml.NewTokenizer(bytes.NewReader(doc))
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			// io.EOF; the tokenizer reads from memory and fails no other way.
+			out.Write(z.Raw())
+			break
+		}
+		raw := z.Raw()
+		if tt != xhtml.StartTagToken && tt != xhtml.SelfClosingTagToken {
+			out.Write(raw)
+			continue
+		}
+		// Token lower cases the tag name in place, so keep the original.
+		raw = append([]byte(nil), raw...)
+		tok := z.Token()
+		if insert && tok.Data != "html" && tok.Data != "head" {
+			out.WriteString(baseTag)
+			insert = false
+		}
+		if rewriteAttrs(&tok, base) {
+			out.WriteString(tok.String())
+		} else {
+			out.Write(raw)
+		}
+		if insert && tok.Data == "head" {
+			out.WriteString(baseTag)
+			insert = false
+		}
+	}
+	return out.Bytes()
+}
+
+// hasBaseHref reports whether doc has a base element with an href.
+func hasBaseHref(doc []byte) bool {
+	z := xhtml.NewTokenizer(bytes.NewReader(doc))
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			return false
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			if string(name) != "base" {
+				continue
+			}
+			for hasAttr {
+				var key []byte
+				key, _, hasAttr = z.TagAttr()
+				if string(key) == "href" {
+					return true
+				}
+			}
+		}
+	}
+}
+
+// rewriteAttrs prefixes the root-absolute URLs of the attributes of tok
+// with base and reports whether it changed any.
+func rewriteAttrs(tok *xhtml.Token, base string) bool {
+	changed := false
+	for i, a := range tok.Attr {
+		if a.Namespace != "" || !urlAttrs[tok.Data+"."+a.Key] {
+			continue
+		}
+		var v string
+		if a.Key == "srcset" {
+			v = rewriteSrcset(a.Val, base)
+		} else {
+			v = rebaseURL(a.Val, base)
+		}
+		if v != a.Val {
+			tok.Attr[i].Val = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// rewriteSrcset rebases the URLs of a srcset, a comma separated list of URLs
+// each optionally followed by a descriptor.
+func rewriteSrcset(srcset string, base string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		trimmed := strings.TrimLeft(c, " \t\n\r\f")
+		candidates[i] = c[:len(c)-len(trimmed)] + rebaseURL(trimmed, base)
+	}
+	return strings.Join(candidates, ",")
+}
+
+// rebaseURL prefixes u with base if it is root-absolute, i.e. starts with a
+// single slash.
+func rebaseURL(u string, base string) string {
+	if !strings.HasPrefix(u, "/") || strings.HasPrefix(u, "//") || strings.HasPrefix(u, "/\\") {
+		return u
+	}
+	return strings.TrimSuffix(base, "/") + u
+}
@@ -0,0 +1,111 @@
+package serve
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestRewriteHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{
+			"head",
+			`<html><head><link href="/style.css"></head><body><a href="/docs/">Docs</a></body></html>`,
+			`<html><head><base href="/app/"><link href="/app/style.css"></head><body><a href="/app/docs/">Docs</a></body></html>`,
+		},
+		{
+			"no head",
+			`<!DOCTYPE html><p><img src="/logo.png"></p>`,
+			`<!DOCTYPE html><base href="/app/"><p><img src="/app/logo.png"></p>`,
+		},
+		{
+			"existing base",
+			`<head><base href="/other/"><script src="/app.js"></script></head>`,
+			`<head><base href="/app/other/"><script src="/app/app.js"></script></head>`,
+		},
+		{
+			"other URLs",
+			`<head></head><a href="//cdn.example.com/x">a</a><a href="https://example.com/">b</a><a href="rel.html">c</a><a href="#top">d</a><a href="/\evil">e</a>`,
+			`<head><base href="/app/"></head><a href="//cdn.example.com/x">a</a><a href="https://example.com/">b</a><a href="rel.html">c</a><a href="#top">d</a><a href="/\evil">e</a>`,
+		},
+		{
+			"srcset",
+			`<head></head><img srcset="/a.png 1x, /b.png 2x,c.png 3x">`,
+			`<head><base href="/app/"></head><img srcset="/app/a.png 1x, /app/b.png 2x,c.png 3x">`,
+		},
+		{
+			"form",
+			`<head></head><form action="/search"><button formaction="/go">Go</button></form>`,
+			`<head><base href="/app/"></head><form action="/app/search"><button formaction="/app/go">Go</button></form>`,
+		},
+		{
+			"not a URL attribute",
+			`<HEAD></HEAD><div data-href="/x" title="/y">`,
+			`<HEAD><base href="/app/"></HEAD><div data-href="/x" title="/y">`,
+		},
+		{"text only", `just text`, `just text`},
+	}
+	for _, tt := range tests {
+		if got := string(rewriteHTML([]byte(tt.doc), "/app/")); got != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseRewriteBase(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		ok   bool
+	}{
+		{"/app", "/app/", true},
+		{"/app/", "/app/", true},
+		{"/a/../b//c", "/b/c/", true},
+		{"/", "/", true},
+		{"app", "", false},
+		{"//cdn.example.com", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseRewriteBase(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseRewriteBase(%q) = %q, %v, want %q and ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestRewriteBase(t *testing.T) {
+	c := DefaultConfig()
+	c.RewriteBase = "/app"
+	c.ETag = true
+	doc := `<html><head></head><body><a href="/x.txt">x</a></body></html>`
+	want := `<html><head><base href="/app/"></head><body><a href="/app/x.txt">x</a></body></html>`
+	h := newTestHandler(t, c, map[string]string{
+		"index.html": doc,
+		"x.txt":      `<a href="/x.txt">`,
+	})
+	w := get(h, "/")
+	if w.Code != http.StatusOK || w.Body.String() != want {
+		t.Fatalf("got status %d and body %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(want)) {
+		t.Errorf("got Content-Length %s, want %d", got, len(want))
+	}
+	if etag := w.Header().Get("ETag"); etag != "" && etag[:2] != "W/" {
+		t.Errorf("got strong ETag %s for a rewritten document", etag)
+	}
+	// Ranges of the file do not apply to the rewritten document.
+	if w := get(h, "/", "Range", "bytes=0-9"); w.Code != http.StatusOK || w.Body.String() != want {
+		t.Errorf("range: got status %d and body %q", w.Code, w.Body)
+	}
+	if w := get(h, "/x.txt"); w.Body.String() != `<a href="/x.txt">` {
+		t.Errorf("text: got body %q", w.Body)
+	}
+	if w := get(h, "/x.txt", "Range", "bytes=0-1"); w.Code != http.StatusPartialContent {
+		t.Errorf("text range: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MaxBodySize answers requests announcing a body larger than max bytes with
+// 413 and limits the body of all other requests to max bytes, so handlers
+// reading past it fail instead of exhausting memory.
+func MaxBodySize(max int64, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			w.Header().Set("Connection", "close")
+			httpError(w, r, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, max)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isBodyTooLarge reports whether err was returned reading past the limit of
+// MaxBodySize.
+func isBodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+type connKey struct{}
+
+// ConnContext stores the connection of a request in its context, so that
+// MinBodyRate can set read deadlines. It is meant for http.Server.ConnContext.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// errBodyTooSlow is returned reading a request body that arrives slower than
+// the minimum rate.
+var errBodyTooSlow = errors.New("request body too slow")
+
+// MinBodyRate answers requests whose body arrives slower than rate bytes per
+// second after the grace period with 408 and closes their connection. The
+// rate is enforced with read deadlines on the connection, which requires
+// ConnContext; HTTP/2 requests share their connection and are not limited.
+func MinBodyRate(rate int64, grace time.Duration, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(connKey{}).(net.Conn)
+		if !ok || r.ProtoMajor != 1 || r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+		body := &rateReader{ReadCloser: r.Body, conn: conn, rate: rate, grace: grace, start: time.Now()}
+		r.Body = body
+		sw := &slowBodyWriter{ResponseWriter: w, body: body}
+		h.ServeHTTP(withOptional(sw, w), r)
+		if body.isTooSlow() {
+			logOf(r).infof("client %s sent the body of %s %s slower than %d bytes/s", r.RemoteAddr, r.Method, r.URL, rate)
+			if !sw.wroteHeader {
+				w.Header().Set("Connection", "close")
+				httpError(w, r, http.StatusText(http.StatusRequestTimeout), http.StatusRequestTimeout)
+			}
+			return
+		}
+		// The server reads the rest of the body after the handler, which
+		// must not take longer either.
+		body.setDeadline(r.ContentLength)
+	})
+}
+
+// rateReader sets a read deadline on conn before each read, by which the
+// next byte must arrive to keep up the rate.
+type rateReader struct {
+	io.ReadCloser
+	conn  net.Conn
+	rate  int64
+	grace time.Duration
+	start time.Time
+	read  int64
+	// eof and tooSlow are set atomically, since bodies may be read
+	// concurrently to the handler, e.g. by Proxy.
+	eof     int32
+	tooSlow int32
+}
+
+func (b *rateReader) isTooSlow() bool { return atomic.LoadInt32(&b.tooSlow) == 1 }
+
+func (b *rateReader) Read(p []byte) (int, error) {
+	if b.isTooSlow() {
+		return 0, errBodyTooSlow
+	}
+	b.setDeadline(b.read + 1)
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		atomic.StoreInt32(&b.tooSlow, 1)
+		return n, errBodyTooSlow
+	}
+	if err == io.EOF {
+		// Remove the deadline, so it does not affect the server's reads
+		// after the body.
+		atomic.StoreInt32(&b.eof, 1)
+		b.conn.SetReadDeadline(time.Time{})
+	}
+	return n, err
+}
+
+// setDeadline sets the read deadline by which n bytes of the body must have
+// arrived, unless the body was read completely.
+func (b *rateReader) setDeadline(n int64) {
+	if atomic.LoadInt32(&b.eof) == 1 {
+		return
+	}
+	due := b.start.Add(time.Duration(float64(n) / float64(b.rate) * float64(time.Second)))
+	if grace := b.start.Add(b.grace); due.Before(grace) {
+		due = grace
+	}
+	b.conn.SetReadDeadline(due)
+}
+
+// slowBodyWriter discards the response once the body was too slow, so that
+// MinBodyRate can answer with 408 instead.
+type slowBodyWriter struct {
+	http.ResponseWriter
+	body        *rateReader
+	wroteHeader bool
+}
+
+func (w *slowBodyWriter) WriteHeader(status int) {
+	if w.body.isTooSlow() || w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *slowBodyWriter) Write(b []byte) (int, error) {
+	if w.body.isTooSlow() && !w.wroteHeader {
+		return 0, errBodyTooSlow
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
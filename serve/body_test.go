@@ -0,0 +1,81 @@
+package serve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMinBodyRate serves MinBodyRate of rate bytes per second over
+// connections known to ConnContext, answering with the length of the body.
+func startMinBodyRate(t *testing.T, rate int64) string {
+	t.Helper()
+	s := httptest.NewUnstartedServer(MinBodyRate(rate, 100*time.Millisecond, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return
+		}
+		fmt.Fprint(w, len(b))
+	})))
+	s.Config.ConnContext = ConnContext
+	s.Start()
+	t.Cleanup(s.Close)
+	return s.Listener.Addr().String()
+}
+
+func TestMinBodyRateSlow(t *testing.T) {
+	addr := startMinBodyRate(t, 1000)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 1000\r\n\r\n")
+	// Dribble a byte every 50ms, 20 bytes per second.
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := c.Write([]byte("x")); err != nil {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestTimeout || !resp.Close {
+		t.Errorf("got status %d, closing %v", resp.StatusCode, resp.Close)
+	}
+	// The connection is closed rather than kept alive.
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("got %v reading after the response, want EOF", err)
+	}
+}
+
+func TestMinBodyRateFast(t *testing.T) {
+	addr := startMinBodyRate(t, 1000)
+	body := strings.Repeat("x", 10000)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post("http://"+addr+"/", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(b) != "10000" {
+			t.Errorf("request %d: got status %d and body %q", i+1, resp.StatusCode, b)
+		}
+	}
+}
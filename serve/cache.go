@@ -0,0 +1,281 @@
+package serve
+
+import (
+	"bytes"
+	"container/list"
+	"expvar"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cachingFS is a read-through cache for the files and directory listings of
+// an http.FileSystem. Entries expire after a TTL and the least recently used
+// entries are evicted once the total size exceeds a bound.
+type cachingFS struct {
+	fs       http.FileSystem
+	ttl      time.Duration
+	maxBytes int64
+	flights  *flightGroup
+	// hits and misses count the opened names found or not in the cache.
+	hits   *expvar.Int
+	misses *expvar.Int
+	log    *Logger
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+type cacheEntry struct {
+	name    string
+	data    []byte
+	info    os.FileInfo
+	dir     []os.FileInfo
+	expires time.Time
+}
+
+func (e *cacheEntry) size() int64 {
+	// Listings are accounted with a rough estimate per entry.
+	return int64(len(e.data)) + int64(len(e.dir))*256
+}
+
+func newCachingFS(fs http.FileSystem, ttl time.Duration, maxBytes int64, flights *flightGroup, stats *handlerStats, log *Logger) *cachingFS {
+	return &cachingFS{
+		fs:       fs,
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		flights:  flights,
+		hits:     &stats.cacheHits,
+		misses:   &stats.cacheMisses,
+		log:      log,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+func (c *cachingFS) Open(name string) (http.File, error) {
+	if e, ok := c.get(name); ok {
+		c.hits.Add(1)
+		c.log.debugf("cache hit for %s", name)
+		return newMemFile(e), nil
+	}
+	c.misses.Add(1)
+	v, err, _ := c.flights.do(name, func() (interface{}, error) { return c.load(name) })
+	if err != nil {
+		return nil, err
+	}
+	if e := v.(*cacheEntry); e != nil {
+		return newMemFile(e), nil
+	}
+	return c.fs.Open(name)
+}
+
+// load reads name into a new cache entry, or returns nil for a file too
+// large to cache.
+func (c *cachingFS) load(name string) (*cacheEntry, error) {
+	f, err := c.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() && info.Size() > c.maxBytes {
+		return nil, nil
+	}
+	e := &cacheEntry{name: name, info: info, expires: time.Now().Add(c.ttl)}
+	if info.IsDir() {
+		e.dir, err = f.Readdir(-1)
+	} else {
+		e.data, err = ioutil.ReadAll(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.put(e)
+	return e, nil
+}
+
+func (c *cachingFS) get(name string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if time.Now().After(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e, true
+}
+
+func (c *cachingFS) put(e *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.name]; ok {
+		c.remove(el)
+	}
+	c.entries[e.name] = c.lru.PushFront(e)
+	c.size += e.size()
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *cachingFS) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.name)
+	c.size -= e.size()
+}
+
+// purge removes the entries with names starting with prefix and returns
+// their number.
+func (c *cachingFS) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for name, el := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			c.remove(el)
+			n++
+		}
+	}
+	return n
+}
+
+// memFile is an http.File backed by a cache entry.
+type memFile struct {
+	*bytes.Reader
+	entry *cacheEntry
+	// dir is a copy of the listing of the entry, since callers like
+	// http.FileServer sort what Readdir returns in place.
+	dir []os.FileInfo
+	pos int
+}
+
+func newMemFile(e *cacheEntry) *memFile {
+	f := &memFile{Reader: bytes.NewReader(e.data), entry: e}
+	if e.dir != nil {
+		f.dir = append([]os.FileInfo(nil), e.dir...)
+	}
+	return f
+}
+
+func (f *memFile) Close() error { return nil }
+
+func (f *memFile) Stat() (os.FileInfo, error) { return f.entry.info, nil }
+
+func (f *memFile) Readdir(count int) ([]os.FileInfo, error) {
+	rest := f.dir[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.dir)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.pos += count
+	return rest[:count], nil
+}
+
+// negativeCacheSize bounds the number of paths remembered by a negativeFS.
+const negativeCacheSize = 10000
+
+// negativeFS remembers names that do not exist for a TTL and fails to open
+// them without consulting the underlying file system.
+type negativeFS struct {
+	fs  http.FileSystem
+	ttl time.Duration
+	log *Logger
+
+	mu      sync.Mutex
+	fifo    *list.List
+	entries map[string]*list.Element
+}
+
+type negativeEntry struct {
+	name    string
+	expires time.Time
+}
+
+func newNegativeFS(fs http.FileSystem, ttl time.Duration, log *Logger) *negativeFS {
+	return &negativeFS{
+		fs:      fs,
+		ttl:     ttl,
+		log:     log,
+		fifo:    list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+func (c *negativeFS) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if c.missing(name) {
+		c.log.debugf("negative cache hit for %s", name)
+		return nil, os.ErrNotExist
+	}
+	f, err := c.fs.Open(name)
+	if os.IsNotExist(err) {
+		c.remember(name)
+	}
+	return f, err
+}
+
+func (c *negativeFS) missing(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[name]
+	if !ok {
+		return false
+	}
+	if time.Now().After(el.Value.(*negativeEntry).expires) {
+		c.fifo.Remove(el)
+		delete(c.entries, name)
+		return false
+	}
+	return true
+}
+
+func (c *negativeFS) remember(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[name]; ok {
+		c.fifo.Remove(el)
+	}
+	c.entries[name] = c.fifo.PushBack(&negativeEntry{name: name, expires: time.Now().Add(c.ttl)})
+	for c.fifo.Len() > negativeCacheSize {
+		e := c.fifo.Remove(c.fifo.Front()).(*negativeEntry)
+		delete(c.entries, e.name)
+	}
+}
+
+// purge forgets the missing names starting with prefix and returns their
+// number.
+func (c *negativeFS) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for name, el := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			c.fifo.Remove(el)
+			delete(c.entries, name)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCachingFSListingNotShared(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"d/a": "a", "d/b": "b", "d/c": "c"})
+	c := newCachingFS(http.Dir(dir), time.Minute, 1<<20, nil, newHandlerStats(), nil)
+	names := func() []string {
+		f, err := c.Open("/d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		infos, err := f.Readdir(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range infos {
+			names = append(names, fi.Name())
+		}
+		// Sort in place like http.FileServer, in reverse.
+		sort.Slice(infos, func(i, j int) bool { return infos[i].Name() > infos[j].Name() })
+		return names
+	}
+	first := names()
+	for i := 0; i < 3; i++ {
+		if got := names(); strings.Join(got, ",") != strings.Join(first, ",") {
+			t.Fatalf("got listing %v, want %v", got, first)
+		}
+	}
+	if c.hits.Value() != 3 {
+		t.Errorf("got %d hits, want 3", c.hits.Value())
+	}
+}
+
+func TestAdminPurgesNegativeCache(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.NegativeCacheTTL = time.Hour
+	c.AdminPath = "/_admin"
+	c.AdminToken = "token"
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetReady()
+	if w := get(h, "/new.txt"); w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	writeFiles(t, c.Root, map[string]string{"new.txt": "new"})
+	if w := get(h, "/new.txt"); w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d before the purge, want %d", w.Code, http.StatusNotFound)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/_admin/cache/purge", strings.NewReader("/new"))
+	r.Header.Set("X-Admin-Token", "token")
+	w := serveRequest(h, r)
+	var summary purgeSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("got status %d and body %s", w.Code, w.Body)
+	}
+	if summary.Purged["missing"] != 1 {
+		t.Errorf("got summary %+v", summary)
+	}
+	if w := get(h, "/new.txt"); w.Code != http.StatusOK || w.Body.String() != "new" {
+		t.Errorf("got status %d and body %q after the purge", w.Code, w.Body)
+	}
+}
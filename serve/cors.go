@@ -0,0 +1,140 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// CORSOptions configure CORS headers.
+type CORSOptions struct {
+	// Origins are the allowed origins. * allows all origins.
+	Origins []string
+	// Credentials allows requests with cookies or authorization. The
+	// allowed origin is then echoed, as browsers reject credentialed
+	// responses allowing *.
+	Credentials bool
+	// Expose are the response headers that scripts may read.
+	Expose []string
+	// Methods and Headers are the allowed request methods and headers,
+	// GET and Accept if empty. New advertises the methods of the enabled
+	// features, limited to Config.AllowMethods if it was set explicitly.
+	Methods []string
+	Headers []string
+}
+
+// GRPCWeb returns o extended by the methods and headers of gRPC-Web clients,
+// exposing the grpc-status and grpc-message trailers, which gRPC-Web sends as
+// headers of responses without a body.
+func (o CORSOptions) GRPCWeb() CORSOptions {
+	o.Methods = appendMissing(o.Methods, "GET", "POST")
+	o.Headers = appendMissing(o.Headers, grpcWebHeaders...)
+	o.Expose = appendMissing(o.Expose, "grpc-status", "grpc-message")
+	return o
+}
+
+// grpcWebHeaders are the request headers sent by gRPC-Web clients.
+var grpcWebHeaders = []string{
+	"Accept",
+	"Content-Type",
+	"X-Grpc-Web",
+	"X-User-Agent",
+	"Grpc-Timeout",
+	"X-Accept-Content-Transfer-Encoding",
+	"X-Accept-Response-Streaming",
+}
+
+// appendMissing appends the values to list that it does not contain yet.
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, l := range list {
+			if strings.EqualFold(l, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// featureMethods returns the methods handled by the features enabled in c:
+// GET and HEAD for files, and the methods of APIs behind a reverse proxy.
+func featureMethods(c Config) []string {
+	methods := []string{"GET", "HEAD"}
+	if len(c.Proxy) > 0 {
+		methods = append(methods, "POST", "PUT", "PATCH", "DELETE")
+	}
+	return methods
+}
+
+// restrictMethods returns the methods that are also allowed, or all of them
+// if allowed is empty.
+func restrictMethods(methods []string, allowed []string) []string {
+	if len(allowed) == 0 {
+		return methods
+	}
+	var restricted []string
+	for _, m := range methods {
+		for _, a := range allowed {
+			if m == a {
+				restricted = append(restricted, m)
+				break
+			}
+		}
+	}
+	return restricted
+}
+
+func (o CORSOptions) validate() error {
+	for _, origin := range o.Origins {
+		if origin == "*" && o.Credentials {
+			return fmt.Errorf("credentials cannot be allowed for any origin")
+		}
+	}
+	return nil
+}
+
+// CORS adds CORS headers to responses to requests from allowed origins.
+func CORS(opts CORSOptions, h http.Handler) http.Handler {
+	any := false
+	allowed := map[string]bool{}
+	for _, origin := range opts.Origins {
+		if origin == "*" {
+			any = true
+		}
+		allowed[origin] = true
+	}
+	methods, headers := "GET", "Accept"
+	if len(opts.Methods) > 0 {
+		methods = strings.Join(opts.Methods, ", ")
+	}
+	if len(opts.Headers) > 0 {
+		headers = strings.Join(opts.Headers, ", ")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if any && !opts.Credentials {
+			w.Header().Add("Access-Control-Allow-Origin", "*")
+		} else {
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if !allowed[origin] {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Access-Control-Allow-Origin", origin)
+			if opts.Credentials {
+				w.Header().Add("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		w.Header().Add("Access-Control-Allow-Methods", methods)
+		w.Header().Add("Access-Control-Allow-Headers", headers)
+		if len(opts.Expose) > 0 {
+			w.Header().Add("Access-Control-Expose-Headers", strings.Join(opts.Expose, ", "))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
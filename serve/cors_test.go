@@ -0,0 +1,94 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCORSCredentials(t *testing.T) {
+	c := DefaultConfig()
+	c.CORS = true
+	c.CORSOrigins = "https://app.example.com, https://admin.example.com"
+	c.CORSCredentials = true
+	c.CORSExpose = "ETag, X-Request-Id"
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	for _, origin := range []string{"https://app.example.com", "https://admin.example.com"} {
+		w := get(h, "/a.txt", "Origin", origin, "Cookie", "session=1")
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != origin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q", origin, got)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+			t.Errorf("%s: got Access-Control-Allow-Credentials %q", origin, got)
+		}
+		if got := w.Header().Get("Access-Control-Expose-Headers"); got != "ETag, X-Request-Id" {
+			t.Errorf("%s: got Access-Control-Expose-Headers %q", origin, got)
+		}
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "Origin") {
+			t.Errorf("%s: got Vary %q", origin, vary)
+		}
+	}
+	for _, origin := range []string{"https://evil.example.com", ""} {
+		w := get(h, "/a.txt", "Origin", origin)
+		if w.Code != http.StatusOK {
+			t.Errorf("%q: got status %d", origin, w.Code)
+		}
+		for _, name := range []string{"Access-Control-Allow-Origin", "Access-Control-Allow-Credentials", "Access-Control-Expose-Headers"} {
+			if got := w.Header().Get(name); got != "" {
+				t.Errorf("%q: got %s %q", origin, name, got)
+			}
+		}
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	c := DefaultConfig()
+	c.CORS = true
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	w := get(h, "/a.txt", "Origin", "https://app.example.com")
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("got Access-Control-Allow-Origin %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("got Access-Control-Allow-Credentials %q", got)
+	}
+}
+
+func TestCORSCredentialsAnyOrigin(t *testing.T) {
+	for _, origins := range []string{"*", "https://app.example.com,*"} {
+		c := DefaultConfig()
+		c.Root = t.TempDir()
+		c.CORS = true
+		c.CORSOrigins = origins
+		c.CORSCredentials = true
+		if _, err := New(c); err == nil {
+			t.Errorf("%s: got no error", origins)
+		}
+	}
+}
+
+func TestCORSFeatureMethods(t *testing.T) {
+	for _, tt := range []struct {
+		proxy    bool
+		allow    string
+		explicit bool
+		want     string
+	}{
+		{false, "GET,HEAD", false, "GET, HEAD"},
+		{true, "GET,HEAD", false, "GET, HEAD, POST, PUT, PATCH, DELETE"},
+		{true, "GET,HEAD,POST", true, "GET, HEAD, POST"},
+	} {
+		c := DefaultConfig()
+		c.CORS = true
+		c.AllowMethods = tt.allow
+		c.AllowMethodsExplicit = tt.explicit
+		if tt.proxy {
+			c.Proxy = []string{"/api=http://127.0.0.1:1"}
+		}
+		h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+		w := get(h, "/a.txt", "Origin", "https://app.example.com")
+		if got := w.Header().Get("Access-Control-Allow-Methods"); got != tt.want {
+			t.Errorf("proxy %t, allow %q (explicit %t): got Access-Control-Allow-Methods %q, want %q", tt.proxy, tt.allow, tt.explicit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package serve
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"filippo.io/age"
+)
+
+// decryptingFS serves the decrypted content of name.age for a name that does
+// not exist. Decrypted content is kept in memory for a TTL.
+type decryptingFS struct {
+	fs         http.FileSystem
+	identities []age.Identity
+	ttl        time.Duration
+	flights    *flightGroup
+	log        *Logger
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+func newDecryptingFS(fs http.FileSystem, identityFile string, ttl time.Duration, flights *flightGroup, log *Logger) (*decryptingFS, error) {
+	f, err := os.Open(identityFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	identities, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingFS{
+		fs:         fs,
+		identities: identities,
+		ttl:        ttl,
+		flights:    flights,
+		log:        log,
+		entries:    map[string]*cacheEntry{},
+	}, nil
+}
+
+func (d *decryptingFS) Open(name string) (http.File, error) {
+	f, err := d.fs.Open(name)
+	if !os.IsNotExist(err) {
+		return f, err
+	}
+	name = path.Clean("/" + name)
+	if e, ok := d.get(name); ok {
+		return newMemFile(e), nil
+	}
+	v, err, _ := d.flights.do(name, func() (interface{}, error) { return d.decrypt(name) })
+	if err != nil {
+		return nil, err
+	}
+	return newMemFile(v.(*cacheEntry)), nil
+}
+
+// decrypt reads and decrypts name.age into a new entry.
+func (d *decryptingFS) decrypt(name string) (*cacheEntry, error) {
+	enc, err := d.fs.Open(name + ".age")
+	if err != nil {
+		return nil, os.ErrNotExist
+	}
+	defer enc.Close()
+	info, err := enc.Stat()
+	if err != nil || info.IsDir() {
+		return nil, os.ErrNotExist
+	}
+	r, err := age.Decrypt(enc, d.identities...)
+	if err != nil {
+		d.log.warnf("decrypt %s.age: %v", name, err)
+		return nil, os.ErrPermission
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		d.log.warnf("decrypt %s.age: %v", name, err)
+		return nil, os.ErrPermission
+	}
+	e := &cacheEntry{
+		name:    name,
+		data:    data,
+		info:    renamedFileInfo{FileInfo: info, name: path.Base(name), size: int64(len(data))},
+		expires: time.Now().Add(d.ttl),
+	}
+	d.put(e)
+	return e, nil
+}
+
+func (d *decryptingFS) get(name string) (*cacheEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e, ok := d.entries[name]
+	return e, ok && time.Now().Before(e.expires)
+}
+
+// put stores e and drops all expired entries, so that plaintext does not stay
+// in memory longer than necessary.
+func (d *decryptingFS) put(e *cacheEntry) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	for name, old := range d.entries {
+		if now.After(old.expires) {
+			delete(d.entries, name)
+		}
+	}
+	if d.ttl > 0 {
+		d.entries[e.name] = e
+	}
+}
+
+// purge drops the decrypted content of the names starting with prefix and
+// returns their number.
+func (d *decryptingFS) purge(prefix string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	n := 0
+	for name := range d.entries {
+		if strings.HasPrefix(name, prefix) {
+			delete(d.entries, name)
+			n++
+		}
+	}
+	return n
+}
+
+// renamedFileInfo overrides the name and size of a os.FileInfo.
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+	size int64
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+func (fi renamedFileInfo) Size() int64 { return fi.size }
@@ -0,0 +1,47 @@
+package serve
+
+import (
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Delay serves requests below one of the path prefixes, or all requests if
+// there are none, after waiting delay plus a random duration of up to jitter.
+// Requests canceled while waiting are not served. It is meant for testing how
+// clients cope with latency.
+func Delay(delay time.Duration, jitter time.Duration, prefixes []string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hasAnyPrefix(r.URL.Path, prefixes) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		d := delay
+		if jitter > 0 {
+			d += time.Duration(rand.Int63n(int64(jitter) + 1))
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+			h.ServeHTTP(w, r)
+		case <-r.Context().Done():
+			logOf(r).debugf("delay of %s %s canceled", r.Method, r.URL)
+		}
+	})
+}
+
+// hasAnyPrefix reports whether s starts with one of the prefixes, which is
+// the case for all s if there are none.
+func hasAnyPrefix(s string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDelay(t *testing.T) {
+	const delay = 50 * time.Millisecond
+	c := DefaultConfig()
+	c.Delay = delay
+	c.DelayJitter = 20 * time.Millisecond
+	c.DelayPath = "/slow/"
+	h := newTestHandler(t, c, map[string]string{"slow/a.txt": "a", "fast.txt": "f"})
+	for i := 0; i < 3; i++ {
+		start := time.Now()
+		if w := get(h, "/slow/a.txt"); w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		if d := time.Since(start); d < delay {
+			t.Errorf("served after %s, want at least %s", d, delay)
+		}
+	}
+	start := time.Now()
+	get(h, "/fast.txt")
+	if d := time.Since(start); d >= delay {
+		t.Errorf("path outside the prefixes delayed by %s", d)
+	}
+}
+
+func TestDelayCanceled(t *testing.T) {
+	served := false
+	h := Delay(time.Hour, 0, nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { served = true }))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	done := make(chan struct{})
+	go func() {
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("canceled request still waiting")
+	}
+	if served {
+		t.Error("canceled request served")
+	}
+}
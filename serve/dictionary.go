@@ -0,0 +1,221 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dictionaryEncodings are the content encodings of Compression Dictionary
+// Transport (RFC 9842) in order of preference, with the file suffix of their
+// sidecars and the magic number preceding the dictionary hash in responses.
+var dictionaryEncodings = []struct {
+	encoding string
+	suffix   string
+	magic    []byte
+}{
+	{"dcb", ".dcb", []byte{0xff, 0x44, 0x43, 0x42}},
+	{"dcz", ".dcz", []byte{0x5e, 0x2a, 0x4d, 0x18, 0x20, 0x00, 0x00, 0x00}},
+}
+
+// compressionDict is a shared compression dictionary.
+type compressionDict struct {
+	content []byte
+	hash    [sha256.Size]byte
+	modTime time.Time
+	// path is where the dictionary is served, with a Use-As-Dictionary
+	// header for the URL pattern match.
+	path  string
+	match string
+}
+
+// loadCompressionDict reads the dictionary file name to be served at
+// dictPath for the URLs matching the URL pattern match.
+func loadCompressionDict(name string, dictPath string, match string) (*compressionDict, error) {
+	if !strings.HasPrefix(dictPath, "/") {
+		return nil, fmt.Errorf("dictionary path must start with /: %s", dictPath)
+	}
+	if !strings.HasPrefix(match, "/") || strings.ContainsAny(match, "\"\\") {
+		return nil, fmt.Errorf("match must be a path pattern starting with /: %s", match)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) == 0 {
+		return nil, errors.New("dictionary is empty")
+	}
+	return &compressionDict{content: content, hash: sha256.Sum256(content), modTime: fi.ModTime(), path: dictPath, match: match}, nil
+}
+
+// available reports whether the Available-Dictionary header, a structured
+// field byte sequence of a SHA-256 hash, names d.
+func (d *compressionDict) available(header string) bool {
+	header = strings.TrimSpace(header)
+	if len(header) < 2 || header[0] != ':' || header[len(header)-1] != ':' {
+		return false
+	}
+	hash, err := base64.StdEncoding.DecodeString(header[1 : len(header)-1])
+	return err == nil && bytes.Equal(hash, d.hash[:])
+}
+
+// CompressionDictionary implements Compression Dictionary Transport (RFC
+// 9842) with sidecar files compressed with the shared dictionary d, e.g.
+// app.json.dcb by "brotli -D dict" or app.json.dcz by "zstd -D dict" for
+// app.json. The dictionary is served at its path with a Use-As-Dictionary
+// header, so that browsers keep it for the URLs of its match pattern. A
+// request for a file that advertises d in Available-Dictionary and accepts
+// dcb or dcz is answered with the sidecar of the preferred encoding,
+// preceded by the magic number and hash the encoding requires. Sidecars
+// older than the dictionary file are ignored, and other requests are passed
+// to h, e.g. to be compressed with plain brotli or gzip. Content type and
+// modification time are those of the original file, as with Precompressed.
+func CompressionDictionary(fs http.FileSystem, d *compressionDict, skipUA *regexp.Regexp, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.URL.Path == d.path {
+			serveCompressionDict(w, r, d)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		orig, err := fs.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer orig.Close()
+		origInfo, err := orig.Stat()
+		if err != nil || origInfo.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding, Available-Dictionary")
+		if skipsCompression(skipUA, w, r) || !d.available(r.Header.Get("Available-Dictionary")) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		accepted := parseQualityList(r.Header.Get("Accept-Encoding"))
+		best := -1
+		bestQ := 0.0
+		for i, de := range dictionaryEncodings {
+			// Only explicitly accepted, a wildcard does not cover them.
+			for _, qv := range accepted {
+				if qv.value == de.encoding && qv.q > bestQ {
+					best, bestQ = i, qv.q
+				}
+			}
+		}
+		if best < 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		de := dictionaryEncodings[best]
+		f, err := fs.Open(name + de.suffix)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil || fi.IsDir() || fi.ModTime().Before(d.modTime) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		if header.Get("Content-Type") == "" {
+			header.Set("Content-Type", contentType(name, orig))
+		}
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", infoETag(fi))
+		}
+		header.Del("Digest")
+		header.Set("Content-Encoding", de.encoding)
+		prefix := append(append([]byte(nil), de.magic...), d.hash[:]...)
+		http.ServeContent(w, r, name, origInfo.ModTime(), &prefixedReader{prefix: prefix, f: f, size: int64(len(prefix)) + fi.Size()})
+	})
+}
+
+// serveCompressionDict serves the dictionary, to be used for its match
+// pattern.
+func serveCompressionDict(w http.ResponseWriter, r *http.Request, d *compressionDict) {
+	header := w.Header()
+	header.Set("Use-As-Dictionary", "match="+strconv.Quote(d.match))
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("ETag", `"`+base64.RawURLEncoding.EncodeToString(d.hash[:])+`"`)
+	if header.Get("Cache-Control") == "" {
+		// Browsers only use dictionaries they may cache.
+		header.Set("Cache-Control", "public, max-age=86400")
+	}
+	http.ServeContent(w, r, path.Base(d.path), d.modTime, bytes.NewReader(d.content))
+}
+
+// prefixedReader reads prefix followed by the content of f, of size bytes in
+// total.
+type prefixedReader struct {
+	prefix []byte
+	f      io.ReadSeeker
+	size   int64
+	// off is the offset of the next Read, fOff that of f.
+	off  int64
+	fOff int64
+}
+
+func (r *prefixedReader) Read(b []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if n := int64(len(r.prefix)); r.off < n {
+		c := copy(b, r.prefix[r.off:])
+		r.off += int64(c)
+		return c, nil
+	}
+	if want := r.off - int64(len(r.prefix)); want != r.fOff {
+		if _, err := r.f.Seek(want, io.SeekStart); err != nil {
+			return 0, err
+		}
+		r.fOff = want
+	}
+	c, err := r.f.Read(b)
+	r.off += int64(c)
+	r.fOff += int64(c)
+	return c, err
+}
+
+func (r *prefixedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, errors.New("seek: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("seek: negative position")
+	}
+	r.off = offset
+	return offset, nil
+}
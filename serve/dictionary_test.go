@@ -0,0 +1,123 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDictTestHandler returns a handler of a dictionary modified at
+// modTime, unless zero, and the hash of the dictionary.
+func newDictTestHandler(t *testing.T, modTime time.Time) (*Handler, [sha256.Size]byte) {
+	t.Helper()
+	dict := filepath.Join(t.TempDir(), "api.dict")
+	if err := ioutil.WriteFile(dict, []byte(`{"id":0,"name":"","email":""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !modTime.IsZero() {
+		if err := os.Chtimes(dict, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := DefaultConfig()
+	c.CompressionDict = dict
+	c.CompressionDictMatch = "/data/*"
+	c.ETag = true
+	h := newTestHandler(t, c, map[string]string{
+		"data/a.json":     `{"id":1,"name":"a","email":"a@example.com"}`,
+		"data/a.json.dcb": "brotli",
+		"data/a.json.dcz": "zstd",
+	})
+	b, _ := ioutil.ReadFile(dict)
+	return h, sha256.Sum256(b)
+}
+
+func TestCompressionDictionaryServed(t *testing.T) {
+	h, hash := newDictTestHandler(t, time.Time{})
+	w := get(h, "/compression.dict")
+	if w.Code != http.StatusOK || w.Body.String() != `{"id":0,"name":"","email":""}` {
+		t.Fatalf("got status %d and body %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Use-As-Dictionary"); got != `match="/data/*"` {
+		t.Errorf("got Use-As-Dictionary %q", got)
+	}
+	if got := w.Header().Get("Cache-Control"); got == "" {
+		t.Error("dictionary is not cacheable")
+	}
+	if got, want := w.Header().Get("ETag"), `"`+base64.RawURLEncoding.EncodeToString(hash[:])+`"`; got != want {
+		t.Errorf("got ETag %q, want %q", got, want)
+	}
+}
+
+func TestCompressionDictionaryNegotiation(t *testing.T) {
+	h, hash := newDictTestHandler(t, time.Time{})
+	available := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+	other := sha256.Sum256([]byte("other"))
+	tests := []struct {
+		name      string
+		available string
+		accept    string
+		encoding  string
+		body      string
+	}{
+		{"dcb preferred", available, "gzip, br, zstd, dcb, dcz", "dcb", "\xff\x44\x43\x42" + string(hash[:]) + "brotli"},
+		{"dcz by quality", available, "dcb;q=0.5, dcz", "dcz", "\x5e\x2a\x4d\x18\x20\x00\x00\x00" + string(hash[:]) + "zstd"},
+		{"other dictionary", ":" + base64.StdEncoding.EncodeToString(other[:]) + ":", "dcb, dcz", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+		{"no dictionary", "", "dcb, dcz", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+		{"malformed", base64.StdEncoding.EncodeToString(hash[:]), "dcb", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+		{"not accepted", available, "gzip, br", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+		{"wildcard", available, "*", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+		{"refused", available, "dcb;q=0, dcz;q=0", "", `{"id":1,"name":"a","email":"a@example.com"}`},
+	}
+	for _, tt := range tests {
+		header := []string{"Accept-Encoding", tt.accept}
+		if tt.available != "" {
+			header = append(header, "Available-Dictionary", tt.available)
+		}
+		w := get(h, "/data/a.json", header...)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", tt.name, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s: got Content-Encoding %q, want %q", tt.name, got, tt.encoding)
+		}
+		if got := w.Body.String(); got != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.name, got, tt.body)
+		}
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "Available-Dictionary") || !strings.Contains(vary, "Accept-Encoding") {
+			t.Errorf("%s: got Vary %q", tt.name, vary)
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+			t.Errorf("%s: got Content-Type %q", tt.name, got)
+		}
+	}
+}
+
+func TestCompressionDictionaryRange(t *testing.T) {
+	h, hash := newDictTestHandler(t, time.Time{})
+	available := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+	w := get(h, "/data/a.json", "Accept-Encoding", "dcb", "Available-Dictionary", available, "Range", "bytes=30-")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if got, want := w.Body.String(), string(hash[26:])+"brotli"; got != want {
+		t.Errorf("got body %q, want %q", got, want)
+	}
+}
+
+func TestCompressionDictionaryStaleSidecar(t *testing.T) {
+	// The sidecars predate a dictionary modified later.
+	h, hash := newDictTestHandler(t, time.Now().Add(time.Hour))
+	available := ":" + base64.StdEncoding.EncodeToString(hash[:]) + ":"
+	w := get(h, "/data/a.json", "Accept-Encoding", "dcb, dcz", "Available-Dictionary", available)
+	if got := w.Header().Get("Content-Encoding"); got != "" || w.Body.String() != `{"id":1,"name":"a","email":"a@example.com"}` {
+		t.Errorf("got Content-Encoding %q and body %q", got, w.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Digest adds a Digest header with the SHA-256 of the served file. Digests
+// are computed by streaming the file once and cached by path, modification
+// time and size; with coalesce, concurrent requests for a file not cached
+// yet share one computation. Directories and range requests are skipped.
+func Digest(fs http.FileSystem, coalesce bool, h http.Handler) http.Handler {
+	return digestHandler(fs, newDigestCache(newFlightGroup(coalesce, nil)), h)
+}
+
+func digestHandler(fs http.FileSystem, c *digestCache, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && r.Header.Get("Range") == "" && !strings.HasSuffix(r.URL.Path, "/") {
+			if d, ok := c.digest(fs, path.Clean("/"+r.URL.Path)); ok {
+				w.Header().Set("Digest", "sha-256="+d)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+type digestEntry struct {
+	modTime time.Time
+	size    int64
+	digest  string
+}
+
+type digestCache struct {
+	flights *flightGroup
+
+	mu      sync.Mutex
+	entries map[string]digestEntry
+}
+
+func newDigestCache(flights *flightGroup) *digestCache {
+	return &digestCache{entries: map[string]digestEntry{}, flights: flights}
+}
+
+// purge drops the digests of the names starting with prefix and returns
+// their number.
+func (c *digestCache) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for name := range c.entries {
+		if strings.HasPrefix(name, prefix) {
+			delete(c.entries, name)
+			n++
+		}
+	}
+	return n
+}
+
+func (c *digestCache) digest(fs http.FileSystem, name string) (string, bool) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return "", false
+	}
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	c.mu.Unlock()
+	if ok && e.modTime.Equal(fi.ModTime()) && e.size == fi.Size() {
+		return e.digest, true
+	}
+	// The key includes the version, so a request for a file that changed
+	// meanwhile does not share the digest of the previous one.
+	key := fmt.Sprintf("%s\x00%d\x00%d", name, fi.ModTime().UnixNano(), fi.Size())
+	v, err, _ := c.flights.do(key, func() (interface{}, error) {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return nil, err
+		}
+		e := digestEntry{
+			modTime: fi.ModTime(),
+			size:    fi.Size(),
+			digest:  base64.StdEncoding.EncodeToString(hash.Sum(nil)),
+		}
+		c.mu.Lock()
+		c.entries[name] = e
+		c.mu.Unlock()
+		return e.digest, nil
+	})
+	if err != nil {
+		return "", false
+	}
+	return v.(string), true
+}
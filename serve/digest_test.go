@@ -0,0 +1,60 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func sha256Digest(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return "sha-256=" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func TestDigest(t *testing.T) {
+	c := DefaultConfig()
+	c.Digest = true
+	h, root := newTestHandlerRoot(t, c, map[string]string{"a.txt": "hello", "d/b.txt": "b"})
+	w := get(h, "/a.txt")
+	if got, want := w.Header().Get("Digest"), sha256Digest("hello"); got != want {
+		t.Errorf("got Digest %q, want %q", got, want)
+	}
+	for _, tt := range []struct {
+		name   string
+		path   string
+		header []string
+	}{
+		{"range", "/a.txt", []string{"Range", "bytes=0-1"}},
+		{"listing", "/d/", nil},
+		{"missing", "/missing.txt", nil},
+	} {
+		if got := get(h, tt.path, tt.header...).Header().Get("Digest"); got != "" {
+			t.Errorf("%s: got Digest %q", tt.name, got)
+		}
+	}
+
+	// A changed file gets a new digest.
+	writeFiles(t, root, map[string]string{"a.txt": "changed"})
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(root, "a.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := get(h, "/a.txt").Header().Get("Digest"), sha256Digest("changed"); got != want {
+		t.Errorf("changed: got Digest %q, want %q", got, want)
+	}
+}
+
+func TestDigestHead(t *testing.T) {
+	c := DefaultConfig()
+	c.Digest = true
+	h := newTestHandler(t, c, map[string]string{"a.txt": "hello"})
+	r := newRequest(t, "/a.txt")
+	r.Method = http.MethodHead
+	if got, want := serveRequest(h, r).Header().Get("Digest"), sha256Digest("hello"); got != want {
+		t.Errorf("got Digest %q, want %q", got, want)
+	}
+}
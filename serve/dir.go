@@ -0,0 +1,111 @@
+package serve
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DirectoryFallback handles requests for directories that have no index.html.
+// If page is set the request is internally rewritten to serve that page,
+// otherwise, if listing is disabled, the request is answered with 404.
+func DirectoryFallback(fs http.FileSystem, page string, listing bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") || !isDirWithoutIndex(fs, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		switch {
+		case page != "":
+			r2 := r.Clone(r.Context())
+			r2.URL.Path = page
+			h.ServeHTTP(w, r2)
+		case !listingEnabled(r, listing):
+			httpError(w, r, "404 page not found", http.StatusNotFound)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+func isDirWithoutIndex(fs http.FileSystem, name string) bool {
+	name = path.Clean("/" + name)
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.IsDir() {
+		return false
+	}
+	index, err := fs.Open(path.Join(name, "index.html"))
+	if err != nil {
+		return true
+	}
+	index.Close()
+	return false
+}
+
+// NoRedirect suppresses the canonicalizing redirects of http.FileServer and
+// serves the redirect target directly instead. The affected redirects are:
+//
+//	/dir            -> /dir/
+//	/dir/index.html -> /dir/
+//	/file/          -> /file
+//
+// Requests are rewritten up front, so that redirects of other handlers, e.g.
+// of proxied upstreams, pass unchanged. Note that relative links in
+// index.html files of directories requested without a trailing slash resolve
+// against the parent directory.
+func NoRedirect(fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		switch {
+		case strings.HasSuffix(p, "/index.html"):
+			p = strings.TrimSuffix(p, "index.html")
+		case strings.HasSuffix(p, "/"):
+			if p != "/" && !isDir(fs, p) {
+				p = strings.TrimRight(p, "/")
+			}
+		case isDir(fs, p):
+			p += "/"
+		}
+		if p == r.URL.Path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = p
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// NoDirRedirect serves directories requested without a trailing slash as if
+// it was present, instead of redirecting to it. Listings are rendered with a
+// base URL, so that their links resolve, but relative links in index.html
+// files resolve against the parent directory.
+func NoDirRedirect(fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") || !isDir(fs, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path += "/"
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// isDir reports whether name is a directory of fs.
+func isDir(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(path.Clean("/" + name))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	return err == nil && fi.IsDir()
+}
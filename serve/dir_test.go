@@ -0,0 +1,80 @@
+package serve
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNoRedirect(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/api/moved", http.StatusMovedPermanently)
+	}))
+	defer upstream.Close()
+	c := DefaultConfig()
+	c.NoRedirect = true
+	c.Proxy = []string{"/api=" + upstream.URL}
+	h := newTestHandler(t, c, map[string]string{
+		"dir/index.html": "index",
+		"file.txt":       "file",
+	})
+	tests := []struct {
+		path string
+		body string
+	}{
+		{"/dir", "index"},
+		{"/dir/", "index"},
+		{"/dir/index.html", "index"},
+		{"/file.txt/", "file"},
+		{"/file.txt", "file"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("%s: got status %d and body %q, want %q", tt.path, w.Code, w.Body, tt.body)
+		}
+	}
+	w := get(h, "/api/old")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/api/moved" {
+		t.Errorf("proxied redirect: got status %d and Location %q", w.Code, w.Header().Get("Location"))
+	}
+}
+
+func TestNoDirRedirect(t *testing.T) {
+	files := map[string]string{
+		"dir/index.html": "index",
+		"list/a.txt":     "a",
+		"file.txt":       "file",
+	}
+	h := newTestHandler(t, DefaultConfig(), files)
+	if w := get(h, "/dir"); w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "dir/" {
+		t.Errorf("redirecting: got status %d and Location %q", w.Code, w.Header().Get("Location"))
+	}
+	c := DefaultConfig()
+	c.NoDirRedirect = true
+	h = newTestHandler(t, c, files)
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/dir", http.StatusOK, "index"},
+		{"/dir/", http.StatusOK, "index"},
+		{"/file.txt", http.StatusOK, "file"},
+		{"/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != tt.status || tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: got status %d and body %q", tt.path, w.Code, w.Body)
+		}
+	}
+	w := get(h, "/list", "Accept", "text/html")
+	if w.Code != http.StatusOK {
+		t.Fatalf("listing: got status %d", w.Code)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `<base href="/list/">`) || !strings.Contains(body, `href="a.txt"`) {
+		t.Errorf("listing: links do not resolve below /list/: %s", body)
+	}
+}
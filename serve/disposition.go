@@ -0,0 +1,41 @@
+package serve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contentDisposition returns a Content-Disposition header value making
+// clients download the response as a file named name. Clients supporting
+// RFC 5987 use the UTF-8 filename*, others the filename with non-ASCII
+// characters replaced.
+func contentDisposition(name string) string {
+	ascii := strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' || r == '"' || r == '\\' {
+			return '_'
+		}
+		return r
+	}, name)
+	if ascii == name {
+		return fmt.Sprintf(`attachment; filename="%s"`, name)
+	}
+	return fmt.Sprintf(`attachment; filename="%s"; filename*=UTF-8''%s`, ascii, encodeRFC5987(name))
+}
+
+// encodeRFC5987 percent-encodes all bytes of s but the attr-chars of RFC
+// 5987.
+func encodeRFC5987(s string) string {
+	const hex = "0123456789ABCDEF"
+	b := &strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('%')
+		b.WriteByte(hex[c>>4])
+		b.WriteByte(hex[c&15])
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package serve
+
+import (
+	"mime"
+	"net/http"
+	"testing"
+)
+
+func TestContentDisposition(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.zip", `attachment; filename="report.zip"`},
+		{"my report.zip", `attachment; filename="my report.zip"`},
+		{"café.zip", `attachment; filename="caf_.zip"; filename*=UTF-8''caf%C3%A9.zip`},
+		{"日本.zip", `attachment; filename="__.zip"; filename*=UTF-8''%E6%97%A5%E6%9C%AC.zip`},
+		{`a"b\c.zip`, `attachment; filename="a_b_c.zip"; filename*=UTF-8''a%22b%5Cc.zip`},
+		{"50% off;x=1.zip", `attachment; filename="50% off;x=1.zip"`},
+	}
+	for _, tt := range tests {
+		got := contentDisposition(tt.name)
+		if got != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.name, got, tt.want)
+		}
+		// Clients decode the original name.
+		_, params, err := mime.ParseMediaType(got)
+		if err != nil {
+			t.Errorf("%q: %v", tt.name, err)
+			continue
+		}
+		if params["filename"] != tt.name {
+			t.Errorf("%q: decoded %q", tt.name, params["filename"])
+		}
+	}
+}
+
+func TestArchiveContentDisposition(t *testing.T) {
+	c := DefaultConfig()
+	c.ZipDownload = true
+	h := newTestHandler(t, c, map[string]string{"Fotos über/a.txt": "a"})
+	w := serveRequest(h, newRequest(t, "/Fotos%20%C3%BCber/?download=zip"))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	_, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := params["filename"]; got != "Fotos über.zip" {
+		t.Errorf("got filename %q", got)
+	}
+}
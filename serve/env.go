@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"context"
+	"html/template"
+	"net/http"
+)
+
+// handlerEnv is the configuration of a Handler that the middleware reaches
+// through the request context, so that Handlers in one process, e.g. of
+// embedding programs, do not share it.
+type handlerEnv struct {
+	log *Logger
+	// jsonErrors makes error responses JSON for clients preferring JSON
+	// over HTML.
+	jsonErrors bool
+	// retryPages are HTML templates of responses telling clients to retry
+	// later by status code.
+	retryPages map[int]*template.Template
+}
+
+type handlerEnvKey struct{}
+
+// defaultEnv applies to requests that did not pass through withEnv.
+var defaultEnv = &handlerEnv{}
+
+// withEnv makes env available to h through envOf.
+func withEnv(env *handlerEnv, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), handlerEnvKey{}, env)))
+	})
+}
+
+// envOf returns the configuration of the Handler serving r.
+func envOf(r *http.Request) *handlerEnv {
+	if env, ok := r.Context().Value(handlerEnvKey{}).(*handlerEnv); ok {
+		return env
+	}
+	return defaultEnv
+}
+
+// logOf returns the logger of the Handler serving r.
+func logOf(r *http.Request) *Logger { return envOf(r).log }
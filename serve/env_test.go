@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHandlersDoNotShareErrorFormat(t *testing.T) {
+	c := DefaultConfig()
+	c.ErrorFormat = "json"
+	jsonHandler := newTestHandler(t, c, nil)
+	textHandler := newTestHandler(t, DefaultConfig(), nil)
+	w := get(jsonHandler, "/missing", "Accept", "application/json")
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("json: got status %d and Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	w = get(textHandler, "/missing", "Accept", "application/json")
+	if w.Code != http.StatusNotFound || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("text: got status %d and Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+}
+
+func TestHandlersDoNotShareRetryPages(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "503.html")
+	if err := ioutil.WriteFile(page, []byte("<p>back in {{.RetryAfter}}s</p>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := DefaultConfig()
+	c.Page503 = page
+	if _, err := New(c); err != nil {
+		t.Fatal(err)
+	}
+	// Neither handler is ready, so both answer 503.
+	h, err := New(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := get(h, "/")
+	if w.Code != http.StatusServiceUnavailable || strings.Contains(w.Body.String(), "back in") {
+		t.Errorf("got status %d and body %q", w.Code, w.Body)
+	}
+}
+
+func TestNewClosesLogFilesOnError(t *testing.T) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files cannot be counted")
+	}
+	before := len(fds)
+	c := DefaultConfig()
+	c.LogFiles = []string{filepath.Join(t.TempDir(), "access.log")}
+	c.ErrorFormat = "xml"
+	if _, err := New(c); err == nil {
+		t.Fatal("got no error for an unknown error format")
+	}
+	fds, _ = ioutil.ReadDir("/proc/self/fd")
+	if len(fds) != before {
+		t.Errorf("got %d open files, want %d", len(fds), before)
+	}
+	if _, err := os.Stat(c.LogFiles[0]); err != nil {
+		t.Fatal(err)
+	}
+}
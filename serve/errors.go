@@ -0,0 +1,124 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// parseRetryPage parses the HTML template file of a retry page.
+func parseRetryPage(file string) (*template.Template, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(file).Parse(string(b))
+}
+
+// retryError replies to r with the status code and a Retry-After header. The
+// body is JSON for clients preferring it if enabled, the page configured for
+// the code or the status text.
+func retryError(w http.ResponseWriter, r *http.Request, code int, retryAfter int) {
+	retryErrorPage(w, r, code, retryAfter, envOf(r).retryPages[code])
+}
+
+// retryErrorPage is retryError with the page t, the status text is served if
+// t is nil.
+func retryErrorPage(w http.ResponseWriter, r *http.Request, code int, retryAfter int, t *template.Template) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	msg := http.StatusText(code)
+	if envOf(r).jsonErrors && prefersJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Error      string `json:"error"`
+			Status     int    `json:"status"`
+			RetryAfter int    `json:"retryAfter"`
+		}{msg, code, retryAfter})
+		return
+	}
+	if t == nil {
+		http.Error(w, msg, code)
+		return
+	}
+	b := &bytes.Buffer{}
+	if err := t.Execute(b, struct {
+		Status     int
+		RetryAfter int
+	}{code, retryAfter}); err != nil {
+		logOf(r).errorf("execute %d page: %v", code, err)
+		http.Error(w, msg, code)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(code)
+	w.Write(b.Bytes())
+}
+
+// httpError replies to r with the error message and status code, as JSON if
+// enabled and preferred by the client.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, code int) {
+	if !envOf(r).jsonErrors || !prefersJSON(r) {
+		http.Error(w, msg, code)
+		return
+	}
+	writeJSONError(w, msg, code)
+}
+
+func writeJSONError(w http.ResponseWriter, msg string, code int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error  string `json:"error"`
+		Status int    `json:"status"`
+	}{msg, code})
+}
+
+// prefersJSON reports whether the client accepts application/json with a
+// higher quality than text/html.
+func prefersJSON(r *http.Request) bool {
+	qvs := parseQualityList(r.Header.Get("Accept"))
+	return accepts(qvs, "application/json") > accepts(qvs, "text/html")
+}
+
+// JSONErrors replaces the plain text error responses of h, like the file
+// server's "404 page not found", with JSON for clients preferring it.
+func JSONErrors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !prefersJSON(r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(withOptional(&errorInterceptor{ResponseWriter: w}, w), r)
+	})
+}
+
+// errorInterceptor writes a JSON error instead of plain text error
+// responses and discards their body.
+type errorInterceptor struct {
+	http.ResponseWriter
+	intercepted bool
+}
+
+func (w *errorInterceptor) WriteHeader(code int) {
+	if code >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.intercepted = true
+		writeJSONError(w.ResponseWriter, http.StatusText(code), code)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorInterceptor) Write(b []byte) (int, error) {
+	if w.intercepted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,106 @@
+package serve
+
+import (
+	"encoding/json"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newUnreadyHandler returns a handler answering with 503 until it is ready,
+// with the 503 page if not empty.
+func newUnreadyHandler(t *testing.T, errorFormat string, page string) *Handler {
+	t.Helper()
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.ErrorFormat = errorFormat
+	if page != "" {
+		c.Page503 = filepath.Join(t.TempDir(), "503.html")
+		if err := ioutil.WriteFile(c.Page503, []byte(page), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	return h
+}
+
+func TestRetryPage(t *testing.T) {
+	h := newUnreadyHandler(t, "json", "<p>{{.Status}}: retry in {{.RetryAfter}}s</p>")
+	w := get(h, "/", "Accept", "text/html")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Fatalf("got status %d and Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	if got := w.Body.String(); got != "<p>503: retry in 5s</p>" {
+		t.Errorf("got body %q", got)
+	}
+}
+
+func TestRetryPageJSON(t *testing.T) {
+	h := newUnreadyHandler(t, "json", "<p>retry in {{.RetryAfter}}s</p>")
+	w := get(h, "/", "Accept", "application/json")
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Fatalf("got status %d and Retry-After %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	var e struct {
+		Error      string `json:"error"`
+		Status     int    `json:"status"`
+		RetryAfter int    `json:"retryAfter"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	if e.Error != "Service Unavailable" || e.Status != 503 || e.RetryAfter != 5 {
+		t.Errorf("got %+v", e)
+	}
+}
+
+func TestRetryPageDefault(t *testing.T) {
+	// Without JSON errors, JSON clients get the page too.
+	for _, tt := range []struct {
+		page string
+		body string
+	}{
+		{"", "Service Unavailable\n"},
+		{"<p>retry in {{.RetryAfter}}s</p>", "<p>retry in 5s</p>"},
+		{"{{.Missing}}", "Service Unavailable\n"},
+	} {
+		h := newUnreadyHandler(t, "text", tt.page)
+		w := get(h, "/", "Accept", "application/json")
+		if w.Code != http.StatusServiceUnavailable || w.Body.String() != tt.body {
+			t.Errorf("%q: got status %d and body %q, want %q", tt.page, w.Code, w.Body, tt.body)
+		}
+	}
+}
+
+func TestRetryPage429(t *testing.T) {
+	env := &handlerEnv{
+		jsonErrors: true,
+		retryPages: map[int]*template.Template{
+			http.StatusTooManyRequests: template.Must(template.New("429").Parse("slow down for {{.RetryAfter}}s")),
+		},
+	}
+	h := withEnv(env, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		retryError(w, r, http.StatusTooManyRequests, 30)
+	}))
+	w := get(h, "/", "Accept", "text/html")
+	if w.Code != http.StatusTooManyRequests || w.Body.String() != "slow down for 30s" {
+		t.Errorf("html: got status %d and body %q", w.Code, w.Body)
+	}
+	w = get(h, "/", "Accept", "application/json")
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), `"retryAfter":30`) {
+		t.Errorf("json: got status %d and body %q", w.Code, w.Body)
+	}
+}
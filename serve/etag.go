@@ -0,0 +1,50 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// ETag adds a strong ETag derived from the modification time and the size of
+// served files. Since http.ServeContent evaluates conditional headers against
+// it, this enables If-None-Match, If-Match and If-Range with entity tags, so
+// that resumed downloads receive the full content if the file changed.
+func ETag(fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == http.MethodGet || r.Method == http.MethodHead) && !strings.HasSuffix(r.URL.Path, "/") {
+			if etag, ok := fileETag(fs, path.Clean("/"+r.URL.Path)); ok {
+				w.Header().Set("ETag", etag)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func fileETag(fs http.FileSystem, name string) (string, bool) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return "", false
+	}
+	return infoETag(fi), true
+}
+
+// infoETag returns the strong ETag of a file.
+func infoETag(fi os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
+
+// weakenETag turns a strong ETag of header into a weak one, as needed when
+// the content is transformed, e.g. compressed.
+func weakenETag(header http.Header) {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		header.Set("ETag", "W/"+etag)
+	}
+}
@@ -0,0 +1,53 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIfRange(t *testing.T) {
+	c := DefaultConfig()
+	c.ETag = true
+	c.GZIP = true
+	body := strings.Repeat("0123456789", 100)
+	h := newTestHandler(t, c, map[string]string{"a.bin": body})
+	full := get(h, "/a.bin")
+	etag := full.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("got ETag %q, want a strong one", etag)
+	}
+	lastModified := full.Header().Get("Last-Modified")
+	modTime, err := http.ParseTime(lastModified)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		ifRange string
+		status  int
+	}{
+		{"matching ETag", etag, http.StatusPartialContent},
+		{"other ETag", `"other"`, http.StatusOK},
+		{"weak ETag", "W/" + etag, http.StatusOK},
+		{"matching date", lastModified, http.StatusPartialContent},
+		{"older date", modTime.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+	for _, tt := range tests {
+		for _, accept := range []string{"", "gzip"} {
+			w := get(h, "/a.bin", "Range", "bytes=10-19", "If-Range", tt.ifRange, "Accept-Encoding", accept)
+			if w.Code != tt.status {
+				t.Errorf("%s with %q: got status %d, want %d", tt.name, accept, w.Code, tt.status)
+				continue
+			}
+			want := body
+			if tt.status == http.StatusPartialContent {
+				want = "0123456789"
+			}
+			if w.Header().Get("Content-Encoding") != "" || w.Body.String() != want {
+				t.Errorf("%s with %q: got Content-Encoding %q and %d bytes", tt.name, accept, w.Header().Get("Content-Encoding"), w.Body.Len())
+			}
+		}
+	}
+}
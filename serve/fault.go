@@ -0,0 +1,62 @@
+package serve
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// faultRule answers a fraction of the requests below prefix with status.
+type faultRule struct {
+	prefix      string
+	status      int
+	probability float64
+}
+
+// parseFaultRules parses rules of the form status:probability, optionally
+// prefixed by a path prefix as in /api:503:0.2.
+func parseFaultRules(specs []string) ([]faultRule, error) {
+	var rules []faultRule
+	for _, spec := range specs {
+		parts := strings.Split(spec, ":")
+		rule := faultRule{prefix: "/"}
+		if len(parts) == 3 && strings.HasPrefix(parts[0], "/") {
+			rule.prefix, parts = parts[0], parts[1:]
+		}
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid fault rule: %s", spec)
+		}
+		status, err := strconv.Atoi(parts[0])
+		if err != nil || status < 400 || status > 599 {
+			return nil, fmt.Errorf("invalid fault status: %s", parts[0])
+		}
+		p, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || p < 0 || p > 1 {
+			return nil, fmt.Errorf("invalid fault probability: %s", parts[1])
+		}
+		rule.status, rule.probability = status, p
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Fault answers requests matching a rule with its status at the rule's
+// probability, to test how clients handle errors. Injected faults carry an
+// X-Serve-Fault header and are logged as such, so they are not mistaken for
+// real errors.
+func Fault(rules []faultRule, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			if !strings.HasPrefix(r.URL.Path, rule.prefix) || rand.Float64() >= rule.probability {
+				continue
+			}
+			logOf(r).infof("injected fault %d for %s %s", rule.status, r.Method, r.URL)
+			w.Header().Set("X-Serve-Fault", "true")
+			httpError(w, r, http.StatusText(rule.status), rule.status)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
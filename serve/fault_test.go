@@ -0,0 +1,62 @@
+package serve
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseFaultRules(t *testing.T) {
+	rules, err := parseFaultRules([]string{"500:0.1", "/api:503:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []faultRule{{"/", 500, 0.1}, {"/api", 503, 1}}
+	if len(rules) != len(want) || rules[0] != want[0] || rules[1] != want[1] {
+		t.Errorf("got %+v, want %+v", rules, want)
+	}
+	for _, spec := range []string{"500", "500:", "200:0.1", "600:0.1", "500:1.5", "500:-0.1", "api:500:0.1", "/api:500:0.1:x"} {
+		if _, err := parseFaultRules([]string{spec}); err == nil {
+			t.Errorf("%s: got no error", spec)
+		}
+	}
+}
+
+func TestFaultRate(t *testing.T) {
+	const n = 10000
+	rules, err := parseFaultRules([]string{"/api:503:0.5", "500:0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Fault(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		path string
+		want map[int]float64
+	}{
+		{"/a.txt", map[int]float64{500: 0.1, 200: 0.9}},
+		// The 500 rule applies to the requests the first one lets through.
+		{"/api/x", map[int]float64{503: 0.5, 500: 0.05, 200: 0.45}},
+	}
+	for _, tt := range tests {
+		counts := map[int]int{}
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			counts[w.Code]++
+			if fault := w.Header().Get("X-Serve-Fault"); (w.Code != http.StatusOK) != (fault == "true") {
+				t.Fatalf("%s: got status %d with X-Serve-Fault %q", tt.path, w.Code, fault)
+			}
+		}
+		for code, p := range tt.want {
+			// Allow five standard deviations of the binomial distribution.
+			tolerance := 5 * math.Sqrt(n*p*(1-p))
+			if got := float64(counts[code]); math.Abs(got-n*p) > tolerance {
+				t.Errorf("%s: got %d of %d with status %d, want about %.0f", tt.path, counts[code], n, code, n*p)
+			}
+		}
+		if len(counts) != len(tt.want) {
+			t.Errorf("%s: got statuses %v", tt.path, counts)
+		}
+	}
+}
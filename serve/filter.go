@@ -0,0 +1,120 @@
+package serve
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// maxSizeFS refuses to open files larger than max and omits them from
+// directory listings.
+type maxSizeFS struct {
+	fs  http.FileSystem
+	max int64
+}
+
+func (m maxSizeFS) Open(name string) (http.File, error) {
+	f, err := m.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.IsDir() {
+		if !m.keep(fi) {
+			f.Close()
+			return nil, os.ErrPermission
+		}
+		return f, nil
+	}
+	return &filteredDir{File: f, keep: m.keep}, nil
+}
+
+func (m maxSizeFS) keep(fi os.FileInfo) bool {
+	return fi.IsDir() || fi.Size() <= m.max
+}
+
+// hiddenFS hides the files and directories from fs for whose cleaned path
+// hidden returns true.
+type hiddenFS struct {
+	fs     http.FileSystem
+	hidden func(name string) bool
+}
+
+func (hfs hiddenFS) Open(name string) (http.File, error) {
+	name = path.Clean("/" + name)
+	if hfs.hidden(name) {
+		return nil, os.ErrNotExist
+	}
+	f, err := hfs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return f, nil
+	}
+	keep := func(fi os.FileInfo) bool { return !hfs.hidden(path.Join(name, fi.Name())) }
+	return &filteredDir{File: f, keep: keep}, nil
+}
+
+// ignoreMatcher reports whether a name or any of its parent directories
+// matches one of the glob patterns. Patterns without a slash match a base
+// name, e.g. *.map or node_modules, patterns with one the path relative to
+// the root, e.g. build/*.tmp.
+func ignoreMatcher(patterns []string) (func(name string) bool, error) {
+	for _, p := range patterns {
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %s: %w", p, err)
+		}
+	}
+	return func(name string) bool {
+		for ; name != "/"; name = path.Dir(name) {
+			for _, p := range patterns {
+				subject := path.Base(name)
+				if strings.Contains(p, "/") {
+					subject = strings.TrimPrefix(name, "/")
+				}
+				if ok, _ := path.Match(strings.TrimPrefix(p, "/"), subject); ok {
+					return true
+				}
+			}
+		}
+		return false
+	}, nil
+}
+
+// filteredDir is a directory that omits entries from Readdir that keep
+// rejects.
+type filteredDir struct {
+	http.File
+	keep func(fi os.FileInfo) bool
+}
+
+func (d *filteredDir) Readdir(count int) ([]os.FileInfo, error) {
+	var kept []os.FileInfo
+	for {
+		infos, err := d.File.Readdir(count)
+		for _, fi := range infos {
+			if d.keep(fi) {
+				kept = append(kept, fi)
+			}
+		}
+		if count <= 0 || len(kept) > 0 || err != nil {
+			if err == io.EOF && len(kept) > 0 {
+				err = nil
+			}
+			return kept, err
+		}
+	}
+}
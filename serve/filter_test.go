@@ -0,0 +1,79 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMaxFileSize(t *testing.T) {
+	c := DefaultConfig()
+	c.MaxFileSize = 10
+	h := newTestHandler(t, c, map[string]string{
+		"d/under.txt": strings.Repeat("u", 10),
+		"d/over.txt":  strings.Repeat("o", 11),
+	})
+	if w := get(h, "/d/under.txt"); w.Code != http.StatusOK || w.Body.Len() != 10 {
+		t.Errorf("just under: got status %d and %d bytes", w.Code, w.Body.Len())
+	}
+	if w := get(h, "/d/over.txt"); w.Code != http.StatusForbidden {
+		t.Errorf("just over: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if got := strings.Join(listingNames(t, h, "/d/"), ","); got != "under.txt" {
+		t.Errorf("got listing %s", got)
+	}
+	if body := get(h, "/d/").Body.String(); strings.Contains(body, "over.txt") {
+		t.Errorf("HTML listing shows the oversized file")
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	c := DefaultConfig()
+	c.Ignore = []string{"*.map", "node_modules", "build/*.tmp"}
+	h := newTestHandler(t, c, map[string]string{
+		"app.js":                    "app",
+		"app.js.map":                "map",
+		"node_modules/x/index.js":   "dep",
+		"build/out.js":              "out",
+		"build/cache.tmp":           "tmp",
+		"src/cache.tmp":             "src tmp",
+		"src/node_modules/index.js": "nested dep",
+	})
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/app.js", http.StatusOK},
+		{"/app.js.map", http.StatusNotFound},
+		{"/node_modules/", http.StatusNotFound},
+		{"/node_modules/x/index.js", http.StatusNotFound},
+		{"/src/node_modules/index.js", http.StatusNotFound},
+		{"/build/out.js", http.StatusOK},
+		{"/build/cache.tmp", http.StatusNotFound},
+		{"/build/../build/cache.tmp", http.StatusNotFound},
+		{"/src/cache.tmp", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := get(h, tt.path); w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+	for target, want := range map[string]string{
+		"/":       "app.js,build/,src/",
+		"/build/": "out.js",
+		"/src/":   "cache.tmp",
+	} {
+		if got := strings.Join(listingNames(t, h, target), ","); got != want {
+			t.Errorf("%s: got listing %s, want %s", target, got, want)
+		}
+	}
+}
+
+func TestIgnoreInvalid(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.Ignore = []string{"[a-"}
+	if _, err := New(c); err == nil {
+		t.Error("got no error")
+	}
+}
@@ -0,0 +1,49 @@
+package serve
+
+import (
+	"expvar"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// flightGroup coalesces concurrent calls for the same key into one, so a
+// burst of requests for a file that is not cached yet reads, decrypts or
+// hashes it once, and one for a directory archive builds it once. Results
+// are shared only with the calls waiting for them and never kept, so a
+// failed call is retried by the next request. A nil group runs every call.
+type flightGroup struct {
+	// coalesced counts the calls that waited for another one, if not nil.
+	coalesced *expvar.Int
+	g         singleflight.Group
+}
+
+// newFlightGroup returns a group counting coalesced calls in coalesced, which
+// may be nil, or nil if coalescing is disabled.
+func newFlightGroup(enabled bool, coalesced *expvar.Int) *flightGroup {
+	if !enabled {
+		return nil
+	}
+	return &flightGroup{coalesced: coalesced}
+}
+
+// do calls fn unless a call for key is in progress, in which case it waits
+// for that call and returns its result. shared reports whether the result
+// was produced by another call. fn must not depend on the request of the
+// caller, e.g. on its context, since waiters of other requests share it, so
+// that a client going away does not fail the others. If fn panics, so do the
+// waiters.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	if g == nil {
+		v, err = fn()
+		return v, err, false
+	}
+	called := false
+	v, err, _ = g.g.Do(key, func() (interface{}, error) {
+		called = true
+		return fn()
+	})
+	if !called && g.coalesced != nil {
+		g.coalesced.Add(1)
+	}
+	return v, err, !called
+}
@@ -0,0 +1,123 @@
+package serve
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// gatedFS counts the opens of name and blocks them until gate is closed.
+type gatedFS struct {
+	http.FileSystem
+	name  string
+	gate  chan struct{}
+	opens int32
+}
+
+func (fs *gatedFS) Open(name string) (http.File, error) {
+	if name == fs.name {
+		atomic.AddInt32(&fs.opens, 1)
+		<-fs.gate
+	}
+	return fs.FileSystem.Open(name)
+}
+
+func TestFlightGroupCoalesces(t *testing.T) {
+	const n = 20
+	var coalesced expvar.Int
+	g := newFlightGroup(true, &coalesced)
+	gate := make(chan struct{})
+	var calls int32
+	var wg sync.WaitGroup
+	results := make([]interface{}, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _, _ = g.do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-gate
+				return "value", nil
+			})
+		}(i)
+	}
+	// Let the calls join the first one before it finishes.
+	time.Sleep(50 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("got %d calls, want 1", calls)
+	}
+	if got := coalesced.Value(); got != n-1 {
+		t.Errorf("got %d coalesced, want %d", got, n-1)
+	}
+	for i, v := range results {
+		if v != "value" {
+			t.Errorf("call %d: got %v", i, v)
+		}
+	}
+}
+
+func TestFlightGroupErrorNotKept(t *testing.T) {
+	g := newFlightGroup(true, nil)
+	if _, err, _ := g.do("key", func() (interface{}, error) { return nil, errors.New("failed") }); err == nil {
+		t.Fatal("got no error")
+	}
+	v, err, shared := g.do("key", func() (interface{}, error) { return "value", nil })
+	if err != nil || v != "value" || shared {
+		t.Errorf("got %v, %v, shared %v after a failed call", v, err, shared)
+	}
+}
+
+func TestArchiveCoalesced(t *testing.T) {
+	const n = 10
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"d/a.txt": "a", "d/b.txt": "b"})
+	fs := &gatedFS{FileSystem: http.Dir(dir), name: "/d/a.txt", gate: make(chan struct{})}
+	var coalesced expvar.Int
+	h := archiveHandler(fs, false, newFlightGroup(true, &coalesced), http.NotFoundHandler())
+	var wg sync.WaitGroup
+	bodies := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = serveRequest(h, newRequest(t, "/d/?download=zip")).Body.Bytes()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(fs.gate)
+	wg.Wait()
+	if fs.opens != 1 {
+		t.Errorf("got %d builds, want 1", fs.opens)
+	}
+	if got := coalesced.Value(); got != n-1 {
+		t.Errorf("got %d coalesced, want %d", got, n-1)
+	}
+	for i, b := range bodies {
+		zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		got := map[string]string{}
+		for _, zf := range zr.File {
+			f, err := zf.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, _ := ioutil.ReadAll(f)
+			f.Close()
+			got[zf.Name] = string(content)
+		}
+		if len(got) != 2 || got["d/a.txt"] != "a" || got["d/b.txt"] != "b" {
+			t.Errorf("request %d: got files %v", i, got)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package serve
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// caseFoldFS resolves names missing from fs case-insensitively, and without
+// a single trailing dot, as servers on case-insensitive file systems do. The
+// directory entries are indexed by their lower case names when first needed
+// and reindexed once the modification time of the directory changes.
+type caseFoldFS struct {
+	fs http.FileSystem
+
+	mu      sync.Mutex
+	indexes map[string]*foldIndex
+}
+
+type foldIndex struct {
+	modTime time.Time
+	// names maps lower case names to the names of the entries. Of names
+	// differing only in case, the lexically smallest is kept.
+	names map[string]string
+}
+
+func newCaseFoldFS(fs http.FileSystem) *caseFoldFS {
+	return &caseFoldFS{fs: fs, indexes: map[string]*foldIndex{}}
+}
+
+func (c *caseFoldFS) Open(name string) (http.File, error) {
+	f, err := c.fs.Open(name)
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	if resolved, ok := c.resolveMissing(path.Clean("/" + name)); ok {
+		return c.fs.Open(resolved)
+	}
+	return nil, err
+}
+
+// lookup returns the name of fs that name is opened as, and whether it
+// exists.
+func (c *caseFoldFS) lookup(name string) (string, bool) {
+	name = path.Clean("/" + name)
+	if exists(c.fs, name) {
+		return name, true
+	}
+	return c.resolveMissing(name)
+}
+
+// resolveMissing returns the name of the entry matching the missing name
+// ignoring case, or, if name ends with a dot, matching it without the dot
+// as is or ignoring case.
+func (c *caseFoldFS) resolveMissing(name string) (string, bool) {
+	if resolved, ok := c.resolve(name); ok {
+		return resolved, true
+	}
+	trimmed := strings.TrimSuffix(name, ".")
+	if trimmed == name {
+		return "", false
+	}
+	if exists(c.fs, trimmed) {
+		return trimmed, true
+	}
+	return c.resolve(trimmed)
+}
+
+// exists reports whether name can be opened or fails for another reason
+// than not existing.
+func exists(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(name)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	f.Close()
+	return true
+}
+
+// CaseFold rewrites request paths to the names fs resolves them to, so that
+// the middleware matching paths, such as auth realms and directory rules,
+// sees the name of the file that is served rather than a differently cased
+// alias of it.
+func CaseFold(fs *caseFoldFS, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		resolved, ok := fs.lookup(name)
+		if !ok || resolved == name {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, "/") && resolved != "/" {
+			resolved += "/"
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = resolved
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// resolve returns the name of the entry matching name case-insensitively.
+func (c *caseFoldFS) resolve(name string) (string, bool) {
+	resolved := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(name, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		names, ok := c.index(resolved)
+		if !ok {
+			return "", false
+		}
+		actual, ok := names[strings.ToLower(part)]
+		if !ok {
+			return "", false
+		}
+		resolved = path.Join(resolved, actual)
+	}
+	return resolved, true
+}
+
+// index returns the lower case names of the entries of the directory dir.
+func (c *caseFoldFS) index(dir string) (map[string]string, bool) {
+	d, err := c.fs.Open(dir)
+	if err != nil {
+		return nil, false
+	}
+	defer d.Close()
+	fi, err := d.Stat()
+	if err != nil || !fi.IsDir() {
+		return nil, false
+	}
+	c.mu.Lock()
+	idx, ok := c.indexes[dir]
+	c.mu.Unlock()
+	if ok && idx.modTime.Equal(fi.ModTime()) {
+		return idx.names, true
+	}
+	infos, err := d.Readdir(-1)
+	if err != nil {
+		return nil, false
+	}
+	idx = &foldIndex{modTime: fi.ModTime(), names: make(map[string]string, len(infos))}
+	for _, info := range infos {
+		lower := strings.ToLower(info.Name())
+		if prev, ok := idx.names[lower]; !ok || info.Name() < prev {
+			idx.names[lower] = info.Name()
+		}
+	}
+	c.mu.Lock()
+	c.indexes[dir] = idx
+	c.mu.Unlock()
+	return idx.names, true
+}
+
+// purge drops the indexes of the directories starting with prefix and
+// returns their number.
+func (c *caseFoldFS) purge(prefix string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for dir := range c.indexes {
+		if strings.HasPrefix(strings.TrimSuffix(dir, "/")+"/", prefix) {
+			delete(c.indexes, dir)
+			n++
+		}
+	}
+	return n
+}
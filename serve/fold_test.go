@@ -0,0 +1,105 @@
+package serve
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCaseInsensitive(t *testing.T) {
+	c := DefaultConfig()
+	c.CaseInsensitive = true
+	h, root := newTestHandlerRoot(t, c, map[string]string{
+		"Docs/ReadMe.HTML": "readme",
+		"a.txt":            "a",
+		"x/AB.txt":         "upper",
+		"x/ab.txt":         "lower",
+		"x/Ab.txt.":        "dotted",
+	})
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/Docs/ReadMe.HTML", http.StatusOK, "readme"},
+		{"/docs/readme.html", http.StatusOK, "readme"},
+		{"/DOCS/README.HTML", http.StatusOK, "readme"},
+		{"/docs/readme.html.", http.StatusOK, "readme"},
+		{"/a.txt.", http.StatusOK, "a"},
+		{"/A.TXT.", http.StatusOK, "a"},
+		{"/a.txt..", http.StatusNotFound, ""},
+		{"/x/ab.txt", http.StatusOK, "lower"},
+		{"/x/AB.txt", http.StatusOK, "upper"},
+		// Of names differing in case, the lexically smallest is served.
+		{"/x/aB.txt", http.StatusOK, "upper"},
+		// A name ending in a dot is served as is.
+		{"/x/Ab.txt.", http.StatusOK, "dotted"},
+		{"/missing", http.StatusNotFound, ""},
+		{"/docs/missing", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != tt.status || tt.body != "" && w.Body.String() != tt.body {
+			t.Errorf("%s: got status %d and body %q", tt.path, w.Code, w.Body)
+		}
+	}
+	// Files added later are found once the directory changed.
+	writeFiles(t, root, map[string]string{"Docs/New.txt": "new"})
+	// Make the change visible on file systems with coarse times.
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(root, "Docs"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if w := get(h, "/docs/new.txt"); w.Code != http.StatusOK || w.Body.String() != "new" {
+		t.Errorf("added file: got status %d and body %q", w.Code, w.Body)
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"Docs/ReadMe.HTML": "readme"})
+	for _, path := range []string{"/docs/readme.html", "/Docs/ReadMe.HTML."} {
+		if w := get(h, path); w.Code != http.StatusNotFound {
+			t.Errorf("%s: got status %d", path, w.Code)
+		}
+	}
+}
+
+func TestCaseInsensitiveAccessControl(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.CaseInsensitive = true
+	c.Htaccess = true
+	c.AuthFor = []string{"/admin/=basic?realm=admin&secrets=" + secrets}
+	h := newTestHandler(t, c, map[string]string{
+		"admin/s.txt":         "protected",
+		"private/.serve.yaml": "auth: true\n",
+		"private/s.txt":       "private",
+		"pub/p.txt":           "public",
+	})
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/admin/s.txt", http.StatusUnauthorized},
+		{"/ADMIN/s.txt", http.StatusUnauthorized},
+		{"/Admin/S.TXT", http.StatusUnauthorized},
+		{"/ADMIN/", http.StatusUnauthorized},
+		{"/private/s.txt", http.StatusForbidden},
+		{"/PRIVATE/s.txt", http.StatusForbidden},
+		{"/Private/S.txt.", http.StatusForbidden},
+		{"/PUB/P.TXT", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if w := get(h, tt.path); w.Code != tt.status {
+			t.Errorf("%s: got status %d and body %q, want %d", tt.path, w.Code, w.Body, tt.status)
+		}
+	}
+	r := newRequest(t, "/ADMIN/S.TXT")
+	r.SetBasicAuth("admin", "secret")
+	if w := serveRequest(h, r); w.Code != http.StatusOK || w.Body.String() != "protected" {
+		t.Errorf("authenticated: got status %d and body %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,611 @@
+package serve
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+const (
+	// ftpIdleTimeout closes control connections without commands.
+	ftpIdleTimeout = 5 * time.Minute
+	// ftpDataTimeout bounds waiting for the client to open a passive data
+	// connection.
+	ftpDataTimeout = 30 * time.Second
+)
+
+// FTPOptions configure the FTP server.
+type FTPOptions struct {
+	// PassivePorts is the range of ports, e.g. 30000-30009, on which
+	// passive data connections are accepted. Any free port is used if
+	// empty.
+	PassivePorts string
+}
+
+// FTPServer serves the files of a Handler read-only over FTP. Clients log
+// in with the credentials of a basic Config.Auth, or as any user without
+// one. Directories whose rules require authentication are refused to the
+// latter, and those disabling listings cannot be listed. Only passive data
+// connections are supported.
+type FTPServer struct {
+	fs       http.FileSystem
+	auth     *auth.BasicAuth
+	dirRules *dirRulesLoader
+	log      *Logger
+	minPort  int
+	maxPort  int
+	nextPort int
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// FTP returns an FTP server for the files served by h.
+func (h *Handler) FTP(opts FTPOptions) (*FTPServer, error) {
+	if h.fs == nil {
+		return nil, errors.New("no directory is served")
+	}
+	if h.authFor {
+		// Realms below path prefixes cannot be enforced for FTP clients.
+		return nil, errors.New("auth realms of path prefixes are not supported")
+	}
+	if len(h.httpOnly) > 0 {
+		return nil, fmt.Errorf("%s cannot be enforced for ftp", strings.Join(h.httpOnly, ", "))
+	}
+	s := &FTPServer{fs: h.fs, dirRules: h.dirRules, log: h.log, conns: map[net.Conn]bool{}}
+	if h.authURN != "" {
+		typ, rest, err := splitAuthURN(h.authURN)
+		if err != nil {
+			return nil, err
+		}
+		if typ != "basic" {
+			return nil, fmt.Errorf("auth type %s is not supported", typ)
+		}
+		if s.auth, err = newBasicAuth(rest); err != nil {
+			return nil, err
+		}
+	}
+	if opts.PassivePorts != "" {
+		parts := strings.SplitN(opts.PassivePorts, "-", 2)
+		min, err1 := strconv.Atoi(parts[0])
+		max, err2 := min, error(nil)
+		if len(parts) == 2 {
+			max, err2 = strconv.Atoi(parts[1])
+		}
+		if err1 != nil || err2 != nil || min < 1 || max > 65535 || min > max {
+			return nil, fmt.Errorf("invalid passive port range: %s", opts.PassivePorts)
+		}
+		s.minPort, s.maxPort, s.nextPort = min, max, min
+	}
+	return s, nil
+}
+
+// httpOnlyRestrictions returns the names of the access restrictions enabled
+// in c that depend on HTTP requests and thus do not apply to FTP.
+func httpOnlyRestrictions(c Config) []string {
+	var names []string
+	if c.SignedURLs {
+		names = append(names, "signed-urls")
+	}
+	if c.GeoAllow != "" || c.GeoDeny != "" {
+		names = append(names, "geo")
+	}
+	if len(splitList(c.RefererProtect)) > 0 {
+		names = append(names, "referer-protect")
+	}
+	if c.MaintenanceFile != "" {
+		names = append(names, "maintenance")
+	}
+	if c.Once {
+		names = append(names, "once")
+	}
+	return names
+}
+
+// Serve accepts FTP control connections on ln until Close is called.
+func (s *FTPServer) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ln.Close()
+	}
+	s.ln = ln
+	s.mu.Unlock()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		if !s.track(c, true) {
+			c.Close()
+			return nil
+		}
+		go func() {
+			defer s.track(c, false)
+			defer c.Close()
+			(&ftpSession{server: s, conn: c, cwd: "/"}).serve()
+		}()
+	}
+}
+
+// track adds or removes a control or data connection, reporting false once
+// the server is closed.
+func (s *FTPServer) track(c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, c)
+		return true
+	}
+	if s.closed {
+		return false
+	}
+	s.conns[c] = true
+	return true
+}
+
+// Close stops accepting connections and closes the open ones, aborting
+// running transfers.
+func (s *FTPServer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for c := range s.conns {
+		c.Close()
+	}
+	if s.ln != nil {
+		return s.ln.Close()
+	}
+	return nil
+}
+
+// listenPassive listens for a data connection on ip, on a port of the
+// passive range if one is configured.
+func (s *FTPServer) listenPassive(ip net.IP) (net.Listener, error) {
+	if s.minPort == 0 {
+		return net.Listen("tcp", net.JoinHostPort(ip.String(), "0"))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := s.maxPort - s.minPort + 1
+	for i := 0; i < n; i++ {
+		port := s.nextPort
+		if s.nextPort++; s.nextPort > s.maxPort {
+			s.nextPort = s.minPort
+		}
+		ln, err := net.Listen("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+		if err == nil {
+			return ln, nil
+		}
+	}
+	return nil, errors.New("no free passive port")
+}
+
+// rules returns the directory rules of dir, reporting false if the session
+// must not access it.
+func (s *FTPServer) rules(dir string) (dirRules, bool) {
+	if s.dirRules == nil {
+		return dirRules{}, true
+	}
+	rules, err := s.dirRules.rules(dir)
+	if err != nil {
+		s.log.errorf("load directory rules: %v", err)
+		return dirRules{}, false
+	}
+	// Without auth, sessions are anonymous; with it, all are authenticated.
+	if s.auth == nil && rules.Auth != nil && *rules.Auth {
+		return rules, false
+	}
+	return rules, true
+}
+
+// ftpSession is the state of a control connection.
+type ftpSession struct {
+	server *FTPServer
+	conn   net.Conn
+	w      *bufio.Writer
+
+	user     string
+	loggedIn bool
+	cwd      string
+	// rest is the offset of the next RETR.
+	rest int64
+	// pasv is the listener of the pending passive data connection.
+	pasv net.Listener
+}
+
+func (sess *ftpSession) serve() {
+	defer sess.closePassive()
+	r := bufio.NewReader(sess.conn)
+	sess.w = bufio.NewWriter(sess.conn)
+	sess.reply(220, "serve FTP ready")
+	for {
+		sess.conn.SetReadDeadline(time.Now().Add(ftpIdleTimeout))
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			cmd, arg = line[:i], line[i+1:]
+		}
+		cmd = strings.ToUpper(cmd)
+		if cmd == "PASS" {
+			sess.server.log.debugf("ftp %s: PASS ***", sess.conn.RemoteAddr())
+		} else {
+			sess.server.log.debugf("ftp %s: %s", sess.conn.RemoteAddr(), line)
+		}
+		if !sess.handle(cmd, arg) {
+			return
+		}
+	}
+}
+
+// reply writes a single line reply.
+func (sess *ftpSession) reply(code int, msg string) {
+	fmt.Fprintf(sess.w, "%d %s\r\n", code, msg)
+	sess.w.Flush()
+}
+
+// handle executes a command, reporting false once the session ends.
+func (sess *ftpSession) handle(cmd string, arg string) bool {
+	switch cmd {
+	case "USER":
+		sess.user, sess.loggedIn = arg, false
+		if sess.server.auth == nil {
+			sess.loggedIn = true
+			sess.reply(230, "Logged in")
+			return true
+		}
+		sess.reply(331, "Password required")
+		return true
+	case "PASS":
+		if sess.server.auth == nil || sess.loggedIn {
+			sess.reply(230, "Logged in")
+			return true
+		}
+		r := &http.Request{Header: http.Header{}}
+		r.SetBasicAuth(sess.user, arg)
+		if sess.server.auth.CheckAuth(r) == "" {
+			sess.server.log.debugf("ftp login failed for %q from %s", sess.user, sess.conn.RemoteAddr())
+			sess.reply(530, "Login incorrect")
+			return true
+		}
+		sess.loggedIn = true
+		sess.reply(230, "Logged in")
+		return true
+	case "QUIT":
+		sess.reply(221, "Bye")
+		return false
+	case "NOOP":
+		sess.reply(200, "OK")
+		return true
+	case "SYST":
+		sess.reply(215, "UNIX Type: L8")
+		return true
+	case "FEAT":
+		fmt.Fprintf(sess.w, "211-Features:\r\n EPSV\r\n PASV\r\n SIZE\r\n MDTM\r\n REST STREAM\r\n UTF8\r\n211 End\r\n")
+		sess.w.Flush()
+		return true
+	case "OPTS":
+		if strings.EqualFold(arg, "UTF8 ON") {
+			sess.reply(200, "UTF8 enabled")
+		} else {
+			sess.reply(501, "Option not supported")
+		}
+		return true
+	}
+	if !sess.loggedIn {
+		sess.reply(530, "Not logged in")
+		return true
+	}
+	switch cmd {
+	case "PWD", "XPWD":
+		sess.reply(257, `"`+strings.Replace(sess.cwd, `"`, `""`, -1)+`" is the current directory`)
+	case "CWD", "XCWD":
+		sess.changeDir(arg)
+	case "CDUP", "XCUP":
+		sess.changeDir("..")
+	case "TYPE":
+		// Files are always sent as they are, also in ASCII mode.
+		sess.reply(200, "Type set")
+	case "MODE":
+		if strings.EqualFold(arg, "S") {
+			sess.reply(200, "Mode set")
+		} else {
+			sess.reply(504, "Only stream mode is supported")
+		}
+	case "STRU":
+		if strings.EqualFold(arg, "F") {
+			sess.reply(200, "Structure set")
+		} else {
+			sess.reply(504, "Only file structure is supported")
+		}
+	case "PASV", "EPSV":
+		sess.passive(cmd == "EPSV")
+	case "PORT", "EPRT":
+		sess.reply(502, "Active mode is not supported, use passive mode")
+	case "REST":
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || offset < 0 {
+			sess.reply(501, "Invalid offset")
+			break
+		}
+		sess.rest = offset
+		sess.reply(350, "Restarting at "+arg)
+	case "SIZE", "MDTM":
+		fi, err := sess.stat(arg)
+		if err != nil {
+			sess.replyOpenError(err, "No such file")
+			break
+		}
+		if fi.IsDir() {
+			sess.reply(550, "No such file")
+			break
+		}
+		if cmd == "SIZE" {
+			sess.reply(213, strconv.FormatInt(fi.Size(), 10))
+		} else {
+			sess.reply(213, fi.ModTime().UTC().Format("20060102150405"))
+		}
+	case "LIST", "NLST":
+		sess.list(arg, cmd == "NLST")
+	case "RETR":
+		sess.retrieve(arg)
+	case "STOR", "STOU", "APPE", "DELE", "RMD", "XRMD", "MKD", "XMKD", "RNFR", "RNTO", "SITE":
+		sess.reply(550, "Permission denied, the server is read-only")
+	default:
+		sess.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+// resolve returns the absolute path of name relative to the working
+// directory.
+func (sess *ftpSession) resolve(name string) string {
+	if strings.HasPrefix(name, "/") {
+		return path.Clean(name)
+	}
+	return path.Join(sess.cwd, name)
+}
+
+// open opens name relative to the working directory, failing with
+// os.ErrPermission if the rules of its directory refuse the session. It
+// also returns those rules.
+func (sess *ftpSession) open(name string) (http.File, os.FileInfo, dirRules, error) {
+	name = sess.resolve(name)
+	f, err := sess.server.fs.Open(name)
+	if err != nil {
+		return nil, nil, dirRules{}, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, dirRules{}, err
+	}
+	rules, ok := sess.server.rules(rulesDir(name, fi.IsDir()))
+	if !ok {
+		f.Close()
+		return nil, nil, dirRules{}, os.ErrPermission
+	}
+	return f, fi, rules, nil
+}
+
+func (sess *ftpSession) stat(name string) (os.FileInfo, error) {
+	f, fi, _, err := sess.open(name)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return fi, nil
+}
+
+// replyOpenError replies to a failure of open.
+func (sess *ftpSession) replyOpenError(err error, msg string) {
+	sess.closePassive()
+	if os.IsPermission(err) {
+		sess.reply(550, "Permission denied")
+		return
+	}
+	sess.reply(550, msg)
+}
+
+func (sess *ftpSession) changeDir(name string) {
+	fi, err := sess.stat(name)
+	if err != nil {
+		sess.replyOpenError(err, "No such directory")
+		return
+	}
+	if !fi.IsDir() {
+		sess.reply(550, "No such directory")
+		return
+	}
+	sess.cwd = sess.resolve(name)
+	sess.reply(250, "Directory changed to "+sess.cwd)
+}
+
+// passive opens a listener for the next data connection on the address the
+// client reached the server at.
+func (sess *ftpSession) passive(extended bool) {
+	sess.closePassive()
+	local, _ := sess.conn.LocalAddr().(*net.TCPAddr)
+	if local == nil {
+		sess.reply(425, "Cannot open data connection")
+		return
+	}
+	ip4 := local.IP.To4()
+	if !extended && ip4 == nil {
+		sess.reply(522, "Use EPSV for IPv6")
+		return
+	}
+	ln, err := sess.server.listenPassive(local.IP)
+	if err != nil {
+		sess.server.log.warnf("ftp passive listen: %v", err)
+		sess.reply(425, "Cannot open data connection")
+		return
+	}
+	sess.pasv = ln
+	port := ln.Addr().(*net.TCPAddr).Port
+	if extended {
+		sess.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+		return
+	}
+	sess.reply(227, fmt.Sprintf("Entering Passive Mode (%d,%d,%d,%d,%d,%d)", ip4[0], ip4[1], ip4[2], ip4[3], port>>8, port&0xff))
+}
+
+func (sess *ftpSession) closePassive() {
+	if sess.pasv != nil {
+		sess.pasv.Close()
+		sess.pasv = nil
+	}
+}
+
+// transfer accepts the pending data connection and writes to it with send,
+// reporting whether it succeeded. Connections from other hosts than the
+// client are refused.
+func (sess *ftpSession) transfer(send func(w io.Writer) error) bool {
+	ln := sess.pasv
+	sess.pasv = nil
+	if ln == nil {
+		sess.reply(425, "Use PASV or EPSV first")
+		return false
+	}
+	defer ln.Close()
+	sess.reply(150, "Opening data connection")
+	if tl, ok := ln.(*net.TCPListener); ok {
+		tl.SetDeadline(time.Now().Add(ftpDataTimeout))
+	}
+	data, err := ln.Accept()
+	if err != nil {
+		sess.reply(425, "Cannot open data connection")
+		return false
+	}
+	defer data.Close()
+	if !sess.server.track(data, true) {
+		return false
+	}
+	defer sess.server.track(data, false)
+	if !sameHost(data.RemoteAddr(), sess.conn.RemoteAddr()) {
+		sess.server.log.warnf("ftp data connection from %s refused for %s", data.RemoteAddr(), sess.conn.RemoteAddr())
+		sess.reply(425, "Data connection refused")
+		return false
+	}
+	if err := send(data); err != nil {
+		sess.server.log.debugf("ftp transfer to %s: %v", sess.conn.RemoteAddr(), err)
+		sess.reply(426, "Transfer aborted")
+		return false
+	}
+	if err := data.Close(); err != nil {
+		sess.reply(426, "Transfer aborted")
+		return false
+	}
+	sess.reply(226, "Transfer complete")
+	return true
+}
+
+func sameHost(a net.Addr, b net.Addr) bool {
+	ta, ok1 := a.(*net.TCPAddr)
+	tb, ok2 := b.(*net.TCPAddr)
+	return ok1 && ok2 && ta.IP.Equal(tb.IP)
+}
+
+// list sends the entries of a directory, or a file, in the format of ls -l,
+// or only their names.
+func (sess *ftpSession) list(arg string, namesOnly bool) {
+	// Options like -la are ignored.
+	var name string
+	for _, f := range strings.Fields(arg) {
+		if !strings.HasPrefix(f, "-") {
+			name = f
+		}
+	}
+	f, fi, rules, err := sess.open(name)
+	if err != nil {
+		sess.replyOpenError(err, "No such file or directory")
+		return
+	}
+	defer f.Close()
+	infos := []os.FileInfo{fi}
+	if fi.IsDir() {
+		if rules.Listing != nil && !*rules.Listing {
+			sess.closePassive()
+			sess.reply(550, "Listing disabled")
+			return
+		}
+		if infos, err = f.Readdir(-1); err != nil {
+			sess.closePassive()
+			sess.reply(550, "Cannot read directory")
+			return
+		}
+	}
+	sess.transfer(func(w io.Writer) error {
+		bw := bufio.NewWriter(w)
+		for _, fi := range infos {
+			if namesOnly {
+				fmt.Fprintf(bw, "%s\r\n", fi.Name())
+				continue
+			}
+			mode := "-r--r--r--"
+			if fi.IsDir() {
+				mode = "dr-xr-xr-x"
+			}
+			layout := "Jan _2 15:04"
+			if time.Since(fi.ModTime()) > 180*24*time.Hour {
+				layout = "Jan _2  2006"
+			}
+			fmt.Fprintf(bw, "%s 1 ftp ftp %12d %s %s\r\n", mode, fi.Size(), fi.ModTime().Format(layout), fi.Name())
+		}
+		return bw.Flush()
+	})
+}
+
+// retrieve sends a file from the offset of a preceding REST.
+func (sess *ftpSession) retrieve(name string) {
+	offset := sess.rest
+	sess.rest = 0
+	f, fi, _, err := sess.open(name)
+	if err != nil {
+		sess.replyOpenError(err, "No such file")
+		return
+	}
+	defer f.Close()
+	if fi.IsDir() {
+		sess.closePassive()
+		sess.reply(550, "Not a file")
+		return
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			sess.closePassive()
+			sess.reply(550, "Cannot restart at offset")
+			return
+		}
+	}
+	ok := sess.transfer(func(w io.Writer) error {
+		_, err := io.Copy(w, f)
+		return err
+	})
+	if ok {
+		sess.server.log.infof("ftp %s %s retrieved %s", sess.conn.RemoteAddr(), sess.user, sess.resolve(name))
+	}
+}
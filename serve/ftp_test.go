@@ -0,0 +1,176 @@
+package serve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// startFTP serves the FTP server of h on a loopback port and returns a
+// connected client.
+func startFTP(t *testing.T, h *Handler) *textproto.Conn {
+	t.Helper()
+	s, err := h.FTP(FTPOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	t.Cleanup(func() { s.Close() })
+	c, err := textproto.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	expectFTP(t, c, 220)
+	return c
+}
+
+// ftpCmd sends a command and returns the reply, failing unless it has code.
+func ftpCmd(t *testing.T, c *textproto.Conn, code int, format string, args ...interface{}) string {
+	t.Helper()
+	if err := c.PrintfLine(format, args...); err != nil {
+		t.Fatal(err)
+	}
+	return expectFTP(t, c, code)
+}
+
+func expectFTP(t *testing.T, c *textproto.Conn, code int) string {
+	t.Helper()
+	_, msg, err := c.ReadResponse(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// ftpData opens a passive data connection, sends the command and returns
+// the data transferred.
+func ftpData(t *testing.T, c *textproto.Conn, format string, args ...interface{}) string {
+	t.Helper()
+	msg := ftpCmd(t, c, 227, "PASV")
+	var h1, h2, h3, h4, p1, p2 int
+	if _, err := fmt.Sscanf(msg[strings.IndexByte(msg, '('):], "(%d,%d,%d,%d,%d,%d)", &h1, &h2, &h3, &h4, &p1, &p2); err != nil {
+		t.Fatalf("PASV reply %q: %v", msg, err)
+	}
+	data, err := net.Dial("tcp", fmt.Sprintf("%d.%d.%d.%d:%d", h1, h2, h3, h4, p1<<8|p2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer data.Close()
+	ftpCmd(t, c, 150, format, args...)
+	b, err := ioutil.ReadAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectFTP(t, c, 226)
+	return string(b)
+}
+
+func TestFTPSession(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"alice": "secret"})
+	c := DefaultConfig()
+	c.Auth = "basic?secrets=" + secrets
+	h := newTestHandler(t, c, map[string]string{
+		"a.txt":     "0123456789",
+		"sub/b.txt": "b",
+	})
+	conn := startFTP(t, h)
+
+	ftpCmd(t, conn, 331, "USER alice")
+	ftpCmd(t, conn, 530, "PASS wrong")
+	ftpCmd(t, conn, 331, "USER alice")
+	ftpCmd(t, conn, 230, "PASS secret")
+
+	list := ftpData(t, conn, "LIST")
+	if !strings.Contains(list, "a.txt") || !strings.Contains(list, "sub") {
+		t.Errorf("LIST: got %q", list)
+	}
+	if names := ftpData(t, conn, "NLST sub"); strings.TrimSpace(names) != "b.txt" {
+		t.Errorf("NLST sub: got %q", names)
+	}
+	if got := ftpCmd(t, conn, 213, "SIZE a.txt"); got != "10" {
+		t.Errorf("SIZE: got %q", got)
+	}
+	if got := ftpData(t, conn, "RETR a.txt"); got != "0123456789" {
+		t.Errorf("RETR: got %q", got)
+	}
+	ftpCmd(t, conn, 350, "REST 4")
+	if got := ftpData(t, conn, "RETR a.txt"); got != "456789" {
+		t.Errorf("RETR after REST: got %q", got)
+	}
+	ftpCmd(t, conn, 550, "STOR c.txt")
+	ftpCmd(t, conn, 550, "DELE a.txt")
+	ftpCmd(t, conn, 221, "QUIT")
+}
+
+func TestFTPLoginRequired(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"alice": "secret"})
+	c := DefaultConfig()
+	c.Auth = "basic?secrets=" + secrets
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	conn := startFTP(t, h)
+	ftpCmd(t, conn, 530, "PASV")
+	ftpCmd(t, conn, 530, "RETR a.txt")
+}
+
+func TestFTPDirRules(t *testing.T) {
+	c := DefaultConfig()
+	c.Htaccess = true
+	h := newTestHandler(t, c, map[string]string{
+		"private/.serve.yaml":     "auth: true\n",
+		"private/s.txt":           "private",
+		"private/pub/.serve.yaml": "auth: false\n",
+		"private/pub/p.txt":       "public",
+		"unlisted/.serve.yaml":    "listing: false\n",
+		"unlisted/u.txt":          "unlisted",
+	})
+	conn := startFTP(t, h)
+	ftpCmd(t, conn, 230, "USER anonymous")
+
+	ftpCmd(t, conn, 550, "CWD private")
+	ftpCmd(t, conn, 550, "SIZE private/s.txt")
+	ftpCmd(t, conn, 227, "PASV")
+	ftpCmd(t, conn, 550, "RETR private/s.txt")
+	ftpCmd(t, conn, 227, "PASV")
+	ftpCmd(t, conn, 550, "LIST private")
+	if got := ftpData(t, conn, "RETR private/pub/p.txt"); got != "public" {
+		t.Errorf("RETR private/pub/p.txt: got %q", got)
+	}
+	ftpCmd(t, conn, 227, "PASV")
+	ftpCmd(t, conn, 550, "LIST unlisted")
+	if got := ftpData(t, conn, "RETR unlisted/u.txt"); got != "unlisted" {
+		t.Errorf("RETR unlisted/u.txt: got %q", got)
+	}
+}
+
+func TestFTPHTTPOnlyRestrictions(t *testing.T) {
+	for name, set := range map[string]func(c *Config){
+		"signed-urls":     func(c *Config) { c.SignedURLs, c.SigningKey = true, "secret" },
+		"referer-protect": func(c *Config) { c.RefererProtect = "jpg" },
+		"maintenance":     func(c *Config) { c.MaintenanceFile = "/nonexistent/maintenance" },
+		"once":            func(c *Config) { c.Once = true },
+	} {
+		c := DefaultConfig()
+		set(&c)
+		h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+		if _, err := h.FTP(FTPOptions{}); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+	if got := httpOnlyRestrictions(Config{GeoDeny: "CN"}); len(got) != 1 || got[0] != "geo" {
+		t.Errorf("geo: got %v", got)
+	}
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"a.txt": "a"})
+	if _, err := h.FTP(FTPOptions{}); err != nil {
+		t.Errorf("no restrictions: got error %v", err)
+	}
+}
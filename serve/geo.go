@@ -0,0 +1,85 @@
+package serve
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// maxGeoCacheSize bounds the number of cached country lookups.
+const maxGeoCacheSize = 10000
+
+// geoDB looks up the ISO country code of IP addresses in a MaxMind database
+// and caches the results per IP.
+type geoDB struct {
+	reader *maxminddb.Reader
+	log    *Logger
+	mu     sync.Mutex
+	cache  map[string]string
+}
+
+func openGeoDB(path string, log *Logger) (*geoDB, error) {
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &geoDB{reader: reader, log: log, cache: map[string]string{}}, nil
+}
+
+// country returns the ISO country code of ip or "" if it is unknown.
+func (db *geoDB) country(ip net.IP) string {
+	key := ip.String()
+	db.mu.Lock()
+	code, ok := db.cache[key]
+	db.mu.Unlock()
+	if ok {
+		return code
+	}
+	var record struct {
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+	}
+	if err := db.reader.Lookup(ip, &record); err != nil {
+		db.log.warnf("geoip lookup %s: %v", ip, err)
+	}
+	code = record.Country.ISOCode
+	db.mu.Lock()
+	if len(db.cache) >= maxGeoCacheSize {
+		db.cache = map[string]string{}
+	}
+	db.cache[key] = code
+	db.mu.Unlock()
+	return code
+}
+
+// Geo answers requests with 403 if the country of the client is denied or, if
+// an allow list is given, not allowed. Clients of unknown countries are only
+// blocked by an allow list.
+func Geo(db *geoDB, allow []string, deny []string, h http.Handler) http.Handler {
+	allowed := countrySet(allow)
+	denied := countrySet(deny)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := ""
+		if ip := remoteIP(r.RemoteAddr); ip != nil {
+			code = db.country(ip)
+		}
+		if denied[code] || (len(allowed) > 0 && !allowed[code]) {
+			logOf(r).debugf("country %q of %s blocked", code, r.RemoteAddr)
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func countrySet(codes []string) map[string]bool {
+	set := map[string]bool{}
+	for _, code := range codes {
+		set[strings.ToUpper(code)] = true
+	}
+	return set
+}
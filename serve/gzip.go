@@ -0,0 +1,154 @@
+package serve
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// GZIP compresses responses for clients accepting gzip, unless their
+// User-Agent matches skipUA, which may be nil. Range requests are served
+// uncompressed, so that byte ranges refer to the file content and downloads
+// remain resumable. Responses that already carry a Content-Encoding or are
+// of a compressed type are passed through.
+func GZIP(skipUA *regexp.Regexp, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Header.Get("Range") != "" || r.Header.Get("Upgrade") != "" || skipsCompression(skipUA, w, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if r.Method == http.MethodHead {
+			// No body is sent, so there is nothing to compress.
+			gzr := &gzipResponseWriter{Writer: ioutil.Discard, ResponseWriter: w}
+			h.ServeHTTP(withOptional(gzr, w), r)
+			gzr.sendHeader()
+			return
+		}
+		gz := gzip.NewWriter(w)
+		gzr := &gzipResponseWriter{Writer: gz, ResponseWriter: w}
+		h.ServeHTTP(withOptional(gzr, w), r)
+		gzr.sendHeader()
+		if gzr.uncompressed {
+			return
+		}
+		if err := gz.Close(); err != nil && !isClientDisconnect(err, r) {
+			logOf(r).warnf("compress response for %s %s: %v", r.Method, r.URL, err)
+		}
+		if rl := requestLogFrom(r); rl != nil {
+			rl.uncompressed = gzr.written
+			rl.compressed = true
+		}
+	})
+}
+
+// compressedTypes are content types not worth compressing again.
+var compressedTypes = map[string]bool{
+	"application/gzip":             true,
+	"application/zip":              true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(h http.Header, field string) {
+	for _, v := range h.Values("Vary") {
+		for _, f := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(f), field) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", field)
+}
+
+// skipsCompression reports whether the User-Agent of r matches skipUA, which
+// may be nil. Responses vary by User-Agent if it is set.
+func skipsCompression(skipUA *regexp.Regexp, w http.ResponseWriter, r *http.Request) bool {
+	if skipUA == nil {
+		return false
+	}
+	w.Header().Add("Vary", "User-Agent")
+	return skipUA.MatchString(r.UserAgent())
+}
+
+type gzipResponseWriter struct {
+	io.Writer
+	http.ResponseWriter
+	// written is the number of uncompressed bytes.
+	written int64
+	// status is held back until the first write, so that the content type
+	// can be sniffed from the uncompressed body and compression decided on
+	// the final header; it is 0 once sent.
+	status      int
+	wroteHeader bool
+	// uncompressed is set for responses sent as they are, such as those
+	// without a body or already compressed ones.
+	uncompressed bool
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if "" == w.Header().Get("Content-Type") {
+		// If no content type, apply sniffing algorithm to un-gzipped body.
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	w.sendHeader()
+	if w.uncompressed {
+		return w.ResponseWriter.Write(b)
+	}
+	n, err := w.Writer.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		// Informational responses precede the final one.
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+}
+
+// sendHeader sends the held back status, if any, which defaults to 200.
+func (w *gzipResponseWriter) sendHeader() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status == 0 {
+		return
+	}
+	status := w.status
+	w.status = 0
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get("Content-Type"))
+	if status == http.StatusNoContent || status == http.StatusNotModified || w.Header().Get("Content-Encoding") != "" || compressedTypes[mediaType] {
+		w.uncompressed = true
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	// The length of the compressed body is unknown up front.
+	w.Header().Del("Content-Length")
+	weakenETag(w.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Flush flushes the buffered compressed data and the underlying writer, so
+// that streamed responses reach the client immediately.
+func (w *gzipResponseWriter) Flush() {
+	w.sendHeader()
+	if f, ok := w.Writer.(interface{ Flush() error }); !w.uncompressed && ok {
+		f.Flush()
+	}
+	flush(w.ResponseWriter)
+}
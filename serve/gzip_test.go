@@ -0,0 +1,264 @@
+package serve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGZIPFlush(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(GZIP(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-release
+		w.Write([]byte("data: second\n\n"))
+	})))
+	defer srv.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+	r, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got Content-Encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first event arrives while the handler still waits.
+	first := make([]byte, len("data: first\n\n"))
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(zr, first)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil || string(first) != "data: first\n\n" {
+			t.Fatalf("got %q (%v)", first, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("flushed data did not arrive")
+	}
+	close(release)
+	rest, err := ioutil.ReadAll(zr)
+	if err != nil || string(rest) != "data: second\n\n" {
+		t.Errorf("got %q (%v)", rest, err)
+	}
+}
+
+func TestGZIPPassThrough(t *testing.T) {
+	body := bytes.Repeat([]byte("compressible "), 100)
+	h := GZIP(nil, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/zip" {
+			w.Header().Set("Content-Type", "application/zip")
+		}
+		if r.URL.Path == "/empty" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(body))
+	}))
+	tests := []struct {
+		path     string
+		header   []string
+		encoding string
+	}{
+		{"/text", []string{"Accept-Encoding", "gzip"}, "gzip"},
+		{"/text", nil, ""},
+		{"/text", []string{"Accept-Encoding", "gzip", "Range", "bytes=0-9"}, ""},
+		{"/zip", []string{"Accept-Encoding", "gzip"}, ""},
+		{"/empty", []string{"Accept-Encoding", "gzip"}, ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path, tt.header...)
+		if got := w.Header().Get("Content-Encoding"); got != tt.encoding {
+			t.Errorf("%s %v: got Content-Encoding %q, want %q", tt.path, tt.header, got, tt.encoding)
+		}
+	}
+	w := get(h, "/text", "Accept-Encoding", "gzip")
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadAll(zr); !bytes.Equal(got, body) {
+		t.Errorf("got %q", got)
+	}
+	if w.Header().Get("Content-Length") != "" {
+		t.Errorf("got Content-Length %s of the uncompressed body", w.Header().Get("Content-Length"))
+	}
+}
+
+func TestGZIPRanges(t *testing.T) {
+	c := DefaultConfig()
+	c.GZIP = true
+	body := strings.Repeat("0123456789", 100)
+	h := newTestHandler(t, c, map[string]string{"a.txt": body})
+
+	w := get(h, "/a.txt", "Accept-Encoding", "gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("got status %d and Content-Encoding %q", w.Code, w.Header().Get("Content-Encoding"))
+	}
+	// Clients learn from the compressed response that they may resume.
+	if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("got Accept-Ranges %q, want bytes", got)
+	}
+
+	w = get(h, "/a.txt", "Accept-Encoding", "gzip", "Range", "bytes=10-19")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("range: got status %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("range: got Content-Encoding %q", got)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 10-19/1000" {
+		t.Errorf("range: got Content-Range %q", got)
+	}
+	if w.Body.String() != "0123456789" {
+		t.Errorf("range: got body %q", w.Body)
+	}
+	if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "Accept-Encoding") {
+		t.Errorf("range: got Vary %q", vary)
+	}
+}
+
+func TestGZIPHead(t *testing.T) {
+	c := DefaultConfig()
+	c.GZIP = true
+	body := strings.Repeat("<p>compressible</p>", 100)
+	h := newTestHandler(t, c, map[string]string{"a.html": body})
+	for _, accept := range []string{"gzip", ""} {
+		r := newRequest(t, "/a.html")
+		r.Method = http.MethodHead
+		r.Header.Set("Accept-Encoding", accept)
+		w := serveRequest(h, r)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("%q: got status %d and %d bytes", accept, w.Code, w.Body.Len())
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("%q: got Content-Type %q", accept, got)
+		}
+		// The compressed length is unknown without compressing.
+		wantLength := strconv.Itoa(len(body))
+		if accept == "gzip" {
+			wantLength = ""
+		}
+		if got := w.Header().Get("Content-Length"); got != wantLength {
+			t.Errorf("%q: got Content-Length %q, want %q", accept, got, wantLength)
+		}
+	}
+}
+
+func TestGZIPSkipUA(t *testing.T) {
+	c := DefaultConfig()
+	c.GZIP = true
+	c.Precompressed = true
+	c.GZIPSkipUA = `MSIE [1-6]\.|BrokenCDN`
+	body := strings.Repeat("<p>compressible</p>", 100)
+	h := newTestHandler(t, c, map[string]string{
+		"a.html":    body,
+		"app.js":    "console.log('plain')",
+		"app.js.gz": "compressed",
+	})
+	tests := []struct {
+		ua         string
+		compressed bool
+	}{
+		{"Mozilla/4.0 (compatible; MSIE 6.0; Windows NT 5.1)", false},
+		{"BrokenCDN/1.0", false},
+		{"Mozilla/5.0 (compatible; MSIE 10.0; Windows NT 6.1)", true},
+		{"Mozilla/5.0 (X11; Linux x86_64) Firefox/120.0", true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		for _, path := range []string{"/a.html", "/app.js"} {
+			w := get(h, path, "Accept-Encoding", "gzip", "User-Agent", tt.ua)
+			if got := w.Header().Get("Content-Encoding") == "gzip"; got != tt.compressed {
+				t.Errorf("%s %q: got compressed %v, want %v", path, tt.ua, got, tt.compressed)
+			}
+			if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "User-Agent") {
+				t.Errorf("%s %q: got Vary %q", path, tt.ua, vary)
+			}
+		}
+	}
+	if w := get(h, "/a.html", "Accept-Encoding", "gzip", "User-Agent", "BrokenCDN/1.0"); w.Body.String() != body {
+		t.Errorf("got body %q", w.Body)
+	}
+}
+
+func TestGZIPSkipUAInvalid(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.GZIP = true
+	c.GZIPSkipUA = "MSIE ["
+	if _, err := New(c); err == nil {
+		t.Error("got no error")
+	}
+}
+
+func TestGZIPGenerated(t *testing.T) {
+	c := DefaultConfig()
+	c.GZIP = true
+	c.Sitemap = "https://example.com"
+	c.StatsPath = "/_stats"
+	h := newTestHandler(t, c, map[string]string{"d/index.htm": "<p>page</p>", "d/a.txt": "a"})
+	deadline := time.Now().Add(5 * time.Second)
+	for get(h, "/sitemap.xml").Code == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tests := []struct {
+		path   string
+		accept string
+		ctype  string
+		want   string
+	}{
+		{"/d/", "text/html", "text/html; charset=utf-8", `href="a.txt"`},
+		{"/d/", "application/json", "application/json; charset=utf-8", `"name":"a.txt"`},
+		{"/sitemap.xml", "", "application/xml", "<loc>https://example.com/d/index.htm</loc>"},
+		{"/_stats", "", "application/json; charset=utf-8", `"cache_hits"`},
+		{"/missing", "", "text/plain; charset=utf-8", "404 page not found"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path, "Accept", tt.accept, "Accept-Encoding", "gzip")
+		if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("%s %s: got Content-Encoding %q", tt.path, tt.accept, got)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s %s: got Content-Type %q, want %q", tt.path, tt.accept, got, tt.ctype)
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Errorf("%s %s: %v", tt.path, tt.accept, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(zr)
+		if err != nil || !strings.Contains(string(body), tt.want) {
+			t.Errorf("%s %s: got body %q, %v", tt.path, tt.accept, body, err)
+		}
+	}
+	etag := get(h, "/d/").Header().Get("ETag")
+	w := get(h, "/d/", "If-None-Match", etag, "Accept-Encoding", "gzip")
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("not modified: got status %d, %d bytes and Content-Encoding %q", w.Code, w.Body.Len(), w.Header().Get("Content-Encoding"))
+	}
+}
@@ -0,0 +1,111 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// headerRule adds headers to responses for request paths starting with prefix.
+type headerRule struct {
+	prefix string
+	header http.Header
+}
+
+// Headers adds the headers of all rules matching the request path to the
+// response before the wrapped handler writes anything, so they are also
+// present on error responses.
+//
+// Rules are applied from the least to the most specific, i.e. ordered by the
+// length of their prefix. A header set by a more specific rule replaces the
+// values of the same header set by a less specific one, while multiple values
+// of a header within one rule are all added.
+func Headers(rules []headerRule, h http.Handler) http.Handler {
+	rules = append([]headerRule(nil), rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].prefix) < len(rules[j].prefix)
+	})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := http.Header{}
+		for _, rule := range rules {
+			if !strings.HasPrefix(r.URL.Path, rule.prefix) {
+				continue
+			}
+			for name, values := range rule.header {
+				header[name] = values
+			}
+		}
+		for name, values := range header {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseHeaderRules parses global headers of the form "Name=value" and path
+// headers of the form "/prefix:Name=value".
+func parseHeaderRules(global []string, paths []string) ([]headerRule, error) {
+	byPrefix := map[string]http.Header{}
+	var prefixes []string
+	add := func(prefix string, spec string) error {
+		name, value, err := parseHeader(spec)
+		if err != nil {
+			return err
+		}
+		if _, ok := byPrefix[prefix]; !ok {
+			byPrefix[prefix] = http.Header{}
+			prefixes = append(prefixes, prefix)
+		}
+		byPrefix[prefix].Add(name, value)
+		return nil
+	}
+	for _, spec := range global {
+		if err := add("/", spec); err != nil {
+			return nil, err
+		}
+	}
+	for _, spec := range paths {
+		i := strings.IndexRune(spec, ':')
+		if i <= 0 || !strings.HasPrefix(spec, "/") {
+			return nil, fmt.Errorf("invalid path header: %s", spec)
+		}
+		if err := add(spec[:i], spec[i+1:]); err != nil {
+			return nil, err
+		}
+	}
+	var rules []headerRule
+	for _, prefix := range prefixes {
+		rules = append(rules, headerRule{prefix: prefix, header: byPrefix[prefix]})
+	}
+	return rules, nil
+}
+
+// parseHeader parses a header of the form "Name=value".
+func parseHeader(spec string) (string, string, error) {
+	i := strings.IndexRune(spec, '=')
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid header: %s", spec)
+	}
+	name, value := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if !httpguts.ValidHeaderFieldName(name) {
+		return "", "", fmt.Errorf("invalid header name: %s", name)
+	}
+	if !httpguts.ValidHeaderFieldValue(value) {
+		return "", "", fmt.Errorf("invalid header value: %s", value)
+	}
+	return name, value, nil
+}
+
+// RootHeader adds an X-Serve-Root header naming the directory that serves the
+// response. It is meant for debugging only.
+func RootHeader(root string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Serve-Root", root)
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,68 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// readiness reports whether initialization has completed.
+type readiness struct {
+	ready int32
+}
+
+func (rd *readiness) setReady() { atomic.StoreInt32(&rd.ready, 1) }
+
+func (rd *readiness) isReady() bool { return atomic.LoadInt32(&rd.ready) == 1 }
+
+// Ready answers all requests with 503 and a Retry-After header until rd
+// reports ready.
+func Ready(rd *readiness, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !rd.isReady() {
+			retryError(w, r, http.StatusServiceUnavailable, 5)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Health serves the liveness at path, which succeeds as long as serve is
+// running, and the readiness at path/ready, which fails until rd reports
+// ready. All other requests are passed to h.
+func Health(path string, rd *readiness, h http.Handler) http.Handler {
+	readyPath := strings.TrimSuffix(path, "/") + "/ready"
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case path:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("ok\n"))
+		case readyPath:
+			if !rd.isReady() {
+				httpError(w, r, "not ready", http.StatusServiceUnavailable)
+				return
+			}
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte("ready\n"))
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// LimitOpenFiles bounds the number of requests, and thereby the files, that
+// are served at the same time. Requests exceeding the bound are answered with
+// 503 instead of failing with "too many open files".
+func LimitOpenFiles(max int, h http.Handler) http.Handler {
+	sem := make(chan struct{}, max)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			h.ServeHTTP(w, r)
+		default:
+			logOf(r).debugf("too many open files for %s %s", r.Method, r.URL)
+			retryError(w, r, http.StatusServiceUnavailable, 1)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"gopkg.in/yaml.v3"
+)
+
+// dirRulesFile is the name of the file holding the rules of a directory.
+const dirRulesFile = ".serve.yaml"
+
+// dirRules override the global configuration for the requests below a
+// directory. Unset fields leave the value of the parent directory in place.
+type dirRules struct {
+	// Headers are added to the responses, e.g. Cache-Control.
+	Headers map[string]string `yaml:"headers"`
+	// Auth requires or exempts requests from authentication.
+	Auth *bool `yaml:"auth"`
+	// Listing enables or disables directory listings.
+	Listing *bool `yaml:"listing"`
+}
+
+// merge returns a copy of r overridden by the rules of a subdirectory.
+func (r dirRules) merge(sub dirRules) dirRules {
+	merged := dirRules{Headers: map[string]string{}, Auth: r.Auth, Listing: r.Listing}
+	for name, value := range r.Headers {
+		merged.Headers[name] = value
+	}
+	for name, value := range sub.Headers {
+		merged.Headers[name] = value
+	}
+	if sub.Auth != nil {
+		merged.Auth = sub.Auth
+	}
+	if sub.Listing != nil {
+		merged.Listing = sub.Listing
+	}
+	return merged
+}
+
+// parseDirRules parses the YAML rules file of a directory.
+func parseDirRules(b []byte) (dirRules, error) {
+	var rules dirRules
+	if err := yaml.Unmarshal(b, &rules); err != nil {
+		return dirRules{}, err
+	}
+	for name, value := range rules.Headers {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return dirRules{}, fmt.Errorf("invalid header name: %s", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return dirRules{}, fmt.Errorf("invalid header value: %s", value)
+		}
+	}
+	return rules, nil
+}
+
+// cachedDirRules are the rules parsed from a file with modification time
+// modTime.
+type cachedDirRules struct {
+	modTime time.Time
+	rules   dirRules
+	err     error
+}
+
+// dirRulesLoader reads the rules files of the directories below root, caching
+// them until their modification time changes.
+type dirRulesLoader struct {
+	root  http.FileSystem
+	mu    sync.Mutex
+	cache map[string]cachedDirRules
+}
+
+func newDirRulesLoader(root http.FileSystem) *dirRulesLoader {
+	return &dirRulesLoader{root: root, cache: map[string]cachedDirRules{}}
+}
+
+// rules returns the rules of the directory dir merged with those of its
+// ancestors.
+func (l *dirRulesLoader) rules(dir string) (dirRules, error) {
+	var dirs []string
+	for d := dir; ; d = path.Dir(d) {
+		dirs = append(dirs, d)
+		if d == "/" {
+			break
+		}
+	}
+	var merged dirRules
+	for i := len(dirs) - 1; i >= 0; i-- {
+		name := path.Join(dirs[i], dirRulesFile)
+		rules, err := l.load(name)
+		if err != nil {
+			return dirRules{}, fmt.Errorf("%s: %w", name, err)
+		}
+		merged = merged.merge(rules)
+	}
+	return merged, nil
+}
+
+// load returns the rules of the file name, which are empty if it does not
+// exist.
+func (l *dirRulesLoader) load(name string) (dirRules, error) {
+	f, err := l.root.Open(name)
+	if os.IsNotExist(err) {
+		return dirRules{}, nil
+	}
+	if err != nil {
+		return dirRules{}, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return dirRules{}, err
+	}
+	l.mu.Lock()
+	cached, ok := l.cache[name]
+	l.mu.Unlock()
+	if ok && cached.modTime.Equal(fi.ModTime()) {
+		return cached.rules, cached.err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return dirRules{}, err
+	}
+	cached = cachedDirRules{modTime: fi.ModTime()}
+	cached.rules, cached.err = parseDirRules(b)
+	l.mu.Lock()
+	l.cache[name] = cached
+	l.mu.Unlock()
+	return cached.rules, cached.err
+}
+
+type dirRulesKey struct{}
+
+// dirRulesFrom returns the directory rules of r, or nil if there are none.
+func dirRulesFrom(r *http.Request) *dirRules {
+	rules, _ := r.Context().Value(dirRulesKey{}).(*dirRules)
+	return rules
+}
+
+// authRequired reports whether r requires authentication, which is the case
+// unless the directory rules exempt it.
+func authRequired(r *http.Request) bool {
+	if rules := dirRulesFrom(r); rules != nil && rules.Auth != nil {
+		return *rules.Auth
+	}
+	return true
+}
+
+// authForced reports whether directory rules explicitly require r to be
+// authenticated.
+func authForced(r *http.Request) bool {
+	rules := dirRulesFrom(r)
+	return rules != nil && rules.Auth != nil && *rules.Auth
+}
+
+// listingEnabled reports whether directory listings are enabled for r, def
+// unless the directory rules override it.
+func listingEnabled(r *http.Request, def bool) bool {
+	if rules := dirRulesFrom(r); rules != nil && rules.Listing != nil {
+		return *rules.Listing
+	}
+	return def
+}
+
+// rulesDir returns the directory whose rules apply to name: name itself if
+// it is a directory, even one requested without a trailing slash, and its
+// parent otherwise.
+func rulesDir(name string, isDir bool) string {
+	if isDir {
+		return name
+	}
+	return path.Dir(name)
+}
+
+// Htaccess applies the rules of the .serve.yaml files in the directories
+// leading to the requested path, with rules of deeper directories overriding
+// those of their ancestors. Headers are added to the response, while the
+// authentication and listing rules are applied by Auth, Listing and
+// DirectoryFallback. If auth is false, no authentication is configured and
+// requests requiring it are forbidden.
+func Htaccess(l *dirRulesLoader, auth bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		rules, err := l.rules(rulesDir(name, strings.HasSuffix(r.URL.Path, "/") || isDir(l.root, name)))
+		if err != nil {
+			logOf(r).errorf("load directory rules: %v", err)
+			httpError(w, r, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		// Without any realm, Auth is not in the chain to forbid them.
+		if !auth && rules.Auth != nil && *rules.Auth {
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		for name, value := range rules.Headers {
+			w.Header().Set(name, value)
+		}
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), dirRulesKey{}, &rules)))
+	})
+}
@@ -0,0 +1,143 @@
+package serve
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHtaccessNested(t *testing.T) {
+	c := DefaultConfig()
+	c.Htaccess = true
+	h := newTestHandler(t, c, map[string]string{
+		".serve.yaml":           "headers:\n  Cache-Control: no-cache\n  X-Site: root\n",
+		"a.txt":                 "a",
+		"docs/.serve.yaml":      "headers:\n  Cache-Control: max-age=60\nlisting: false\n",
+		"docs/b.txt":            "b",
+		"docs/api/.serve.yaml":  "listing: true\n",
+		"docs/api/c.txt":        "c",
+		"docs/other/d.txt":      "d",
+		"broken/.serve.yaml":    "headers: [",
+		"broken/e.txt":          "e",
+		"badheader/.serve.yaml": "headers:\n  \"Bad Name\": x\n",
+	})
+	tests := []struct {
+		path         string
+		status       int
+		cacheControl string
+		site         string
+	}{
+		{"/a.txt", http.StatusOK, "no-cache", "root"},
+		{"/", http.StatusOK, "no-cache", "root"},
+		{"/docs/b.txt", http.StatusOK, "max-age=60", "root"},
+		{"/docs/", http.StatusNotFound, "max-age=60", "root"},
+		{"/docs/other/", http.StatusNotFound, "max-age=60", "root"},
+		{"/docs/api/", http.StatusOK, "max-age=60", "root"},
+		{"/docs/api/c.txt", http.StatusOK, "max-age=60", "root"},
+		{"/broken/e.txt", http.StatusInternalServerError, "", ""},
+		{"/badheader/", http.StatusInternalServerError, "", ""},
+		// The rules files themselves are hidden.
+		{"/.serve.yaml", http.StatusNotFound, "no-cache", "root"},
+		{"/docs/.serve.yaml", http.StatusNotFound, "max-age=60", "root"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+		if got := w.Header().Get("Cache-Control"); got != tt.cacheControl {
+			t.Errorf("%s: got Cache-Control %q, want %q", tt.path, got, tt.cacheControl)
+		}
+		if got := w.Header().Get("X-Site"); got != tt.site {
+			t.Errorf("%s: got X-Site %q, want %q", tt.path, got, tt.site)
+		}
+	}
+}
+
+func TestHtaccessReloadsChangedRules(t *testing.T) {
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.Htaccess = true
+	writeFiles(t, c.Root, map[string]string{".serve.yaml": "headers:\n  X-Version: 1\n", "a.txt": "a"})
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	h.SetReady()
+	if got := get(h, "/a.txt").Header().Get("X-Version"); got != "1" {
+		t.Fatalf("got X-Version %q, want 1", got)
+	}
+	writeFiles(t, c.Root, map[string]string{".serve.yaml": "headers:\n  X-Version: 2\n"})
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(c.Root, ".serve.yaml"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(h, "/a.txt").Header().Get("X-Version"); got != "2" {
+		t.Errorf("got X-Version %q after the change, want 2", got)
+	}
+}
+
+func TestHtaccessAuth(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.Htaccess = true
+	c.Auth = "basic?realm=site&secrets=" + secrets
+	h := newTestHandler(t, c, map[string]string{
+		"private.txt":          "private",
+		"pub/.serve.yaml":      "auth: false\n",
+		"pub/p.txt":            "public",
+		"pub/sec/.serve.yaml":  "auth: true\n",
+		"pub/sec/s.txt":        "secret",
+		"pub/sec/x/index.html": "x",
+	})
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/private.txt", http.StatusUnauthorized},
+		{"/pub/p.txt", http.StatusOK},
+		{"/pub/sec/s.txt", http.StatusUnauthorized},
+		{"/pub/sec/x/", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		if w := get(h, tt.path); w.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, w.Code, tt.status)
+		}
+	}
+	r := newRequest(t, "/pub/sec/s.txt")
+	r.SetBasicAuth("admin", "secret")
+	if w := serveRequest(h, r); w.Code != http.StatusOK || w.Body.String() != "secret" {
+		t.Errorf("authenticated: got status %d and body %q", w.Code, w.Body)
+	}
+}
+
+func TestHtaccessDirectoryWithoutSlash(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.Htaccess = true
+	c.NoDirRedirect = true
+	c.Auth = "basic?realm=site&secrets=" + secrets
+	h := newTestHandler(t, c, map[string]string{
+		".serve.yaml":         "auth: false\n",
+		"index.html":          "home",
+		"private/.serve.yaml": "auth: true\nheaders:\n  X-Private: \"1\"\n",
+		"private/index.html":  "secret index",
+	})
+	for _, path := range []string{"/private", "/private/"} {
+		w := get(h, path)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d and body %q, want %d", path, w.Code, w.Body, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("X-Private"); got != "1" {
+			t.Errorf("%s: got X-Private %q", path, got)
+		}
+	}
+	if w := get(h, "/"); w.Code != http.StatusOK {
+		t.Errorf("/: got status %d", w.Code)
+	}
+}
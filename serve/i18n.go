@@ -0,0 +1,102 @@
+package serve
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// I18N serves index.fr.html instead of a requested index.html, or a
+// directory's index.html, if the client accepts fr and the variant exists.
+// Languages are tried in the order of their Accept-Language quality, a tag
+// like fr-CA before its primary language fr. Without a matching variant the
+// requested file is served.
+func I18N(fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "/") {
+			name = path.Join(name, "index.html")
+		}
+		ext := path.Ext(name)
+		if ext == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Language")
+		base := strings.TrimSuffix(name, ext)
+		for _, lang := range acceptedLanguages(r.Header.Get("Accept-Language")) {
+			for _, tag := range languageTagForms(lang) {
+				variant := base + "." + tag + ext
+				if !isFile(fs, variant) {
+					continue
+				}
+				w.Header().Set("Content-Language", tag)
+				r2 := r.Clone(r.Context())
+				r2.URL.Path = variant
+				r2.URL.RawPath = ""
+				h.ServeHTTP(w, r2)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// acceptedLanguages returns the language tags of an Accept-Language header in
+// the order they are tried, each tag followed by its truncations, e.g. de-ch
+// by de, unless those are listed themselves. The wildcard is left out.
+func acceptedLanguages(header string) []string {
+	qvs := parseQualityList(header)
+	listed := map[string]bool{}
+	for _, qv := range qvs {
+		listed[qv.value] = true
+	}
+	seen := map[string]bool{}
+	var langs []string
+	for _, qv := range qvs {
+		if !isLanguageTag(qv.value) {
+			continue
+		}
+		for tag := qv.value; tag != ""; {
+			if !seen[tag] {
+				seen[tag] = true
+				langs = append(langs, tag)
+			}
+			i := strings.LastIndexByte(tag, '-')
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+			if listed[tag] {
+				break
+			}
+		}
+	}
+	return langs
+}
+
+// languageTagForms returns the file name forms of the lower case tag lang:
+// itself and, with a region, the form with the region in upper case, e.g.
+// pt-br and pt-BR.
+func languageTagForms(lang string) []string {
+	parts := strings.Split(lang, "-")
+	if len(parts) < 2 || len(parts[1]) != 2 {
+		return []string{lang}
+	}
+	parts[1] = strings.ToUpper(parts[1])
+	return []string{lang, strings.Join(parts, "-")}
+}
+
+// isLanguageTag reports whether s is a language tag of letters, digits and
+// hyphens, and thus safe to use in a file name.
+func isLanguageTag(s string) bool {
+	if s == "" || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
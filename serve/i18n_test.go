@@ -0,0 +1,49 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestI18N(t *testing.T) {
+	c := DefaultConfig()
+	c.I18N = true
+	h := newTestHandler(t, c, map[string]string{
+		"index.html":       "en",
+		"index.fr.html":    "fr",
+		"index.fr-ca.html": "fr-ca",
+		"about.de.html":    "de",
+		"about.html":       "about",
+	})
+	tests := []struct {
+		path     string
+		accept   string
+		body     string
+		language string
+	}{
+		{"/", "fr", "fr", "fr"},
+		{"/index.html", "fr-CA, fr;q=0.8", "fr-ca", "fr-ca"},
+		{"/", "fr-BE", "fr", "fr"},
+		{"/", "es, fr;q=0.5", "fr", "fr"},
+		{"/", "es", "en", ""},
+		{"/", "", "en", ""},
+		{"/about.html", "de-DE", "de", "de"},
+		{"/about.html", "*", "about", ""},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path, "Accept-Language", tt.accept)
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("%s with %q: got status %d and body %q, want %q", tt.path, tt.accept, w.Code, w.Body, tt.body)
+			continue
+		}
+		if got := w.Header().Get("Content-Language"); got != tt.language {
+			t.Errorf("%s with %q: got Content-Language %q, want %q", tt.path, tt.accept, got, tt.language)
+		}
+		// Also the responses without a variant vary, since another
+		// language may have one.
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(vary, "Accept-Language") {
+			t.Errorf("%s with %q: got Vary %q", tt.path, tt.accept, vary)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package serve
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// imageVariants are the alternative image formats in order of preference.
+var imageVariants = []struct {
+	ctype string
+	ext   string
+}{
+	{"image/avif", ".avif"},
+	{"image/webp", ".webp"},
+}
+
+// imageExts are the extensions of images that may have variants.
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// ImageNegotiation serves photo.avif or photo.webp instead of a requested
+// photo.jpg if the client explicitly accepts the format and the variant
+// exists. Of equally accepted formats AVIF is preferred.
+func ImageNegotiation(fs http.FileSystem, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ext := strings.ToLower(path.Ext(r.URL.Path))
+		if !imageExts[ext] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept")
+		qvs := parseQualityList(r.Header.Get("Accept"))
+		base := strings.TrimSuffix(path.Clean("/"+r.URL.Path), path.Ext(r.URL.Path))
+		best, bestQ := "", 0.0
+		for _, v := range imageVariants {
+			q := 0.0
+			for _, qv := range qvs {
+				if qv.value == v.ctype {
+					q = qv.q
+				}
+			}
+			if q > bestQ && isFile(fs, base+v.ext) {
+				best, bestQ = base+v.ext, q
+			}
+		}
+		if best == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = best
+		r2.URL.RawPath = ""
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// isFile reports whether name is a regular file of fs.
+func isFile(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	return err == nil && fi.Mode().IsRegular()
+}
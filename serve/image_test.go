@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestImageNegotiation(t *testing.T) {
+	c := DefaultConfig()
+	c.ImageNegotiation = true
+	h := newTestHandler(t, c, map[string]string{
+		"both.jpg":  "jpeg",
+		"both.avif": "avif",
+		"both.webp": "webp",
+		"webp.png":  "png",
+		"webp.webp": "webp",
+		"none.jpg":  "jpeg",
+		"a.txt":     "text",
+	})
+	tests := []struct {
+		path   string
+		accept string
+		body   string
+		ctype  string
+	}{
+		{"/both.jpg", "image/avif,image/webp,image/*,*/*;q=0.8", "avif", "image/avif"},
+		{"/both.jpg", "image/webp,*/*", "webp", "image/webp"},
+		{"/both.jpg", "image/avif;q=0.5,image/webp", "webp", "image/webp"},
+		{"/both.jpg", "image/avif;q=0,image/webp;q=0", "jpeg", "image/jpeg"},
+		{"/both.jpg", "image/*", "jpeg", "image/jpeg"},
+		{"/both.jpg", "", "jpeg", "image/jpeg"},
+		{"/BOTH.JPG", "image/avif", "", ""},
+		{"/webp.png", "image/avif,image/webp", "webp", "image/webp"},
+		{"/none.jpg", "image/avif,image/webp", "jpeg", "image/jpeg"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path, "Accept", tt.accept)
+		if tt.body == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s %q: got status %d", tt.path, tt.accept, w.Code)
+			}
+			continue
+		}
+		if w.Code != http.StatusOK || w.Body.String() != tt.body {
+			t.Errorf("%s %q: got status %d and body %q, want %q", tt.path, tt.accept, w.Code, w.Body, tt.body)
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s %q: got Content-Type %q, want %q", tt.path, tt.accept, got, tt.ctype)
+		}
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(", "+vary+",", ", Accept,") {
+			t.Errorf("%s %q: got Vary %q", tt.path, tt.accept, vary)
+		}
+	}
+	w := get(h, "/a.txt", "Accept", "image/webp")
+	if vary := strings.Join(w.Header()["Vary"], ", "); strings.Contains(", "+vary+",", ", Accept,") {
+		t.Errorf("non-image: got Vary %q", vary)
+	}
+}
@@ -0,0 +1,64 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"time"
+)
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version string `json:"version"`
+	// Revision is the VCS revision the binary was built from.
+	Revision string `json:"revision,omitempty"`
+	// Time is when the binary was built.
+	Time string `json:"build_time,omitempty"`
+}
+
+// info is the JSON served by Info.
+type info struct {
+	BuildInfo
+	Module  string  `json:"module,omitempty"`
+	Go      string  `json:"go"`
+	OS      string  `json:"os"`
+	Arch    string  `json:"arch"`
+	Started string  `json:"started"`
+	Uptime  float64 `json:"uptime_seconds"`
+}
+
+// Info serves build and runtime information as JSON at path and passes all
+// other requests to h. The module version is taken from the build info
+// embedded by the go command, if any.
+func Info(path string, bi BuildInfo, started time.Time, h http.Handler) http.Handler {
+	module := ""
+	if mi, ok := debug.ReadBuildInfo(); ok {
+		module = mi.Main.Path
+		if mi.Main.Version != "" {
+			module += "@" + mi.Main.Version
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			h.ServeHTTP(w, r)
+			return
+		}
+		b, err := json.MarshalIndent(info{
+			BuildInfo: bi,
+			Module:    module,
+			Go:        runtime.Version(),
+			OS:        runtime.GOOS,
+			Arch:      runtime.GOARCH,
+			Started:   started.UTC().Format(time.RFC3339),
+			Uptime:    time.Since(started).Seconds(),
+		}, "", "  ")
+		if err != nil {
+			httpError(w, r, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(append(b, '\n'))
+	})
+}
@@ -0,0 +1,64 @@
+package serve
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+	"testing"
+)
+
+func TestInfo(t *testing.T) {
+	c := DefaultConfig()
+	c.InfoPath = "/_info"
+	c.Build = BuildInfo{Version: "1.2.3", Revision: "0123abc", Time: "2024-01-02T03:04:05Z"}
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	w := get(h, "/_info")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+		t.Fatalf("%v: %s", err, w.Body)
+	}
+	for field, want := range map[string]interface{}{
+		"version":    "1.2.3",
+		"revision":   "0123abc",
+		"build_time": "2024-01-02T03:04:05Z",
+		"go":         runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+	} {
+		if m[field] != want {
+			t.Errorf("got %s %v, want %v", field, m[field], want)
+		}
+	}
+	if uptime, ok := m["uptime_seconds"].(float64); !ok || uptime < 0 {
+		t.Errorf("got uptime_seconds %v", m["uptime_seconds"])
+	}
+	if started, ok := m["started"].(string); !ok || started == "" {
+		t.Errorf("got started %v", m["started"])
+	}
+	if w := get(h, "/a.txt"); w.Body.String() != "a" {
+		t.Errorf("other paths: got body %q", w.Body)
+	}
+}
+
+func TestInfoAuth(t *testing.T) {
+	dir := t.TempDir()
+	secrets := writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c := DefaultConfig()
+	c.InfoPath = "/_info"
+	c.AuthFor = []string{"/_info=basic?realm=ops&secrets=" + secrets}
+	h := newTestHandler(t, c, nil)
+	if w := get(h, "/_info"); w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d", w.Code)
+	}
+	r := newRequest(t, "/_info")
+	r.SetBasicAuth("admin", "secret")
+	if w := serveRequest(h, r); w.Code != http.StatusOK {
+		t.Errorf("authenticated: got status %d", w.Code)
+	}
+}
@@ -0,0 +1,314 @@
+package serve
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksRefresh is the interval at which the keys are fetched again.
+	jwksRefresh = time.Hour
+	// jwksMinRefresh bounds refetching for tokens signed with unknown keys.
+	jwksMinRefresh = time.Minute
+	// jwtLeeway is the clock skew tolerated checking exp and nbf.
+	jwtLeeway = time.Minute
+)
+
+// jwtAuth authenticates requests by a bearer JSON Web Token signed with one
+// of the keys of a JWKS URL, and optionally issued by iss for aud.
+type jwtAuth struct {
+	keys *jwks
+	aud  string
+	iss  string
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaims returns the claims of the token that authenticated r, or nil.
+func JWTClaims(r *http.Request) map[string]interface{} {
+	claims, _ := r.Context().Value(jwtClaimsKey{}).(map[string]interface{})
+	return claims
+}
+
+// newJWTAuth parses the parameters of a jwt auth urn.
+func newJWTAuth(rest string) (*jwtAuth, error) {
+	params, err := url.ParseQuery(rest)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(params.Get("jwks"))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("no jwks url specified")
+	}
+	return &jwtAuth{
+		keys: &jwks{url: u.String(), client: &http.Client{Timeout: 10 * time.Second}},
+		aud:  params.Get("aud"),
+		iss:  params.Get("iss"),
+	}, nil
+}
+
+// Wrap validates the token before calling wrapped with the subject as the
+// user name. The claims are available to later handlers via JWTClaims and,
+// for proxied upstreams, as JSON in the X-Auth-Claims header, which New
+// removes from all incoming requests.
+func (a *jwtAuth) Wrap(wrapped auth.AuthenticatedHandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == r.Header.Get("Authorization") {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		claims, err := a.validate(token, time.Now(), logOf(r))
+		if err != nil {
+			logOf(r).debugf("invalid token from %s: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			httpError(w, r, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		if b, err := json.Marshal(claims); err == nil {
+			r.Header.Set("X-Auth-Claims", string(b))
+		}
+		sub, _ := claims["sub"].(string)
+		ar := &auth.AuthenticatedRequest{
+			Request:  *r.WithContext(context.WithValue(r.Context(), jwtClaimsKey{}, claims)),
+			Username: sub,
+		}
+		wrapped(w, ar)
+	}
+}
+
+// stripAuthClaims removes the X-Auth-Claims header sent by clients, so that
+// only claims validated by a jwt realm reach handlers and proxied upstreams.
+func stripAuthClaims(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-Auth-Claims")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// jwtMethods are the signing algorithms accepted. Others, in particular none
+// and the symmetric HS ones, are refused.
+var jwtMethods = []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}
+
+// jwtMinRSABits is the smallest RSA key accepted.
+const jwtMinRSABits = 2048
+
+// validate checks the signature, the times and the audience and issuer of
+// token and returns its claims.
+func (a *jwtAuth) validate(token string, now time.Time, log *Logger) (map[string]interface{}, error) {
+	parser := jwt.NewParser(jwt.WithValidMethods(jwtMethods), jwt.WithoutClaimsValidation())
+	unverified, _, err := parser.ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return nil, err
+	}
+	kid, _ := unverified.Header["kid"].(string)
+	keys, err := a.keys.get(kid, now, log)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.MapClaims
+	for _, key := range keys {
+		claims = jwt.MapClaims{}
+		_, err = parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+			return key, checkJWTKey(t.Method, key)
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !claims.VerifyExpiresAt(now.Add(-jwtLeeway).Unix(), true) {
+		return nil, errors.New("token expired")
+	}
+	if !claims.VerifyNotBefore(now.Add(jwtLeeway).Unix(), false) {
+		return nil, errors.New("token not yet valid")
+	}
+	if a.iss != "" && !claims.VerifyIssuer(a.iss, true) {
+		return nil, fmt.Errorf("issuer %v not accepted", claims["iss"])
+	}
+	if a.aud != "" && !claims.VerifyAudience(a.aud, true) {
+		return nil, fmt.Errorf("audience %v not accepted", claims["aud"])
+	}
+	return claims, nil
+}
+
+// checkJWTKey refuses RSA keys shorter than jwtMinRSABits and EC keys on
+// another curve than the one of the ES algorithm m.
+func checkJWTKey(m jwt.SigningMethod, key crypto.PublicKey) error {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if key.N.BitLen() < jwtMinRSABits {
+			return fmt.Errorf("rsa key of %d bits", key.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		if es, ok := m.(*jwt.SigningMethodECDSA); ok && es.CurveBits != key.Curve.Params().BitSize {
+			return fmt.Errorf("curve %s for %s", key.Curve.Params().Name, m.Alg())
+		}
+	}
+	return nil
+}
+
+// jwks caches the keys of a JSON Web Key Set URL, fetching them when first
+// needed, every jwksRefresh and when a token names an unknown key.
+type jwks struct {
+	url    string
+	client *http.Client
+	flight singleflight.Group
+
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+// get returns the key with the ID kid, or all keys if kid is empty. Fetches
+// run outside the lock and are shared; while one is underway, requests
+// whose key is already known are served with the stale keys.
+func (k *jwks) get(kid string, now time.Time, log *Logger) ([]crypto.PublicKey, error) {
+	k.mu.Lock()
+	keys := k.keys
+	_, known := keys[kid]
+	usable := keys != nil && (kid == "" || known)
+	since := now.Sub(k.fetched)
+	due := k.fetched.IsZero() || since > jwksRefresh || !usable && since > jwksMinRefresh
+	k.mu.Unlock()
+	if due {
+		ch := k.flight.DoChan("", func() (interface{}, error) {
+			return k.refresh(now, log), nil
+		})
+		if !usable {
+			keys = (<-ch).Val.(map[string]crypto.PublicKey)
+		}
+	}
+	if keys == nil {
+		return nil, errors.New("no keys fetched")
+	}
+	if kid != "" {
+		if key, ok := keys[kid]; ok {
+			return []crypto.PublicKey{key}, nil
+		}
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	var all []crypto.PublicKey
+	for _, key := range keys {
+		all = append(all, key)
+	}
+	return all, nil
+}
+
+// refresh fetches the keys, keeping the previous ones if that fails, and
+// returns the current keys.
+func (k *jwks) refresh(now time.Time, log *Logger) map[string]crypto.PublicKey {
+	keys, err := k.fetch(log)
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if err != nil {
+		log.warnf("%v", err)
+	} else {
+		k.keys = keys
+	}
+	k.fetched = now
+	return k.keys
+}
+
+func (k *jwks) fetch(log *Logger) (map[string]crypto.PublicKey, error) {
+	resp, err := k.client.Get(k.url)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch jwks: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decode jwks: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, jwk := range set.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.debugf("skip jwks key %q: %v", jwk.Kid, err)
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// jsonWebKey is an RSA or EC public key of a JWKS.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	if jwk.Use != "" && jwk.Use != "sig" {
+		return nil, fmt.Errorf("use %s", jwk.Use)
+	}
+	switch jwk.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("curve %s", jwk.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("key type %s", jwk.Kty)
+}
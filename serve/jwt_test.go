@@ -0,0 +1,254 @@
+package serve
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	auth "github.com/abbot/go-http-auth"
+)
+
+// signJWT returns a token of claims signed by key with alg, RS256 or ES256.
+func signJWT(t *testing.T, alg string, kid string, key crypto.Signer, claims map[string]interface{}) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		var r, s *big.Int
+		r, s, err = ecdsa.Sign(rand.Reader, key, digest[:])
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// newJWKSServer serves a JWKS of the public keys of rsaKey and ecKey.
+func newJWKSServer(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *httptest.Server {
+	t.Helper()
+	enc := base64.RawURLEncoding.EncodeToString
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": enc(rsaKey.N.Bytes()), "e": enc(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": enc(ecKey.X.FillBytes(make([]byte, 32))), "y": enc(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": enc(rsaKey.N.Bytes()), "e": "AQAB"},
+	}}
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestJWTAuth(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := newJWKSServer(t, rsaKey, ecKey)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get("X-Auth-Claims")))
+	}))
+	defer upstream.Close()
+	c := DefaultConfig()
+	c.AuthFor = []string{"/api/=jwt?jwks=" + jwksServer.URL + "&aud=app&iss=https://idp"}
+	c.Proxy = []string{"/api=" + upstream.URL}
+	c.Logger = &Logger{Level: LevelError}
+	h := newTestHandler(t, c, nil)
+
+	now := time.Now().Unix()
+	claims := func(modify func(map[string]interface{})) map[string]interface{} {
+		m := map[string]interface{}{"sub": "alice", "iss": "https://idp", "aud": "app", "exp": now + 60}
+		if modify != nil {
+			modify(m)
+		}
+		return m
+	}
+	valid := signJWT(t, "RS256", "rsa", rsaKey, claims(nil))
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"admin","iss":"https://idp","aud":"app","exp":9999999999}`)) + "." + parts[2]
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sig[0] ^= 1
+	badSig := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"rsa", valid, true},
+		{"ec", signJWT(t, "ES256", "ec", ecKey, claims(nil)), true},
+		{"no kid", signJWT(t, "RS256", "", rsaKey, claims(nil)), true},
+		{"aud list", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["aud"] = []string{"other", "app"} })), true},
+		{"leeway", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["exp"] = now - 30 })), true},
+		{"tampered payload", tampered, false},
+		{"tampered signature", badSig, false},
+		{"alg none", none, false},
+		{"alg mismatch", signJWT(t, "ES256", "rsa", ecKey, claims(nil)), false},
+		{"other key", signJWT(t, "RS256", "rsa", otherKey, claims(nil)), false},
+		{"unknown kid", signJWT(t, "RS256", "unknown", rsaKey, claims(nil)), false},
+		{"enc key", signJWT(t, "RS256", "enc", rsaKey, claims(nil)), false},
+		{"expired", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["exp"] = now - 120 })), false},
+		{"no exp", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { delete(m, "exp") })), false},
+		{"not yet valid", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["nbf"] = now + 120 })), false},
+		{"wrong aud", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["aud"] = "other" })), false},
+		{"wrong iss", signJWT(t, "RS256", "rsa", rsaKey, claims(func(m map[string]interface{}) { m["iss"] = "https://evil" })), false},
+		{"malformed", "a.b", false},
+	}
+	for _, tt := range tests {
+		w := get(h, "/api/x", "Authorization", "Bearer "+tt.token)
+		if tt.ok {
+			if w.Code != http.StatusOK {
+				t.Errorf("%s: got status %d", tt.name, w.Code)
+				continue
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got["sub"] != "alice" {
+				t.Errorf("%s: got claims %q", tt.name, w.Body)
+			}
+			continue
+		}
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, http.StatusUnauthorized)
+		}
+		if got := w.Header().Get("WWW-Authenticate"); got != `Bearer error="invalid_token"` {
+			t.Errorf("%s: got challenge %q", tt.name, got)
+		}
+	}
+
+	for _, header := range [][]string{nil, {"Authorization", "Basic YTpi"}} {
+		w := get(h, "/api/x", header...)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%v: got status %d and challenge %q", header, w.Code, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := newJWTAuth("jwks=" + newJWKSServer(t, rsaKey, ecKey).URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	var user string
+	h := a.Wrap(func(w http.ResponseWriter, ar *auth.AuthenticatedRequest) {
+		claims, user = JWTClaims(&ar.Request), ar.Username
+	})
+	token := signJWT(t, "RS256", "rsa", rsaKey, map[string]interface{}{"sub": "alice", "role": "admin", "exp": time.Now().Unix() + 60})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	h(httptest.NewRecorder(), r)
+	if user != "alice" || claims["role"] != "admin" {
+		t.Errorf("got user %q and claims %v", user, claims)
+	}
+	if got := JWTClaims(r); got != nil {
+		t.Errorf("got claims %v of an unauthenticated request", got)
+	}
+}
+
+func TestJWTAuthInvalid(t *testing.T) {
+	for _, rest := range []string{"", "jwks=", "jwks=ftp://host/keys", "jwks=https://"} {
+		if _, err := newJWTAuth(rest); err == nil {
+			t.Errorf("%q: got no error", rest)
+		}
+	}
+}
+
+func TestJWTKeyChecks(t *testing.T) {
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	a := &jwtAuth{keys: &jwks{
+		keys:    map[string]crypto.PublicKey{"small": &small.PublicKey, "p384": &p384.PublicKey},
+		fetched: now,
+	}}
+	claims := map[string]interface{}{"sub": "alice", "exp": now.Add(time.Hour).Unix()}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"ES256","kid":"p384"}`))
+	payload, _ := json.Marshal(claims)
+	for name, token := range map[string]string{
+		"small rsa key": signJWT(t, "RS256", "small", small, claims),
+		"curve of alg":  header + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + strings.Repeat("A", 86),
+	} {
+		if _, err := a.validate(token, now, &Logger{Level: LevelError}); err == nil {
+			t.Errorf("%s: token accepted", name)
+		}
+	}
+}
+
+func TestJWKSStaleWhileFetching(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"keys":[]}`))
+	}))
+	defer s.Close()
+	defer close(release)
+	k := &jwks{
+		url:     s.URL,
+		client:  s.Client(),
+		keys:    map[string]crypto.PublicKey{"rsa": &rsaKey.PublicKey},
+		fetched: time.Now().Add(-2 * jwksRefresh),
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := k.get("rsa", time.Now(), &Logger{Level: LevelError})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get waited for the refresh of a known key")
+	}
+}
@@ -0,0 +1,145 @@
+package serve
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ListenOptions configure the listening socket.
+type ListenOptions struct {
+	// ReusePort enables SO_REUSEPORT so that multiple processes can serve
+	// the same port.
+	ReusePort bool
+	// Backlog is the size of the accept queue, the system default if 0.
+	Backlog int
+	// KeepAlive is the TCP keep-alive period of accepted connections.
+	// Keep-alives are disabled if negative.
+	KeepAlive time.Duration
+	// MaxConnsPerIP limits the open connections per client IP if positive.
+	MaxConnsPerIP int
+	// TrustedProxies are exempt from MaxConnsPerIP.
+	TrustedProxies []*net.IPNet
+	// TLS serves TLS on the listener if not nil.
+	TLS *tls.Config
+	// AutoPort is the number of following ports that are tried if the port
+	// of the address is in use.
+	AutoPort int
+	// Logger logs ports switched to and refused connections.
+	Logger *Logger
+}
+
+// Listen announces on the TCP address addr.
+func Listen(addr string, opts ListenOptions) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: opts.KeepAlive}
+	if opts.ReusePort {
+		lc.Control = controlReusePort
+	}
+	ln, err := listenAutoPort(lc, addr, opts.AutoPort, opts.Logger)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Backlog > 0 {
+		if err := setBacklog(ln.(*net.TCPListener), opts.Backlog); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	}
+	if opts.MaxConnsPerIP > 0 {
+		ln = limitConnsPerIP(ln, opts.MaxConnsPerIP, opts.TrustedProxies, opts.Logger)
+	}
+	if opts.TLS != nil {
+		ln = tls.NewListener(ln, opts.TLS)
+	}
+	return ln, nil
+}
+
+// listenAutoPort listens on addr or, while the port is in use, on up to
+// tries following ports.
+func listenAutoPort(lc net.ListenConfig, addr string, tries int, log *Logger) (net.Listener, error) {
+	ln, err := lc.Listen(context.Background(), "tcp", addr)
+	if err == nil || tries <= 0 || !errors.Is(err, syscall.EADDRINUSE) {
+		return ln, err
+	}
+	host, port, serr := net.SplitHostPort(addr)
+	p, perr := strconv.Atoi(port)
+	if serr != nil || perr != nil || p == 0 {
+		return nil, err
+	}
+	for i := 1; i <= tries && p+i <= 65535; i++ {
+		next := net.JoinHostPort(host, strconv.Itoa(p+i))
+		ln, nerr := lc.Listen(context.Background(), "tcp", next)
+		if nerr == nil {
+			log.warnf("%s is in use, listening on %s instead", addr, next)
+			return ln, nil
+		}
+		if !errors.Is(nerr, syscall.EADDRINUSE) {
+			return nil, nerr
+		}
+	}
+	return nil, err
+}
+
+// perIPListener closes accepted connections from IP addresses that already
+// have max open connections, unless they are exempt.
+type perIPListener struct {
+	net.Listener
+	max    int
+	exempt []*net.IPNet
+	log    *Logger
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+func limitConnsPerIP(ln net.Listener, max int, exempt []*net.IPNet, log *Logger) net.Listener {
+	return &perIPListener{Listener: ln, max: max, exempt: exempt, log: log, conns: map[string]int{}}
+}
+
+func (l *perIPListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(c.RemoteAddr().String())
+		if ip == nil || containsIP(l.exempt, ip) {
+			return c, nil
+		}
+		key := ip.String()
+		l.mu.Lock()
+		if l.conns[key] >= l.max {
+			l.mu.Unlock()
+			l.log.debugf("refused connection from %s: too many connections", key)
+			c.Close()
+			continue
+		}
+		l.conns[key]++
+		l.mu.Unlock()
+		return &perIPConn{Conn: c, release: func() { l.release(key) }}, nil
+	}
+}
+
+func (l *perIPListener) release(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.conns[key]--; l.conns[key] <= 0 {
+		delete(l.conns, key)
+	}
+}
+
+type perIPConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *perIPConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
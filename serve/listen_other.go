@@ -0,0 +1,19 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package serve
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"syscall"
+)
+
+func controlReusePort(network, address string, c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on %s", runtime.GOOS)
+}
+
+func setBacklog(ln *net.TCPListener, backlog int) error {
+	return fmt.Errorf("setting the backlog is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,110 @@
+package serve
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// greet accepts the connections of ln, greeting each with a line and
+// passing it to accepted.
+func greet(ln net.Listener, accepted chan<- net.Conn) {
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		io.WriteString(c, "hello\n")
+		accepted <- c
+	}
+}
+
+// greeted reports whether a new connection to addr is greeted rather than
+// closed.
+func greeted(t *testing.T, addr string) (net.Conn, bool) {
+	t.Helper()
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		c.Close()
+		return nil, false
+	}
+	return c, line == "hello\n"
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", ListenOptions{MaxConnsPerIP: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 10)
+	go greet(ln, accepted)
+	addr := ln.Addr().String()
+	for i := 0; i < 2; i++ {
+		c, ok := greeted(t, addr)
+		if !ok {
+			t.Fatalf("connection %d refused", i+1)
+		}
+		defer c.Close()
+	}
+	if c, ok := greeted(t, addr); ok {
+		c.Close()
+		t.Fatal("third connection accepted")
+	}
+	// Closing a connection on the server makes room for another.
+	(<-accepted).Close()
+	c, ok := greeted(t, addr)
+	if !ok {
+		t.Fatal("connection refused after one was closed")
+	}
+	c.Close()
+}
+
+func TestMaxConnsPerIPTrustedProxy(t *testing.T) {
+	trusted, err := ParseCIDRs("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := Listen("127.0.0.1:0", ListenOptions{MaxConnsPerIP: 1, TrustedProxies: trusted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go greet(ln, make(chan net.Conn, 10))
+	for i := 0; i < 3; i++ {
+		c, ok := greeted(t, ln.Addr().String())
+		if !ok {
+			t.Fatalf("connection %d of a trusted proxy refused", i+1)
+		}
+		defer c.Close()
+	}
+}
+
+func TestListenAutoPort(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	addr := taken.Addr().(*net.TCPAddr)
+	if _, err := Listen(addr.String(), ListenOptions{}); err == nil {
+		t.Fatal("got no error listening on a port in use")
+	}
+	ln, err := Listen(addr.String(), ListenOptions{AutoPort: 10, Logger: &Logger{Level: LevelError}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	got := ln.Addr().(*net.TCPAddr)
+	if !got.IP.Equal(addr.IP) || got.Port <= addr.Port || got.Port > addr.Port+10 {
+		t.Errorf("got %s, want a following port of %s", got, addr)
+	}
+}
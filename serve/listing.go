@@ -0,0 +1,273 @@
+package serve
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<base href="{{.Base}}">
+<title>{{.Path}}</title>
+<style>{{.Style}}</style>
+</head>
+<body class="theme-{{.Theme}}">
+<h1>{{range .Breadcrumbs}}<a href="{{.Href}}">{{.Name}}</a>{{end}}</h1>
+<table>
+<thead>
+<tr><th><a href="?sort=name">Name</a></th><th><a href="?sort=size">Size</a></th><th><a href="?sort=modified">Modified</a></th></tr>
+</thead>
+<tbody>
+{{if .Parent}}<tr><td><span class="icon icon-folder"></span><a href="{{.Parent}}">..</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><span class="icon icon-{{.Icon}}"></span><a href="{{.Href}}">{{.Name}}</a></td><td>{{if .SizeHuman}}{{.SizeHuman}}{{else}}{{.Size}}{{end}}</td><td>{{.Modified}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+type listing struct {
+	Path    string         `json:"path"`
+	Entries []listingEntry `json:"entries"`
+
+	// Base is the URL of the directory, against which the relative links
+	// resolve even if the listing was served without a trailing slash.
+	Base        string       `json:"-"`
+	Breadcrumbs []breadcrumb `json:"-"`
+	Parent      string       `json:"-"`
+	Theme       string       `json:"-"`
+	Style       template.CSS `json:"-"`
+}
+
+// breadcrumb links to an ancestor of a listed directory.
+type breadcrumb struct {
+	Name string
+	Href string
+}
+
+// breadcrumbs returns links to the root and every directory on the way to
+// the directory dir, which ends with a slash.
+func breadcrumbs(dir string) []breadcrumb {
+	crumbs := []breadcrumb{{Name: "/", Href: "/"}}
+	href := "/"
+	for _, seg := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if seg == "" {
+			continue
+		}
+		href += seg + "/"
+		crumbs = append(crumbs, breadcrumb{Name: seg + "/", Href: (&url.URL{Path: href}).String()})
+	}
+	return crumbs
+}
+
+type listingEntry struct {
+	Name      string `json:"name"`
+	Href      string `json:"href"`
+	Size      int64  `json:"size"`
+	SizeHuman string `json:"sizeHuman,omitempty"`
+	Modified  string `json:"modified"`
+	Icon      string `json:"-"`
+
+	modTime time.Time
+}
+
+// listingOptions configure the rendering of directory listings.
+type listingOptions struct {
+	// sort is the default order of the entries.
+	sort listingSort
+	// humanSizes renders sizes in human readable units.
+	humanSizes bool
+	// theme is the name of the color theme.
+	theme string
+}
+
+// listingSort describes the order of a directory listing.
+type listingSort struct {
+	key  string
+	desc bool
+}
+
+// parseListingSort parses a sort order of the form "name", "size" or
+// "modified", optionally prefixed by "-" for descending order.
+func parseListingSort(s string) (listingSort, error) {
+	ls := listingSort{key: s}
+	if strings.HasPrefix(s, "-") {
+		ls.key, ls.desc = s[1:], true
+	}
+	switch ls.key {
+	case "name", "size", "modified":
+		return ls, nil
+	default:
+		return listingSort{}, fmt.Errorf("unknown sort order: %s", s)
+	}
+}
+
+func (ls listingSort) less(a, b listingEntry) bool {
+	if ls.desc {
+		a, b = b, a
+	}
+	switch ls.key {
+	case "size":
+		if a.Size != b.Size {
+			return a.Size < b.Size
+		}
+	case "modified":
+		if !a.modTime.Equal(b.modTime) {
+			return a.modTime.Before(b.modTime)
+		}
+	}
+	return a.Name < b.Name
+}
+
+// Listing renders listings of directories that have no index.html and passes
+// all other requests to h. The order of the entries can be overridden per
+// request with the sort query parameter. Clients accepting application/json
+// receive the listing as JSON, so listings vary by Accept.
+func Listing(fs http.FileSystem, opts listingOptions, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/") || !isDirWithoutIndex(fs, r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !listingEnabled(r, true) {
+			httpError(w, r, "404 page not found", http.StatusNotFound)
+			return
+		}
+		o := opts.sort
+		if s := r.URL.Query().Get("sort"); s != "" {
+			var err error
+			if o, err = parseListingSort(s); err != nil {
+				httpError(w, r, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		l, err := readListing(fs, r.URL.Path, o)
+		if err != nil {
+			httpError(w, r, "Error reading directory", http.StatusInternalServerError)
+			return
+		}
+		if opts.humanSizes {
+			for i := range l.Entries {
+				l.Entries[i].SizeHuman = humanizeBytes(l.Entries[i].Size)
+			}
+		}
+		// The same URL is served as JSON or HTML by Accept, also when not
+		// modified.
+		addVary(w.Header(), "Accept")
+		asJSON := strings.Contains(r.Header.Get("Accept"), "application/json")
+		etag := listingETag(l, asJSON, o, opts)
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if asJSON {
+			w.Header().Set("Content-Type", "application/json")
+			if r.Method != http.MethodHead {
+				json.NewEncoder(w).Encode(l)
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if r.Method == http.MethodHead {
+			return
+		}
+		l.Base = (&url.URL{Path: r.URL.Path}).String()
+		l.Breadcrumbs = breadcrumbs(r.URL.Path)
+		if r.URL.Path != "/" {
+			l.Parent = "../"
+		}
+		l.Theme, l.Style = opts.theme, listingStyle
+		listingTemplate.Execute(w, l)
+	})
+}
+
+// listingETag returns a weak ETag of the listing l in the order o, which
+// changes whenever an entry is added, removed or modified, or the listing is
+// sorted or rendered differently.
+func listingETag(l listing, asJSON bool, o listingSort, opts listingOptions) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%t %t %s %s %t\n", asJSON, opts.humanSizes, opts.theme, o.key, o.desc)
+	for _, e := range l.Entries {
+		fmt.Fprintf(hash, "%q %d %d\n", e.Name, e.Size, e.modTime.UnixNano())
+	}
+	return fmt.Sprintf(`W/"%x"`, hash.Sum(nil)[:16])
+}
+
+// etagMatches reports whether the If-None-Match header matches etag by weak
+// comparison.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func readListing(fs http.FileSystem, name string, order listingSort) (listing, error) {
+	f, err := fs.Open(path.Clean("/" + name))
+	if err != nil {
+		return listing{}, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(-1)
+	if err != nil {
+		return listing{}, err
+	}
+	l := listing{Path: name}
+	for _, fi := range infos {
+		l.Entries = append(l.Entries, newListingEntry(fi))
+	}
+	sort.SliceStable(l.Entries, func(i, j int) bool {
+		return order.less(l.Entries[i], l.Entries[j])
+	})
+	return l, nil
+}
+
+// newListingEntry describes fi. Its Href escapes the name as a relative URL
+// path: spaces, #, ?, % and non-ASCII characters are percent-encoded, and
+// names with a colon, e.g. x:y.txt, are prefixed with ./ so they are not
+// mistaken for a URL scheme, which url.PathEscape would not prevent.
+func newListingEntry(fi os.FileInfo) listingEntry {
+	name := fi.Name()
+	if fi.IsDir() {
+		name += "/"
+	}
+	return listingEntry{
+		Name:     name,
+		Href:     (&url.URL{Path: name}).String(),
+		Size:     fi.Size(),
+		Modified: fi.ModTime().Format(time.RFC3339),
+		Icon:     iconFor(fi.Name(), fi.IsDir()),
+		modTime:  fi.ModTime(),
+	}
+}
+
+// humanizeBytes formats n in base-1024 units with one decimal place.
+func humanizeBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	v, exp := float64(n)/unit, 0
+	// Values that would be rounded up to 1024.0 take the next unit.
+	for v >= unit-0.05 && exp < len("KMGTPE")-1 {
+		v /= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", v, "KMGTPE"[exp])
+}
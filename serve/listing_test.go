@@ -0,0 +1,312 @@
+package serve
+
+import (
+	"encoding/json"
+	"html"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestListingVary(t *testing.T) {
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"d/a.txt": "a"})
+	html := get(h, "/d/")
+	if html.Code != http.StatusOK || !strings.HasPrefix(html.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("HTML: got status %d and Content-Type %q", html.Code, html.Header().Get("Content-Type"))
+	}
+	js := get(h, "/d/", "Accept", "application/json")
+	var l listing
+	if err := json.Unmarshal(js.Body.Bytes(), &l); err != nil || len(l.Entries) != 1 || l.Entries[0].Name != "a.txt" {
+		t.Fatalf("JSON: got %s (%v)", js.Body, err)
+	}
+	notModified := get(h, "/d/", "If-None-Match", html.Header().Get("ETag"))
+	if notModified.Code != http.StatusNotModified {
+		t.Fatalf("got status %d, want %d", notModified.Code, http.StatusNotModified)
+	}
+	for name, w := range map[string]http.ResponseWriter{"HTML": html, "JSON": js, "not modified": notModified} {
+		if vary := strings.Join(w.Header()["Vary"], ", "); !strings.Contains(", "+vary+",", ", Accept,") {
+			t.Errorf("%s: got Vary %q", name, vary)
+		}
+	}
+	if html.Header().Get("ETag") == js.Header().Get("ETag") {
+		t.Error("HTML and JSON listings have the same ETag")
+	}
+}
+
+// listingNames returns the names of the JSON listing of the request target.
+func listingNames(t *testing.T, h http.Handler, target string) []string {
+	t.Helper()
+	r := newRequest(t, target)
+	r.Header.Set("Accept", "application/json")
+	w := serveRequest(h, r)
+	var l listing
+	if err := json.Unmarshal(w.Body.Bytes(), &l); err != nil {
+		t.Fatalf("%s: got status %d and body %s", target, w.Code, w.Body)
+	}
+	var names []string
+	for _, e := range l.Entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestListingSort(t *testing.T) {
+	dir := t.TempDir()
+	// b is the largest and oldest, c the smallest and newest.
+	writeFiles(t, dir, map[string]string{"a.txt": "aa", "b.txt": "bbb", "c.txt": "c"})
+	now := time.Now()
+	for name, age := range map[string]time.Duration{"a.txt": time.Hour, "b.txt": 2 * time.Hour, "c.txt": 0} {
+		if err := os.Chtimes(filepath.Join(dir, name), now.Add(-age), now.Add(-age)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		sort string
+		want string
+	}{
+		{"name", "a.txt,b.txt,c.txt"},
+		{"-name", "c.txt,b.txt,a.txt"},
+		{"size", "c.txt,a.txt,b.txt"},
+		{"-size", "b.txt,a.txt,c.txt"},
+		{"modified", "b.txt,a.txt,c.txt"},
+		{"-modified", "c.txt,a.txt,b.txt"},
+	}
+	for _, tt := range tests {
+		c := DefaultConfig()
+		c.Root = dir
+		c.ListingSort = tt.sort
+		h, err := New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.SetReady()
+		if got := strings.Join(listingNames(t, h, "/"), ","); got != tt.want {
+			t.Errorf("-listing-sort %s: got %s, want %s", tt.sort, got, tt.want)
+		}
+		// The query overrides the default.
+		if got := strings.Join(listingNames(t, h, "/?sort=-name"), ","); got != "c.txt,b.txt,a.txt" {
+			t.Errorf("-listing-sort %s with ?sort=-name: got %s", tt.sort, got)
+		}
+		h.Close()
+	}
+}
+
+func TestListingSortInvalid(t *testing.T) {
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"a.txt": "a"})
+	if w := serveRequest(h, newRequest(t, "/?sort=color")); w.Code != http.StatusBadRequest {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.ListingSort = "-color"
+	if h, err := New(c); err == nil {
+		h.Close()
+		t.Error("got no error for an unknown default sort order")
+	}
+}
+
+func TestBreadcrumbs(t *testing.T) {
+	tests := []struct {
+		dir  string
+		want []breadcrumb
+	}{
+		{"/", []breadcrumb{{"/", "/"}}},
+		{"/a/", []breadcrumb{{"/", "/"}, {"a/", "/a/"}}},
+		{"/a/b c/d%/", []breadcrumb{{"/", "/"}, {"a/", "/a/"}, {"b c/", "/a/b%20c/"}, {"d%/", "/a/b%20c/d%25/"}}},
+	}
+	for _, tt := range tests {
+		got := breadcrumbs(tt.dir)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.dir, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.dir, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestListingNavigation(t *testing.T) {
+	files := map[string]string{"a/b/c.txt": "c"}
+	h := newTestHandler(t, DefaultConfig(), files)
+	body := get(h, "/a/b/").Body.String()
+	for _, want := range []string{`<a href="/">/</a><a href="/a/">a/</a><a href="/a/b/">b/</a>`, `<a href="../">..</a>`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("nested listing lacks %s", want)
+		}
+	}
+	if body := get(h, "/").Body.String(); strings.Contains(body, `<a href="../">`) {
+		t.Error("root listing links to its parent")
+	}
+
+	// Mounted below a base, the crumbs lead there.
+	c := DefaultConfig()
+	c.RewriteBase = "/app"
+	h = newTestHandler(t, c, files)
+	body = get(h, "/a/b/").Body.String()
+	if want := `<a href="/app/">/</a><a href="/app/a/">a/</a><a href="/app/a/b/">b/</a>`; !strings.Contains(body, want) {
+		t.Errorf("listing below /app lacks %s:\n%s", want, body)
+	}
+}
+
+func TestListingHead(t *testing.T) {
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"d/a.txt": "a"})
+	for _, accept := range []string{"text/html", "application/json"} {
+		r := newRequest(t, "/d/")
+		r.Method = http.MethodHead
+		r.Header.Set("Accept", accept)
+		w := serveRequest(h, r)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("%s: got status %d and %d bytes", accept, w.Code, w.Body.Len())
+		}
+		if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, accept) {
+			t.Errorf("%s: got Content-Type %q", accept, got)
+		}
+	}
+}
+
+func TestListingSpecialNames(t *testing.T) {
+	names := []string{"a b.txt", "c#d.txt", "e?f.txt", "g%h.txt", "g%41.txt", "ü ñ.txt", "x:y.txt", "<i>&amp;.txt", `q"uote'.txt`}
+	files := map[string]string{}
+	for _, name := range names {
+		files["d/"+name] = name
+	}
+	h := newTestHandler(t, DefaultConfig(), files)
+	r := newRequest(t, "/d/")
+	r.Header.Set("Accept", "text/html")
+	w := serveRequest(h, r)
+	entries := regexp.MustCompile(`<a href="([^"]*)">([^<]*)</a></td>`).FindAllStringSubmatch(w.Body.String(), -1)
+	// The first entry links to the parent.
+	if len(entries) != len(names)+1 {
+		t.Fatalf("got %d entries: %s", len(entries), w.Body)
+	}
+	base, _ := url.Parse("http://localhost/d/")
+	seen := map[string]bool{}
+	for _, e := range entries[1:] {
+		href, name := html.UnescapeString(e[1]), html.UnescapeString(e[2])
+		seen[name] = true
+		ref, err := url.Parse(href)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		target := base.ResolveReference(ref)
+		if target.Host != "localhost" {
+			t.Errorf("%s: href %q leaves the server", name, href)
+			continue
+		}
+		if w := serveRequest(h, newRequest(t, target.RequestURI())); w.Code != http.StatusOK || w.Body.String() != name {
+			t.Errorf("%s: href %q got status %d and body %q", name, href, w.Code, w.Body)
+		}
+	}
+	for _, name := range names {
+		if !seen[name] {
+			t.Errorf("%s not displayed", name)
+		}
+	}
+}
+
+func TestListingETag(t *testing.T) {
+	h, root := newTestHandlerRoot(t, DefaultConfig(), map[string]string{"d/a.txt": "a"})
+	etag := func() string {
+		t.Helper()
+		w := get(h, "/d/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+		etag := w.Header().Get("ETag")
+		if !strings.HasPrefix(etag, `W/"`) {
+			t.Fatalf("got ETag %q", etag)
+		}
+		if w := get(h, "/d/", "If-None-Match", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Fatalf("repeated: got status %d and %d bytes", w.Code, w.Body.Len())
+		}
+		return etag
+	}
+	first := etag()
+	if w := get(h, "/d/", "If-None-Match", `"other", `+strings.TrimPrefix(first, "W/")); w.Code != http.StatusNotModified {
+		t.Errorf("strong form in a list: got status %d", w.Code)
+	}
+	if w := get(h, "/d/", "If-None-Match", first, "Accept", "application/json"); w.Code != http.StatusOK {
+		t.Errorf("JSON with the HTML ETag: got status %d", w.Code)
+	}
+	check := func(change string) {
+		t.Helper()
+		if w := get(h, "/d/", "If-None-Match", first); w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", change, w.Code)
+		}
+		first = etag()
+	}
+	writeFiles(t, root, map[string]string{"d/b.txt": "b"})
+	check("added")
+	writeFiles(t, root, map[string]string{"d/b.txt": "bb"})
+	check("resized")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "d", "b.txt"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	check("touched")
+	if err := os.Remove(filepath.Join(root, "d", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	check("removed")
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1, "1 B"},
+		{1023, "1023 B"},
+		{1024, "1.0 KB"},
+		{1536, "1.5 KB"},
+		{1<<20 - 1, "1.0 MB"},
+		{1 << 20, "1.0 MB"},
+		{1<<30 - 1, "1.0 GB"},
+		{5 << 30, "5.0 GB"},
+		{1 << 40, "1.0 TB"},
+		{1 << 50, "1.0 PB"},
+		{1 << 60, "1.0 EB"},
+		{math.MaxInt64, "8.0 EB"},
+	}
+	for _, tt := range tests {
+		if got := humanizeBytes(tt.n); got != tt.want {
+			t.Errorf("humanizeBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestListingETagSort(t *testing.T) {
+	// With a single entry, all orders list the same entries.
+	h := newTestHandler(t, DefaultConfig(), map[string]string{"d/a.txt": "a"})
+	etags := map[string]string{}
+	for _, sort := range []string{"name", "-name", "size", "-modified"} {
+		w := serveRequest(h, newRequest(t, "/d/?sort="+sort))
+		etag := w.Header().Get("ETag")
+		if w.Code != http.StatusOK || etag == "" {
+			t.Fatalf("%s: got status %d and ETag %q", sort, w.Code, etag)
+		}
+		for other, e := range etags {
+			if e == etag {
+				t.Errorf("%s and %s: got the same ETag %s", sort, other, etag)
+			}
+			r := newRequest(t, "/d/?sort="+sort)
+			r.Header.Set("If-None-Match", e)
+			if w := serveRequest(h, r); w.Code != http.StatusOK {
+				t.Errorf("%s with the ETag of %s: got status %d", sort, other, w.Code)
+			}
+		}
+		etags[sort] = etag
+	}
+}
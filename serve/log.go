@@ -0,0 +1,124 @@
+package serve
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// statusRecorder records the status, the number of bytes written and the
+// first write error.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+	err    error
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Level is the verbosity of the internal logger.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = map[string]Level{
+	"error": LevelError,
+	"warn":  LevelWarn,
+	"info":  LevelInfo,
+	"debug": LevelDebug,
+}
+
+// ParseLevel parses the name of a level.
+func ParseLevel(s string) (Level, error) {
+	l, ok := levelNames[strings.ToLower(s)]
+	if !ok {
+		return 0, fmt.Errorf("unknown log level: %s", s)
+	}
+	return l, nil
+}
+
+// Logger logs messages up to its level with the standard logger. A nil
+// Logger logs errors and warnings.
+type Logger struct {
+	Level Level
+}
+
+// Enabled reports whether messages of level are logged.
+func (l *Logger) Enabled(level Level) bool {
+	if l == nil {
+		return level <= LevelWarn
+	}
+	return level <= l.Level
+}
+
+// Logf logs at the given level.
+func (l *Logger) Logf(level Level, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+func (l *Logger) errorf(format string, args ...interface{}) { l.Logf(LevelError, format, args...) }
+func (l *Logger) warnf(format string, args ...interface{})  { l.Logf(LevelWarn, format, args...) }
+func (l *Logger) infof(format string, args ...interface{})  { l.Logf(LevelInfo, format, args...) }
+func (l *Logger) debugf(format string, args ...interface{}) { l.Logf(LevelDebug, format, args...) }
+
+// sensitiveHeaders are redacted when dumping headers.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+}
+
+// DumpHeaders logs the request headers and the response headers that were set
+// while handling the request. Credentials are redacted unless unsafe is set.
+func DumpHeaders(unsafe bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(withOptional(rec, w), r)
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "%s %s from %s", r.Method, r.URL, r.RemoteAddr)
+		writeHeaders(b, "> ", r.Header, unsafe)
+		fmt.Fprintf(b, "\n< %d", rec.status)
+		writeHeaders(b, "< ", w.Header(), unsafe)
+		log.Print(b.String())
+	})
+}
+
+func writeHeaders(b *strings.Builder, prefix string, header http.Header, unsafe bool) {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range header[name] {
+			if !unsafe && sensitiveHeaders[name] {
+				value = "***redacted***"
+			}
+			fmt.Fprintf(b, "\n%s%s: %s", prefix, name, value)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package serve
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+)
+
+// bufferedWriter decouples writers from the slow underlying writer. Writes
+// are handed to a background goroutine, which buffers them and flushes the
+// buffer when it is full and every interval.
+type bufferedWriter struct {
+	mu     sync.RWMutex
+	closed bool
+	lines  chan []byte
+	done   chan struct{}
+}
+
+func newBufferedWriter(w io.Writer, size int, interval time.Duration) *bufferedWriter {
+	bw := &bufferedWriter{
+		lines: make(chan []byte, 1024),
+		done:  make(chan struct{}),
+	}
+	go bw.run(bufio.NewWriterSize(w, size), interval)
+	return bw
+}
+
+func (bw *bufferedWriter) run(w *bufio.Writer, interval time.Duration) {
+	defer close(bw.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case line, ok := <-bw.lines:
+			if !ok {
+				w.Flush()
+				return
+			}
+			w.Write(line)
+		case <-ticker.C:
+			w.Flush()
+		}
+	}
+}
+
+// Write queues a copy of b. Writes after Close are dropped.
+func (bw *bufferedWriter) Write(b []byte) (int, error) {
+	bw.mu.RLock()
+	defer bw.mu.RUnlock()
+	if bw.closed {
+		return 0, io.ErrClosedPipe
+	}
+	bw.lines <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+// Close writes all queued lines, flushes the buffer and waits for the
+// background goroutine to finish.
+func (bw *bufferedWriter) Close() error {
+	bw.mu.Lock()
+	if !bw.closed {
+		bw.closed = true
+		close(bw.lines)
+	}
+	bw.mu.Unlock()
+	<-bw.done
+	return nil
+}
@@ -0,0 +1,75 @@
+package serve
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLogBufferFlushedOnClose(t *testing.T) {
+	const n = 500
+	var console syncBuffer
+	file := filepath.Join(t.TempDir(), "access.log")
+	c := DefaultConfig()
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogOutput = &console
+	c.LogFiles = []string{"json:" + file}
+	c.LogBuffer = 1 << 20
+	c.LogFlushInterval = time.Hour
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			get(h, fmt.Sprintf("/a.txt?%d", i))
+		}(i)
+	}
+	wg.Wait()
+	if got := console.String(); got != "" {
+		t.Errorf("got %d bytes before the buffer is full or flushed", len(got))
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(console.String(), "\n"); got != n {
+		t.Errorf("console: got %d lines, want %d", got, n)
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(b), "\n"); got != n {
+		t.Errorf("file: got %d lines, want %d", got, n)
+	}
+}
+
+func TestLogBufferFlushInterval(t *testing.T) {
+	var out syncBuffer
+	bw := newBufferedWriter(&out, 1<<20, 10*time.Millisecond)
+	defer bw.Close()
+	bw.Write([]byte("line\n"))
+	deadline := time.Now().Add(time.Second)
+	for out.String() == "" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := out.String(); got != "line\n" {
+		t.Errorf("got %q after the flush interval", got)
+	}
+}
+
+func TestLogBufferWriteAfterClose(t *testing.T) {
+	var out syncBuffer
+	bw := newBufferedWriter(&out, 1<<20, time.Hour)
+	bw.Close()
+	if _, err := bw.Write([]byte("line\n")); err == nil {
+		t.Error("got no error writing after Close")
+	}
+	if err := bw.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
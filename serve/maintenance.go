@@ -0,0 +1,56 @@
+package serve
+
+import (
+	"html/template"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// sentinelTTL is how long the existence of a sentinel file is cached.
+const sentinelTTL = time.Second
+
+// sentinel reports whether a file exists, checking the disk at most once per
+// sentinelTTL.
+type sentinel struct {
+	path    string
+	mu      sync.Mutex
+	checked time.Time
+	exists  bool
+}
+
+func (s *sentinel) isPresent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now := time.Now(); now.Sub(s.checked) >= sentinelTTL {
+		_, err := os.Stat(s.path)
+		s.exists = err == nil
+		s.checked = now
+	}
+	return s.exists
+}
+
+// maintenanceOptions configure Maintenance.
+type maintenanceOptions struct {
+	// file switches maintenance mode on while it exists.
+	file string
+	// page is executed like the -503-page, the status text is served if nil.
+	page       *template.Template
+	retryAfter int
+	allow      []*net.IPNet
+}
+
+// Maintenance answers all requests with 503 and a Retry-After header while
+// the file of opts exists, except requests from the allowed networks.
+func Maintenance(opts maintenanceOptions, h http.Handler) http.Handler {
+	s := &sentinel{path: opts.file}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.isPresent() && !containsIP(opts.allow, remoteIP(r.RemoteAddr)) {
+			retryErrorPage(w, r, http.StatusServiceUnavailable, opts.retryAfter, opts.page)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,46 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Methods answers requests using any method but the allowed ones with 405
+// and an Allow header listing the allowed methods. OPTIONS requests are
+// answered with 204 and the same Allow header, so clients can discover the
+// supported methods. Since CORS headers are added before, this also serves
+// as the response to preflight requests.
+func Methods(methods []string, h http.Handler) http.Handler {
+	allowed := map[string]bool{}
+	for _, m := range methods {
+		allowed[m] = true
+	}
+	if !allowed[http.MethodOptions] {
+		methods = append(methods[:len(methods):len(methods)], http.MethodOptions)
+	}
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !allowed[r.Method] {
+			w.Header().Set("Allow", allow)
+			httpError(w, r, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parseMethods parses a comma separated list of HTTP methods.
+func parseMethods(s string) []string {
+	var methods []string
+	for _, m := range strings.Split(s, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
@@ -0,0 +1,91 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestMethodsAllow(t *testing.T) {
+	tests := []struct {
+		methods string
+		allow   string
+		refused string
+		passed  string
+	}{
+		{"", "GET, HEAD, OPTIONS", http.MethodPost, http.MethodHead},
+		{"get, post ,DELETE", "GET, POST, DELETE, OPTIONS", http.MethodPut, http.MethodDelete},
+		{"GET,OPTIONS", "GET, OPTIONS", http.MethodHead, http.MethodGet},
+	}
+	for _, tt := range tests {
+		c := DefaultConfig()
+		if tt.methods != "" {
+			c.AllowMethods = tt.methods
+		}
+		h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+
+		r := newRequest(t, "/a.txt")
+		r.Method = http.MethodOptions
+		w := serveRequest(h, r)
+		if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+			t.Errorf("%q: OPTIONS got status %d and body %q", tt.methods, w.Code, w.Body)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%q: OPTIONS got Allow %q, want %q", tt.methods, got, tt.allow)
+		}
+
+		r = newRequest(t, "/a.txt")
+		r.Method = tt.refused
+		w = serveRequest(h, r)
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%q: %s got status %d", tt.methods, tt.refused, w.Code)
+		}
+		if got := w.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%q: %s got Allow %q, want %q", tt.methods, tt.refused, got, tt.allow)
+		}
+
+		r = newRequest(t, "/a.txt")
+		r.Method = tt.passed
+		w = serveRequest(h, r)
+		if w.Code == http.StatusMethodNotAllowed || w.Header().Get("Allow") != "" {
+			t.Errorf("%q: %s got status %d and Allow %q", tt.methods, tt.passed, w.Code, w.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestMethodsPreflightBeforeAuth(t *testing.T) {
+	dir := t.TempDir()
+	c := DefaultConfig()
+	c.Auth = "basic?realm=site&secrets=" + writeHtpasswd(t, dir, map[string]string{"admin": "secret"})
+	c.CORS = true
+	c.CORSOrigins = "https://app.example.com"
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+
+	r := newRequest(t, "/a.txt")
+	r.Method = http.MethodOptions
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := serveRequest(h, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: got status %d", w.Code)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("preflight: got Access-Control-Allow-Origin %q", got)
+	}
+	if got := w.Header().Get("Allow"); got != "GET, HEAD, OPTIONS" {
+		t.Errorf("preflight: got Allow %q", got)
+	}
+
+	// Other requests are still authenticated, with CORS headers on the 401.
+	w = get(h, "/a.txt", "Origin", "https://app.example.com")
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("got status %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://app.example.com" {
+		t.Errorf("got Access-Control-Allow-Origin %q on the 401", got)
+	}
+	r = newRequest(t, "/a.txt")
+	r.SetBasicAuth("admin", "secret")
+	if w := serveRequest(h, r); w.Code != http.StatusOK {
+		t.Errorf("authenticated: got status %d", w.Code)
+	}
+}
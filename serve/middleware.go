@@ -0,0 +1,105 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// middleware wraps a handler.
+type middleware func(h http.Handler) http.Handler
+
+// defaultMiddlewareOrder names all middleware in the order requests pass
+// through them, from the outermost to the innermost, which wraps the file
+// server.
+var defaultMiddlewareOrder = []string{
+	"default-host",
+	"canonical-host",
+	"health",
+	"ready",
+	"maintenance",
+	"geo",
+	"signed-urls",
+	"cors",
+	"methods",
+	"htaccess",
+	"auth",
+	"dump-headers",
+	"log",
+	"info",
+	"admin",
+	"once",
+	"max-body-size",
+	"min-body-rate",
+	"delay",
+	"fault",
+	"throttle",
+	"referer",
+	"i18n",
+	"image-negotiation",
+	"gzip",
+	"rewrite-base",
+	"stats",
+	"push",
+	"headers",
+	"root-header",
+	"robots",
+	"no-dir-redirect",
+	"directory-fallback",
+	"proxy",
+	"no-redirect",
+	"max-open-files",
+	"digest",
+	"etag",
+	"charset",
+	"sitemap",
+	"archive",
+	"default-type",
+	"sniff",
+	"listing",
+	"json-errors",
+	"compression-dict",
+	"precompressed",
+}
+
+// parseMiddlewareOrder parses a comma separated list of middleware names.
+func parseMiddlewareOrder(s string) ([]string, error) {
+	known := map[string]bool{}
+	for _, name := range defaultMiddlewareOrder {
+		known[name] = true
+	}
+	seen := map[string]bool{}
+	var order []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !known[name] {
+			return nil, fmt.Errorf("unknown middleware: %s", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate middleware: %s", name)
+		}
+		seen[name] = true
+		order = append(order, name)
+	}
+	return order, nil
+}
+
+// chain wraps h with the enabled middleware in order, so that the first one
+// sees requests first. Every enabled middleware must be part of the order.
+func chain(order []string, enabled map[string]middleware, h http.Handler) (http.Handler, error) {
+	listed := map[string]bool{}
+	for _, name := range order {
+		listed[name] = true
+	}
+	for name := range enabled {
+		if !listed[name] {
+			return nil, fmt.Errorf("middleware %s is enabled but missing from the order", name)
+		}
+	}
+	for i := len(order) - 1; i >= 0; i-- {
+		if m, ok := enabled[order[i]]; ok {
+			h = m(h)
+		}
+	}
+	return h, nil
+}
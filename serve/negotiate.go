@@ -0,0 +1,53 @@
+package serve
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// qualityValue is an element of a header like Accept or Accept-Encoding.
+type qualityValue struct {
+	value string
+	q     float64
+}
+
+// parseQualityList parses a comma separated list of values with optional
+// q parameters, ordered by descending quality. Values with q=0 are omitted.
+func parseQualityList(s string) []qualityValue {
+	var qvs []qualityValue
+	for _, part := range strings.Split(s, ",") {
+		params := strings.Split(part, ";")
+		qv := qualityValue{value: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		if qv.value == "" {
+			continue
+		}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					qv.q = q
+				}
+			}
+		}
+		if qv.q > 0 {
+			qvs = append(qvs, qv)
+		}
+	}
+	sort.SliceStable(qvs, func(i, j int) bool { return qvs[i].q > qvs[j].q })
+	return qvs
+}
+
+// accepts reports the quality of value in the list, considering the wildcard.
+func accepts(qvs []qualityValue, value string) float64 {
+	wildcard := 0.0
+	for _, qv := range qvs {
+		if qv.value == value {
+			return qv.q
+		}
+		if qv.value == "*" {
+			wildcard = qv.q
+		}
+	}
+	return wildcard
+}
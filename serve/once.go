@@ -0,0 +1,43 @@
+package serve
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Once serves files until the first one has been downloaded completely, then
+// closes done and answers all further requests with 410. Listings, partial
+// and interrupted downloads do not count.
+func Once(fs http.FileSystem, done chan struct{}, h http.Handler) http.Handler {
+	var mu sync.Mutex
+	finished := false
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		gone := finished
+		mu.Unlock()
+		if gone {
+			httpError(w, r, http.StatusText(http.StatusGone), http.StatusGone)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(withOptional(rec, w), r)
+		if r.Method != http.MethodGet || rec.status != http.StatusOK || rec.err != nil || r.Context().Err() != nil {
+			return
+		}
+		// Content read from stdin is a file served at /.
+		if isDir(fs, r.URL.Path) {
+			return
+		}
+		if n, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64); err == nil && n != rec.bytes {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if !finished {
+			finished = true
+			logOf(r).infof("%s downloaded once, shutting down", r.URL.Path)
+			close(done)
+		}
+	})
+}
@@ -0,0 +1,56 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+)
+
+// isClosed reports whether the channel of Handler.Done is closed.
+func isClosed(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestOnce(t *testing.T) {
+	c := DefaultConfig()
+	c.Once = true
+	h := newTestHandler(t, c, map[string]string{"d/a.txt": "a"})
+	for _, path := range []string{"/d/", "/missing.txt"} {
+		get(h, path)
+		if isClosed(h.Done()) {
+			t.Fatalf("%s shut down", path)
+		}
+	}
+	if w := get(h, "/d/a.txt", "Range", "bytes=0-0"); w.Code != http.StatusPartialContent || isClosed(h.Done()) {
+		t.Fatalf("range request: got status %d and done %v", w.Code, isClosed(h.Done()))
+	}
+	if w := get(h, "/d/a.txt"); w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Fatalf("got status %d and body %q", w.Code, w.Body)
+	}
+	if !isClosed(h.Done()) {
+		t.Fatal("not done after a complete download")
+	}
+	if w := get(h, "/d/a.txt"); w.Code != http.StatusGone {
+		t.Errorf("second download: got status %d, want %d", w.Code, http.StatusGone)
+	}
+}
+
+func TestOnceContent(t *testing.T) {
+	c := DefaultConfig()
+	c.Once = true
+	c.Content = []byte("from stdin")
+	h := newTestHandler(t, c, nil)
+	if w := get(h, "/"); w.Code != http.StatusOK || w.Body.String() != "from stdin" {
+		t.Fatalf("got status %d and body %q", w.Code, w.Body)
+	}
+	if !isClosed(h.Done()) {
+		t.Fatal("not done after a complete download")
+	}
+	if w := get(h, "/"); w.Code != http.StatusGone {
+		t.Errorf("second download: got status %d, want %d", w.Code, http.StatusGone)
+	}
+}
@@ -0,0 +1,100 @@
+package serve
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// precompressedEncodings are the supported sidecar encodings in order of
+// preference, with the file suffix of their sidecars.
+var precompressedEncodings = []struct {
+	encoding string
+	suffix   string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// Precompressed serves precompressed sidecar files, e.g. app.js.br or
+// app.js.gz for app.js, to clients accepting their encoding. The best
+// encoding is chosen by the quality the client assigns to it, preferring
+// brotli over gzip. Content type and modification time are those of the
+// original file, unless a content type is set already. A strong ETag is
+// replaced by one of the sidecar, and the Digest of the original file is
+// dropped, since both describe the encoded response. Clients whose
+// User-Agent matches skipUA, which may be nil, are served the original file.
+func Precompressed(fs http.FileSystem, skipUA *regexp.Regexp, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		name := path.Clean("/" + r.URL.Path)
+		orig, err := fs.Open(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		defer orig.Close()
+		origInfo, err := orig.Stat()
+		if err != nil || origInfo.IsDir() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Accept-Encoding")
+		if skipsCompression(skipUA, w, r) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		accepted := parseQualityList(r.Header.Get("Accept-Encoding"))
+		var candidates []int
+		for i, pe := range precompressedEncodings {
+			if accepts(accepted, pe.encoding) > 0 {
+				candidates = append(candidates, i)
+			}
+		}
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return accepts(accepted, precompressedEncodings[candidates[i]].encoding) > accepts(accepted, precompressedEncodings[candidates[j]].encoding)
+		})
+		for _, i := range candidates {
+			pe := precompressedEncodings[i]
+			f, err := fs.Open(name + pe.suffix)
+			if err != nil {
+				continue
+			}
+			defer f.Close()
+			fi, err := f.Stat()
+			if err != nil || fi.IsDir() {
+				continue
+			}
+			header := w.Header()
+			if header.Get("Content-Type") == "" {
+				header.Set("Content-Type", contentType(name, orig))
+			}
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				header.Set("ETag", infoETag(fi))
+			}
+			header.Del("Digest")
+			header.Set("Content-Encoding", pe.encoding)
+			http.ServeContent(w, r, name, origInfo.ModTime(), f)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// contentType determines the content type of the file name by its extension,
+// falling back to sniffing its content.
+func contentType(name string, f io.Reader) string {
+	if ctype := mime.TypeByExtension(path.Ext(name)); ctype != "" {
+		return ctype
+	}
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return http.DetectContentType(buf[:n])
+}
@@ -0,0 +1,46 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPrecompressedHeaders(t *testing.T) {
+	c := DefaultConfig()
+	c.Precompressed = true
+	c.CORS = true
+	c.Headers = []string{"X-Frame-Options=DENY"}
+	c.ETag = true
+	c.Digest = true
+	c.Charset = "utf-8"
+	h := newTestHandler(t, c, map[string]string{
+		"app.js":    "console.log('plain')",
+		"app.js.br": "compressed",
+	})
+	plain := get(h, "/app.js", "Origin", "https://example.org")
+	sidecar := get(h, "/app.js", "Origin", "https://example.org", "Accept-Encoding", "br")
+	if plain.Code != http.StatusOK || sidecar.Code != http.StatusOK {
+		t.Fatalf("got status %d and %d", plain.Code, sidecar.Code)
+	}
+	if got := sidecar.Body.String(); got != "compressed" {
+		t.Fatalf("sidecar: got body %q", got)
+	}
+	if got := sidecar.Header().Get("Content-Encoding"); got != "br" {
+		t.Errorf("sidecar: got Content-Encoding %q", got)
+	}
+	for _, name := range []string{"Access-Control-Allow-Origin", "X-Frame-Options", "Content-Type", "Vary"} {
+		if p, s := plain.Header().Get(name), sidecar.Header().Get(name); p == "" || p != s {
+			t.Errorf("%s: got %q plain and %q sidecar", name, p, s)
+		}
+	}
+	if got := sidecar.Header().Get("Content-Type"); got != "text/javascript; charset=utf-8" && got != "application/javascript; charset=utf-8" {
+		t.Errorf("got Content-Type %q", got)
+	}
+	p, s := plain.Header().Get("ETag"), sidecar.Header().Get("ETag")
+	if p == "" || s == "" || p == s {
+		t.Errorf("got ETag %q plain and %q sidecar", p, s)
+	}
+	if plain.Header().Get("Digest") == "" || sidecar.Header().Get("Digest") != "" {
+		t.Errorf("got Digest %q plain and %q sidecar", plain.Header().Get("Digest"), sidecar.Header().Get("Digest"))
+	}
+}
@@ -0,0 +1,75 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// proxyRule forwards requests below prefix to an upstream server.
+type proxyRule struct {
+	prefix string
+	proxy  *httputil.ReverseProxy
+}
+
+// parseProxyRules parses rules of the form /prefix=http://upstream, ordered
+// from the most to the least specific prefix.
+func parseProxyRules(specs []string) ([]proxyRule, error) {
+	var rules []proxyRule
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+		if i <= 0 || !strings.HasPrefix(spec, "/") {
+			return nil, fmt.Errorf("invalid proxy rule: %s", spec)
+		}
+		target, err := url.Parse(spec[i+1:])
+		if err != nil || target.Scheme == "" || target.Host == "" {
+			return nil, fmt.Errorf("invalid proxy upstream: %s", spec[i+1:])
+		}
+		p := httputil.NewSingleHostReverseProxy(target)
+		p.Transport = timingTransport{http.DefaultTransport}
+		p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if isBodyTooLarge(err) {
+				httpError(w, r, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+				return
+			}
+			logOf(r).warnf("proxy %s %s: %v", r.Method, r.URL, err)
+			httpError(w, r, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		}
+		rules = append(rules, proxyRule{prefix: spec[:i], proxy: p})
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].prefix) > len(rules[j].prefix) })
+	return rules, nil
+}
+
+// Proxy forwards requests below the prefix of a rule, including the prefix,
+// to its upstream. All other requests are passed to h.
+func Proxy(rules []proxyRule, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, rule := range rules {
+			if strings.HasPrefix(r.URL.Path, rule.prefix) {
+				rule.proxy.ServeHTTP(w, r)
+				return
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// timingTransport records the time until the upstream responded in the
+// requestLog of the request.
+type timingTransport struct {
+	http.RoundTripper
+}
+
+func (t timingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.RoundTripper.RoundTrip(r)
+	if rl := requestLogFrom(r); rl != nil {
+		rl.upstream += time.Since(start)
+	}
+	return resp, err
+}
@@ -0,0 +1,148 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProxyUpstreamTiming(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("upstream " + r.URL.Path))
+	}))
+	defer upstream.Close()
+	rules, err := parseProxyRules([]string{"/api=" + upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := NewAccessLog("json", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	al.setOutput(&buf)
+	h := LogRequests(al, Proxy(rules, http.NotFoundHandler()))
+
+	if w := get(h, "/api/x"); w.Body.String() != "upstream /api/x" {
+		t.Fatalf("got body %q", w.Body)
+	}
+	var proxied map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &proxied); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if ms, ok := proxied["upstream_ms"].(float64); !ok || ms < 50 {
+		t.Errorf("got upstream_ms %v, want at least 50", proxied["upstream_ms"])
+	}
+
+	buf.Reset()
+	get(h, "/static.txt")
+	var local map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &local); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	if _, ok := local["upstream_ms"]; ok {
+		t.Errorf("got upstream_ms %v for a request that was not proxied", local["upstream_ms"])
+	}
+}
+
+func TestProxyUpstreamTimingText(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+	rules, err := parseProxyRules([]string{"/api=" + upstream.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	al, err := NewAccessLog("text", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	al.setOutput(&buf)
+	h := LogRequests(al, Proxy(rules, http.NotFoundHandler()))
+	get(h, "/api/x")
+	get(h, "/static.txt")
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "(upstream ") || strings.Contains(lines[1], "upstream") {
+		t.Errorf("got log %q", buf.String())
+	}
+}
+
+func TestProxyRules(t *testing.T) {
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("api")) }))
+	defer api.Close()
+	v2 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("v2")) }))
+	defer v2.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	rules, err := parseProxyRules([]string{"/api=" + api.URL, "/api/v2=" + v2.URL, "/down=" + down.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := Proxy(rules, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("local")) }))
+	tests := []struct {
+		path   string
+		status int
+		body   string
+	}{
+		{"/api/x", http.StatusOK, "api"},
+		{"/api/v2/x", http.StatusOK, "v2"},
+		{"/index.html", http.StatusOK, "local"},
+		{"/down/x", http.StatusBadGateway, "Bad Gateway\n"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != tt.status || w.Body.String() != tt.body {
+			t.Errorf("%s: got status %d and body %q, want %d and %q", tt.path, w.Code, w.Body, tt.status, tt.body)
+		}
+	}
+	for _, spec := range []string{"api=http://x", "/api", "/api=x", "/api=http://"} {
+		if _, err := parseProxyRules([]string{spec}); err == nil {
+			t.Errorf("%s: got no error", spec)
+		}
+	}
+}
+
+func TestProxyMaxBodySize(t *testing.T) {
+	var received []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A body cut off at the limit while streaming fails to read.
+		if b, err := ioutil.ReadAll(r.Body); err == nil {
+			received = append(received, string(b))
+		}
+	}))
+	defer upstream.Close()
+	c := DefaultConfig()
+	c.Proxy = []string{"/api=" + upstream.URL}
+	c.AllowMethods = "GET,HEAD,POST"
+	c.MaxBodySize = 16
+	h := newTestHandler(t, c, nil)
+	post := func(body string, length int64) int {
+		r := httptest.NewRequest(http.MethodPost, "/api/x", strings.NewReader(body))
+		// A length of -1 sends the body chunked, without announcing it.
+		r.ContentLength = length
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post("small", 5); code != http.StatusOK {
+		t.Errorf("small body: got status %d", code)
+	}
+	if code := post("exactly 16 bytes", -1); code != http.StatusOK {
+		t.Errorf("body of the limit: got status %d", code)
+	}
+	if code := post(strings.Repeat("x", 1024), 1024); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("announced large body: got status %d", code)
+	}
+	if code := post(strings.Repeat("x", 1024), -1); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("chunked large body: got status %d", code)
+	}
+	if len(received) != 2 || received[0] != "small" || received[1] != "exactly 16 bytes" {
+		t.Errorf("upstream received %q", received)
+	}
+}
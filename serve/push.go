@@ -0,0 +1,51 @@
+package serve
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Push initiates HTTP/2 server pushes of the assets configured for a request
+// path. Requests for a directory match the rules of its index.html. When push
+// is unavailable, e.g. for HTTP/1.1 connections, nothing is pushed.
+//
+// Server push has been removed from some browsers but is still useful for
+// clients and proxies that support it.
+func Push(rules map[string][]string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+		if strings.HasSuffix(p, "/") {
+			p += "index.html"
+		}
+		for _, target := range rules[p] {
+			err := push(w, target, nil)
+			if err == http.ErrNotSupported {
+				break
+			}
+			if err != nil {
+				logOf(r).debugf("push %s for %s: %v", target, r.URL.Path, err)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// parsePushRules parses rules of the form "/path:/asset1,/asset2".
+func parsePushRules(specs []string) (map[string][]string, error) {
+	rules := map[string][]string{}
+	for _, spec := range specs {
+		i := strings.IndexRune(spec, ':')
+		if i <= 0 || !strings.HasPrefix(spec, "/") {
+			return nil, fmt.Errorf("invalid push rule: %s", spec)
+		}
+		for _, target := range strings.Split(spec[i+1:], ",") {
+			target = strings.TrimSpace(target)
+			if !strings.HasPrefix(target, "/") {
+				return nil, fmt.Errorf("invalid push target: %s", target)
+			}
+			rules[spec[:i]] = append(rules[spec[:i]], target)
+		}
+	}
+	return rules, nil
+}
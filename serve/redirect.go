@@ -0,0 +1,114 @@
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// acmeChallengePrefix is never redirected so that certificates can be issued
+// for non-canonical hosts.
+const acmeChallengePrefix = "/.well-known/acme-challenge/"
+
+// parseRedirectCode validates the status code used for redirects.
+func parseRedirectCode(code int) (int, error) {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return code, nil
+	default:
+		return 0, fmt.Errorf("invalid redirect status code: %d", code)
+	}
+}
+
+// RedirectCode answers the permanent redirects of h, i.e. the canonicalizing
+// redirects of http.FileServer, with code instead.
+func RedirectCode(code int, h http.Handler) http.Handler {
+	if code == http.StatusMovedPermanently {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(withOptional(&redirectCodeWriter{ResponseWriter: w, code: code}, w), r)
+	})
+}
+
+type redirectCodeWriter struct {
+	http.ResponseWriter
+	code int
+}
+
+func (w *redirectCodeWriter) WriteHeader(status int) {
+	if status == http.StatusMovedPermanently {
+		status = w.code
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// CanonicalHost redirects requests whose host differs from the canonical
+// host, ignoring the port, preserving scheme, path and query. Requests for
+// ACME challenges and for healthPath or below it are never redirected.
+func CanonicalHost(host string, healthPath string, code int, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqHost, port := splitHostPort(r.Host)
+		canonical, _ := splitHostPort(host)
+		if strings.EqualFold(reqHost, canonical) || strings.HasPrefix(r.URL.Path, acmeChallengePrefix) || isHealthPath(r.URL.Path, healthPath) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		target := *r.URL
+		target.Scheme = "http"
+		if r.TLS != nil {
+			target.Scheme = "https"
+		}
+		target.Host = host
+		if port != "" && !strings.Contains(host, ":") {
+			target.Host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, target.String(), code)
+	})
+}
+
+// isHealthPath reports whether p is healthPath, if set, or below it.
+func isHealthPath(p string, healthPath string) bool {
+	if healthPath == "" {
+		return false
+	}
+	healthPath = strings.TrimSuffix(healthPath, "/")
+	return p == healthPath || strings.HasPrefix(p, healthPath+"/")
+}
+
+// splitHostPort splits host into host and port, where the port is optional.
+func splitHostPort(hostport string) (string, string) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return hostport, ""
+	}
+	return host, port
+}
+
+// DefaultHost sets the host of HTTP/1.0 requests without a Host header to
+// host, if set, before the host is matched by later handlers. If strict is
+// set, other requests without a host are answered with 400.
+func DefaultHost(host string, strict bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if !r.ProtoAtLeast(1, 1) {
+			if host != "" {
+				r2 := r.Clone(r.Context())
+				r2.Host = host
+				r = r2
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		if strict {
+			httpError(w, r, "missing required Host header", http.StatusBadRequest)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
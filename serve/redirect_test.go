@@ -0,0 +1,90 @@
+package serve
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectCode(t *testing.T) {
+	c := DefaultConfig()
+	c.RedirectCode = http.StatusFound
+	c.CanonicalHost = "www.example.com"
+	c.HealthPath = "/healthz"
+	h := newTestHandler(t, c, map[string]string{
+		"dir/index.html":  "index",
+		"healthzfile.txt": "not health",
+	})
+	tests := []struct {
+		path     string
+		host     string
+		status   int
+		location string
+	}{
+		{"/dir", "www.example.com", http.StatusFound, "dir/"},
+		{"/dir/index.html", "www.example.com", http.StatusFound, "./"},
+		{"/dir/", "example.com", http.StatusFound, "http://www.example.com/dir/"},
+		{"/healthz", "example.com", http.StatusOK, ""},
+		{"/healthz/ready", "example.com", http.StatusOK, ""},
+		{"/healthzfile.txt", "example.com", http.StatusFound, "http://www.example.com/healthzfile.txt"},
+	}
+	for _, tt := range tests {
+		r := newRequest(t, tt.path)
+		r.Host = tt.host
+		w := serveRequest(h, r)
+		if w.Code != tt.status || w.Header().Get("Location") != tt.location {
+			t.Errorf("%s on %s: got status %d and Location %q, want %d and %q", tt.path, tt.host, w.Code, w.Header().Get("Location"), tt.status, tt.location)
+		}
+	}
+}
+
+func TestDefaultHost(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { io.WriteString(w, r.Host) })
+	tests := []struct {
+		defaultHost string
+		strict      bool
+		major       int
+		minor       int
+		host        string
+		status      int
+		body        string
+	}{
+		{"example.com", false, 1, 0, "", http.StatusOK, "example.com"},
+		{"example.com", false, 1, 0, "other.com", http.StatusOK, "other.com"},
+		{"", false, 1, 0, "", http.StatusOK, ""},
+		{"example.com", false, 1, 1, "", http.StatusOK, ""},
+		{"example.com", false, 1, 1, "other.com", http.StatusOK, "other.com"},
+		{"example.com", true, 1, 0, "", http.StatusOK, "example.com"},
+		{"", true, 1, 0, "", http.StatusOK, ""},
+		{"example.com", true, 1, 1, "", http.StatusBadRequest, ""},
+		{"example.com", true, 2, 0, "", http.StatusBadRequest, ""},
+		{"example.com", true, 1, 1, "other.com", http.StatusOK, "other.com"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.ProtoMajor, r.ProtoMinor = tt.major, tt.minor
+		r.Proto = fmt.Sprintf("HTTP/%d.%d", tt.major, tt.minor)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		DefaultHost(tt.defaultHost, tt.strict, echo).ServeHTTP(w, r)
+		if w.Code != tt.status || tt.status == http.StatusOK && w.Body.String() != tt.body {
+			t.Errorf("%+v: got status %d and host %q", tt, w.Code, w.Body)
+		}
+	}
+}
+
+func TestDefaultHostCanonical(t *testing.T) {
+	c := DefaultConfig()
+	c.CanonicalHost = "example.com"
+	c.DefaultHost = "example.com"
+	h := newTestHandler(t, c, map[string]string{"a.txt": "a"})
+	r := newRequest(t, "/a.txt")
+	r.ProtoMajor, r.ProtoMinor, r.Proto = 1, 0, "HTTP/1.0"
+	r.Host = ""
+	// Not redirected to the canonical host it already has.
+	if w := serveRequest(h, r); w.Code != http.StatusOK || w.Body.String() != "a" {
+		t.Errorf("got status %d and body %q", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,55 @@
+package serve
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Referer protects files with the given extensions from hotlinking. Requests
+// for them are answered with 403 unless the host of their Referer matches one
+// of the allowed patterns, or the Referer is empty and allowEmpty is set.
+// Patterns use path.Match syntax, e.g. "*.example.com".
+func Referer(allowed []string, exts []string, allowEmpty bool, h http.Handler) http.Handler {
+	protected := map[string]bool{}
+	for _, ext := range exts {
+		protected[strings.ToLower(ext)] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !protected[strings.ToLower(path.Ext(r.URL.Path))] || refererAllowed(r.Referer(), allowed, allowEmpty) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		logOf(r).debugf("referer %q not allowed for %s", r.Referer(), r.URL.Path)
+		httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+func refererAllowed(referer string, allowed []string, allowEmpty bool) bool {
+	if referer == "" {
+		return allowEmpty
+	}
+	u, err := url.Parse(referer)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, pattern := range allowed {
+		if ok, _ := path.Match(strings.ToLower(pattern), host); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated list, dropping empty elements.
+func splitList(s string) []string {
+	var list []string
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			list = append(list, e)
+		}
+	}
+	return list
+}
@@ -0,0 +1,57 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestReferer(t *testing.T) {
+	files := map[string]string{"a.jpg": "image", "a.txt": "text"}
+	tests := []struct {
+		path       string
+		referer    string
+		allowEmpty bool
+		status     int
+	}{
+		{"/a.jpg", "https://example.com/page", false, http.StatusOK},
+		{"/a.jpg", "https://img.example.com/page", false, http.StatusOK},
+		{"/a.jpg", "https://EXAMPLE.com/", false, http.StatusOK},
+		{"/a.jpg", "https://other.org/page", false, http.StatusForbidden},
+		{"/a.jpg", "https://example.com.other.org/", false, http.StatusForbidden},
+		{"/a.jpg", "", true, http.StatusOK},
+		{"/a.jpg", "", false, http.StatusForbidden},
+		{"/A.JPG", "https://other.org/", false, http.StatusForbidden},
+		{"/a.txt", "https://other.org/page", false, http.StatusOK},
+	}
+	for _, tt := range tests {
+		c := DefaultConfig()
+		c.RefererAllow = "example.com, *.example.com"
+		c.RefererProtect = ".jpg,.png"
+		c.RefererAllowEmpty = tt.allowEmpty
+		h := newTestHandler(t, c, files)
+		var header []string
+		if tt.referer != "" {
+			header = []string{"Referer", tt.referer}
+		}
+		if w := get(h, tt.path, header...); w.Code != tt.status {
+			t.Errorf("%s with referer %q: got status %d, want %d", tt.path, tt.referer, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRefererImageVariant(t *testing.T) {
+	c := DefaultConfig()
+	c.RefererAllow = "example.com"
+	c.RefererProtect = ".jpg"
+	c.ImageNegotiation = true
+	h := newTestHandler(t, c, map[string]string{"a.jpg": "jpeg", "a.webp": "webp"})
+	for _, accept := range []string{"", "image/webp"} {
+		w := get(h, "/a.jpg", "Referer", "https://other.org/", "Accept", accept)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("accept %q: got status %d and body %q, want %d", accept, w.Code, w.Body, http.StatusForbidden)
+		}
+	}
+	if w := get(h, "/a.jpg", "Referer", "https://example.com/", "Accept", "image/webp"); w.Code != http.StatusOK || w.Body.String() != "webp" {
+		t.Errorf("allowed: got status %d and body %q", w.Code, w.Body)
+	}
+}
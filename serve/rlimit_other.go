@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package serve
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func raiseOpenFileLimit() (uint64, error) {
+	return 0, fmt.Errorf("raising the open file limit is not supported on %s", runtime.GOOS)
+}
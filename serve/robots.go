@@ -0,0 +1,33 @@
+package serve
+
+import (
+	"io/ioutil"
+	"net/http"
+)
+
+// robotsPresets are the built-in robots.txt policies.
+var robotsPresets = map[string]string{
+	"allow-all":    "User-agent: *\nDisallow:\n",
+	"disallow-all": "User-agent: *\nDisallow: /\n",
+}
+
+// loadRobots returns the robots.txt of a preset policy or read from a file.
+func loadRobots(policy string) ([]byte, error) {
+	if content, ok := robotsPresets[policy]; ok {
+		return []byte(content), nil
+	}
+	return ioutil.ReadFile(policy)
+}
+
+// Robots serves content at /robots.txt, taking precedence over a robots.txt
+// in the served directory.
+func Robots(content []byte, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/robots.txt" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(content)
+	})
+}
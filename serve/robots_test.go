@@ -0,0 +1,41 @@
+package serve
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestRobots(t *testing.T) {
+	custom := filepath.Join(t.TempDir(), "robots.txt")
+	writeFiles(t, filepath.Dir(custom), map[string]string{"robots.txt": "User-agent: bot\nDisallow: /private/\n"})
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"allow-all", "User-agent: *\nDisallow:\n"},
+		{"disallow-all", "User-agent: *\nDisallow: /\n"},
+		{custom, "User-agent: bot\nDisallow: /private/\n"},
+		// Without a policy the file on disk is served.
+		{"", "on disk\n"},
+	}
+	for _, tt := range tests {
+		c := DefaultConfig()
+		c.Robots = tt.policy
+		h := newTestHandler(t, c, map[string]string{"robots.txt": "on disk\n"})
+		w := get(h, "/robots.txt")
+		if w.Code != http.StatusOK || w.Body.String() != tt.want {
+			t.Errorf("%q: got status %d and body %q, want %q", tt.policy, w.Code, w.Body, tt.want)
+		}
+		if got := w.Header().Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("%q: got Content-Type %q", tt.policy, got)
+		}
+	}
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.Robots = "allow-some"
+	if h, err := New(c); err == nil {
+		h.Close()
+		t.Error("got no error for an unknown policy without a file")
+	}
+}
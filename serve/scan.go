@@ -0,0 +1,24 @@
+package serve
+
+import (
+	stdlog "log"
+	"net/http"
+	"os"
+)
+
+// scan walks root and logs the number of files and their total size, warning
+// if there is nothing to serve.
+func scan(root string, opts walkOptions, log *Logger) {
+	var files, size int64
+	err := walk(http.Dir(root), opts, func(name string, fi os.FileInfo) {
+		files++
+		size += fi.Size()
+	})
+	if err != nil {
+		log.warnf("scan [%s] incomplete: %v", root, err)
+	}
+	stdlog.Printf("Found %d files (%s) in [%s].", files, humanizeBytes(size), root)
+	if files == 0 {
+		log.warnf("[%s] contains no files", root)
+	}
+}
@@ -0,0 +1,742 @@
+// Package serve implements the handler of the serve command, so that it can
+// be embedded in other programs.
+package serve
+
+import (
+	"expvar"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config configures a Handler. The fields correspond to the flags of the
+// serve command, e.g. GZIP to -gzip, and are documented there. Lists are
+// comma separated as on the command line.
+type Config struct {
+	// Root is the served directory.
+	Root string
+	// Content is served at / instead of Root if not nil.
+	Content     []byte
+	ContentType string
+
+	// Logger logs internal messages, and requests if its level is info or
+	// higher. Errors and warnings are logged if nil.
+	Logger         *Logger
+	LogFormat      string
+	LogFields      string
+	LogTemplate    string
+	LogRedactQuery string
+	LogNoQuery     bool
+	// LogExcludeStatus and LogExcludePath are comma separated response
+	// statuses and path glob patterns of requests that are not logged.
+	LogExcludeStatus  string
+	LogExcludePath    string
+	LogBuffer         int
+	LogFlushInterval  time.Duration
+	LogColor          bool
+	LogTimezone       string
+	LogTimeFormat     string
+	DumpHeaders       bool
+	DumpHeadersUnsafe bool
+	// LogOutput receives the access log. Defaults to stderr.
+	LogOutput io.Writer
+	// LogFiles are files of the form [format:]path that additionally
+	// receive the access log, in LogFormat unless text or json is given.
+	LogFiles []string
+
+	NoListing     bool
+	DefaultPage   string
+	ListingSort   string
+	HumanSizes    bool
+	ListingTheme  string
+	NoRedirect    bool
+	NoDirRedirect bool
+	RedirectCode  int
+	// Once shuts down after the first complete download, see Handler.Done.
+	Once bool
+	// Htaccess applies the .serve.yaml files of the served directories.
+	Htaccess bool
+	// Ignore are glob patterns of files and directories that are neither
+	// listed nor served.
+	Ignore []string
+
+	CanonicalHost string
+	DefaultHost   string
+	StrictHost    bool
+	Headers       []string
+	HeaderPaths   []string
+	Push          []string
+	// Proxy are rules of the form /prefix=http://upstream.
+	Proxy        []string
+	AllowMethods string
+	// AllowMethodsExplicit reports that AllowMethods was set by the user
+	// rather than left at its default; only then does it also limit the
+	// methods that CORS advertises.
+	AllowMethodsExplicit bool
+
+	CacheTTL         time.Duration
+	CacheMaxBytes    int64
+	NegativeCacheTTL time.Duration
+	// Coalesce lets concurrent requests share the reading, decrypting and
+	// hashing of a file that is not cached yet.
+	Coalesce bool
+	// CaseInsensitive resolves missing paths ignoring case and a single
+	// trailing dot.
+	CaseInsensitive bool
+	Decrypt         string
+	DecryptTTL      time.Duration
+	MaxFileSize     int64
+	MaxBodySize     int64
+	MaxOpenFiles    int
+	// MinBodyRate is the rate in bytes per second below which request
+	// bodies are answered with 408 after MinBodyRateGrace. Unlimited if 0.
+	MinBodyRate      int64
+	MinBodyRateGrace time.Duration
+
+	Scan        bool
+	Sitemap     string
+	WalkWorkers int
+	WalkTimeout time.Duration
+
+	StatsPath  string
+	HealthPath string
+	// AdminPath serves the admin endpoints, such as cache purging, below it
+	// if set. It requires Auth or AdminToken.
+	AdminPath  string
+	AdminToken string
+	// InfoPath serves Build and runtime information as JSON if set.
+	InfoPath        string
+	Build           BuildInfo
+	DebugRootHeader bool
+	ETag            bool
+	Digest          bool
+	SignedURLs      bool
+	SigningKey      string
+	Robots          string
+	Precompressed   bool
+	// CompressionDict is a shared dictionary served at CompressionDictPath
+	// for the URLs of the pattern CompressionDictMatch, whose .dcb and
+	// .dcz sidecars are compressed with it.
+	CompressionDict      string
+	CompressionDictPath  string
+	CompressionDictMatch string
+	ImageNegotiation     bool
+	I18N                 bool
+	Throttle             string
+	ThrottlePerIP        bool
+
+	GeoIPDB           string
+	GeoAllow          string
+	GeoDeny           string
+	RefererAllow      string
+	RefererProtect    string
+	RefererAllowEmpty bool
+
+	ErrorFormat string
+	// Page429 and Page503 are HTML templates of responses asking clients
+	// to retry, executed with the Status and the RetryAfter seconds.
+	Page429     string
+	Page503     string
+	DefaultType string
+	// SniffRules are rules of the form .ext=type or 0xhex=type.
+	SniffRules  []string
+	Charset     string
+	ZipDownload bool
+	// ArchiveCompress gzips tar downloads for clients accepting it.
+	ArchiveCompress bool
+	CORS            bool
+	CORSOrigins     string
+	// CORSCredentials requires CORSOrigins without *.
+	CORSCredentials bool
+	CORSExpose      string
+	// GRPCWebCORS enables CORS for gRPC-Web clients.
+	GRPCWebCORS bool
+	GZIP        bool
+	// GZIPSkipUA is a regular expression of User-Agents that are served
+	// uncompressed.
+	GZIPSkipUA string
+	// RewriteBase is the path below which the site is reachable, e.g. via
+	// a proxy, if not /. HTML documents are rewritten to work below it.
+	RewriteBase string
+
+	// MaintenanceFile switches maintenance mode on while it exists.
+	MaintenanceFile       string
+	MaintenancePage       string
+	MaintenanceAllow      string
+	MaintenanceRetryAfter time.Duration
+
+	// Delay and DelayJitter delay responses to requests below the
+	// comma separated DelayPath prefixes, or all requests if empty.
+	Delay       time.Duration
+	DelayJitter time.Duration
+	DelayPath   string
+	// Fault are rules of the form [/prefix:]status:probability.
+	Fault []string
+
+	Auth string
+	// AuthFor are realms of the form /prefix=urn, which take precedence
+	// over Auth below their prefix. Sessions only apply to Auth.
+	AuthFor []string
+	// AuthNoChallenge omits WWW-Authenticate from 401 responses: never,
+	// xhr or always.
+	AuthNoChallenge string
+	SessionSecret   string
+	SessionTTL      time.Duration
+
+	MiddlewareOrder string
+}
+
+// DefaultConfig returns the configuration of the serve command without any
+// flags.
+func DefaultConfig() Config {
+	return Config{
+		Root:              ".",
+		LogFormat:         "text",
+		LogFlushInterval:  time.Second,
+		LogTimezone:       "Local",
+		ListingSort:       "name",
+		ListingTheme:      "auto",
+		RedirectCode:      http.StatusMovedPermanently,
+		AllowMethods:      "GET,HEAD",
+		CacheMaxBytes:     64 << 20,
+		Coalesce:          true,
+		DecryptTTL:        time.Minute,
+		WalkWorkers:       8,
+		WalkTimeout:       30 * time.Second,
+		RefererAllowEmpty: true,
+		ErrorFormat:       "text",
+		Charset:           "utf-8",
+		CORSOrigins:       "*",
+		SessionTTL:        12 * time.Hour,
+
+		CompressionDictPath:  "/compression.dict",
+		CompressionDictMatch: "/*",
+
+		MaintenanceRetryAfter: 5 * time.Minute,
+		MinBodyRateGrace:      5 * time.Second,
+	}
+}
+
+// Handler serves the configured directory through the enabled middleware.
+type Handler struct {
+	http.Handler
+	ready *readiness
+	// logClosers are closed in order by Close, buffers before their files.
+	logClosers []io.Closer
+	// fs, dirRules, authURN and authFor configure the server returned by
+	// FTP, which refuses to serve if httpOnly names restrictions it cannot
+	// enforce.
+	fs       http.FileSystem
+	dirRules *dirRulesLoader
+	authURN  string
+	authFor  bool
+	httpOnly []string
+	done     chan struct{}
+	log      *Logger
+	stats    *handlerStats
+}
+
+// Done is closed once the handler has finished serving, i.e. with Once after
+// the first complete download. It is never closed otherwise.
+func (h *Handler) Done() <-chan struct{} { return h.done }
+
+// Vars returns the statistics of the handler by name, such as cache_hits,
+// e.g. to be published with expvar.Publish.
+func (h *Handler) Vars() map[string]expvar.Var { return h.stats.vars() }
+
+// SetReady makes the readiness endpoint succeed and stops answering
+// requests with 503.
+func (h *Handler) SetReady() { h.ready.setReady() }
+
+// Close writes buffered access log lines and closes the log files.
+func (h *Handler) Close() error {
+	var first error
+	for _, c := range h.logClosers {
+		if err := c.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// New validates c and assembles the handler. Requests are only logged if the
+// level of c.Logger is info or higher. The handler answers requests with 503
+// until SetReady is called.
+func New(c Config) (*Handler, error) {
+	handler := &Handler{ready: &readiness{}, log: c.Logger, stats: newHandlerStats()}
+	if err := handler.init(c); err != nil {
+		// Close the log files opened so far.
+		handler.Close()
+		return nil, err
+	}
+	return handler, nil
+}
+
+// init assembles the handler of c.
+func (handler *Handler) init(c Config) error {
+	al, err := NewAccessLog(c.LogFormat, c.LogFields, c.LogTemplate)
+	if err != nil {
+		return fmt.Errorf("configure access log: %w", err)
+	}
+	if c.LogOutput != nil {
+		al.setOutput(c.LogOutput)
+	}
+	al.sinks[0].color = c.LogColor && al.sinks[0].format != "json"
+	location, err := time.LoadLocation(c.LogTimezone)
+	if err != nil {
+		return fmt.Errorf("load log timezone: %w", err)
+	}
+	al.SetTime(location, c.LogTimeFormat)
+	al.noQuery = c.LogNoQuery
+	al.logger = c.Logger
+	if err := al.SetExclude(c.LogExcludeStatus, c.LogExcludePath); err != nil {
+		return fmt.Errorf("configure access log exclusions: %w", err)
+	}
+	if c.LogBuffer > 0 {
+		out := c.LogOutput
+		if out == nil {
+			out = os.Stderr
+		}
+		bw := newBufferedWriter(out, c.LogBuffer, c.LogFlushInterval)
+		handler.logClosers = append(handler.logClosers, bw)
+		al.setOutput(bw)
+	}
+	for _, lf := range c.LogFiles {
+		format, name := c.LogFormat, lf
+		if i := strings.IndexByte(lf, ':'); i >= 0 && (lf[:i] == "text" || lf[:i] == "json") {
+			format, name = lf[:i], lf[i+1:]
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("open log file: %w", err)
+		}
+		var out io.Writer = f
+		if c.LogBuffer > 0 {
+			bw := newBufferedWriter(f, c.LogBuffer, c.LogFlushInterval)
+			handler.logClosers = append(handler.logClosers, bw)
+			out = bw
+		}
+		handler.logClosers = append(handler.logClosers, f)
+		if err := al.addSink(format, out); err != nil {
+			return fmt.Errorf("configure access log: %w", err)
+		}
+	}
+	if params := splitList(c.LogRedactQuery); len(params) > 0 {
+		al.redactQuery = map[string]bool{}
+		for _, p := range params {
+			al.redactQuery[p] = true
+		}
+	}
+
+	wo := walkOptions{workers: c.WalkWorkers, timeout: c.WalkTimeout}
+	if c.Scan && c.Content == nil {
+		scan(c.Root, wo, c.Logger)
+	}
+
+	var fs http.FileSystem = http.Dir(c.Root)
+	// caches are the in-memory caches purged by the admin endpoint.
+	var caches []namedCache
+	var dirRules *dirRulesLoader
+	if c.Htaccess && c.Content == nil {
+		dirRules = newDirRulesLoader(fs)
+		fs = hiddenFS{fs: fs, hidden: func(name string) bool { return path.Base(name) == dirRulesFile }}
+	}
+	if len(c.Ignore) > 0 {
+		ignored, err := ignoreMatcher(c.Ignore)
+		if err != nil {
+			return fmt.Errorf("parse ignore patterns: %w", err)
+		}
+		fs = hiddenFS{fs: fs, hidden: ignored}
+	}
+	if c.Decrypt != "" {
+		if c.Auth == "" {
+			return fmt.Errorf("decrypt: requires -auth")
+		}
+		dfs, err := newDecryptingFS(fs, c.Decrypt, c.DecryptTTL, newFlightGroup(c.Coalesce, &handler.stats.coalesced), c.Logger)
+		if err != nil {
+			return fmt.Errorf("load age identities: %w", err)
+		}
+		fs = dfs
+		caches = append(caches, namedCache{"decrypted", dfs})
+	}
+	if c.MaxFileSize > 0 {
+		fs = maxSizeFS{fs: fs, max: c.MaxFileSize}
+	}
+	if c.CacheTTL > 0 {
+		cfs := newCachingFS(fs, c.CacheTTL, c.CacheMaxBytes, newFlightGroup(c.Coalesce, &handler.stats.coalesced), handler.stats, c.Logger)
+		fs = cfs
+		caches = append(caches, namedCache{"files", cfs})
+	}
+	if c.NegativeCacheTTL > 0 {
+		nfs := newNegativeFS(fs, c.NegativeCacheTTL, c.Logger)
+		fs = nfs
+		caches = append(caches, namedCache{"missing", nfs})
+	}
+	var ffs *caseFoldFS
+	if c.CaseInsensitive && c.Content == nil {
+		ffs = newCaseFoldFS(fs)
+		fs = ffs
+		caches = append(caches, namedCache{"case_index", ffs})
+	}
+	if c.Content == nil {
+		handler.fs = fs
+		handler.dirRules = dirRules
+		handler.authURN = c.Auth
+		handler.authFor = len(c.AuthFor) > 0
+		handler.httpOnly = httpOnlyRestrictions(c)
+	}
+	redirectCode, err := parseRedirectCode(c.RedirectCode)
+	if err != nil {
+		return fmt.Errorf("parse redirect code: %w", err)
+	}
+	listingSort, err := parseListingSort(c.ListingSort)
+	if err != nil {
+		return fmt.Errorf("parse listing sort: %w", err)
+	}
+	if !listingThemes[c.ListingTheme] {
+		return fmt.Errorf("unknown listing theme: %s", c.ListingTheme)
+	}
+	env := &handlerEnv{log: c.Logger, retryPages: map[int]*template.Template{}}
+	switch c.ErrorFormat {
+	case "text":
+	case "json":
+		env.jsonErrors = true
+	default:
+		return fmt.Errorf("unknown error format: %s", c.ErrorFormat)
+	}
+
+	for code, file := range map[int]string{http.StatusTooManyRequests: c.Page429, http.StatusServiceUnavailable: c.Page503} {
+		if file == "" {
+			continue
+		}
+		t, err := parseRetryPage(file)
+		if err != nil {
+			return fmt.Errorf("load %d page: %w", code, err)
+		}
+		env.retryPages[code] = t
+	}
+
+	var h http.Handler = RedirectCode(redirectCode, http.FileServer(fs))
+	if c.Content != nil {
+		fs = emptyFS{}
+		h = Content(c.Content, c.ContentType)
+	}
+
+	mw := map[string]middleware{}
+	if env.jsonErrors {
+		mw["json-errors"] = JSONErrors
+	}
+	listingOpts := listingOptions{
+		sort:       listingSort,
+		humanSizes: c.HumanSizes,
+		theme:      c.ListingTheme,
+	}
+	mw["listing"] = func(h http.Handler) http.Handler { return Listing(fs, listingOpts, h) }
+	sniffRules, err := parseSniffRules(c.SniffRules)
+	if err != nil {
+		return fmt.Errorf("parse sniff rules: %w", err)
+	}
+	if c.Content == nil {
+		mw["sniff"] = func(h http.Handler) http.Handler { return Sniff(fs, sniffRules, h) }
+	}
+	if c.DefaultType != "" {
+		mw["default-type"] = func(h http.Handler) http.Handler { return DefaultType(fs, c.DefaultType, h) }
+	}
+	if c.ZipDownload {
+		mw["archive"] = func(h http.Handler) http.Handler {
+			return archiveHandler(fs, c.ArchiveCompress, newFlightGroup(c.Coalesce, &handler.stats.coalesced), h)
+		}
+	}
+	if c.Sitemap != "" && c.Content == nil {
+		sm := newSitemap(fs, strings.TrimSuffix(c.Sitemap, "/"), wo, c.Logger)
+		mw["sitemap"] = func(h http.Handler) http.Handler { return Sitemap(sm, h) }
+	}
+	if c.Charset != "" {
+		mw["charset"] = func(h http.Handler) http.Handler { return Charset(c.Charset, h) }
+	}
+	if c.ETag {
+		mw["etag"] = func(h http.Handler) http.Handler { return ETag(fs, h) }
+	}
+	if c.Digest {
+		digests := newDigestCache(newFlightGroup(c.Coalesce, &handler.stats.coalesced))
+		caches = append(caches, namedCache{"digests", digests})
+		mw["digest"] = func(h http.Handler) http.Handler { return digestHandler(fs, digests, h) }
+	}
+	if c.MaxOpenFiles > 0 {
+		limit, err := raiseOpenFileLimit()
+		if err != nil {
+			c.Logger.warnf("raise open file limit: %v", err)
+		} else {
+			c.Logger.infof("open file limit is %d", limit)
+			if uint64(c.MaxOpenFiles) > limit {
+				c.Logger.warnf("-max-open-files %d exceeds the open file limit %d", c.MaxOpenFiles, limit)
+			}
+		}
+		mw["max-open-files"] = func(h http.Handler) http.Handler { return LimitOpenFiles(c.MaxOpenFiles, h) }
+	}
+	if c.NoRedirect {
+		mw["no-redirect"] = func(h http.Handler) http.Handler { return NoRedirect(fs, h) }
+	}
+	if len(c.Proxy) > 0 {
+		rules, err := parseProxyRules(c.Proxy)
+		if err != nil {
+			return fmt.Errorf("parse proxy rules: %w", err)
+		}
+		mw["proxy"] = func(h http.Handler) http.Handler { return Proxy(rules, h) }
+	}
+	if c.NoDirRedirect {
+		mw["no-dir-redirect"] = func(h http.Handler) http.Handler { return NoDirRedirect(fs, h) }
+	}
+	if c.NoListing || c.DefaultPage != "" {
+		mw["directory-fallback"] = func(h http.Handler) http.Handler {
+			return DirectoryFallback(fs, c.DefaultPage, !c.NoListing, h)
+		}
+	}
+	if c.Robots != "" {
+		robots, err := loadRobots(c.Robots)
+		if err != nil {
+			return fmt.Errorf("load robots.txt: %w", err)
+		}
+		mw["robots"] = func(h http.Handler) http.Handler { return Robots(robots, h) }
+	}
+	if c.DebugRootHeader {
+		root, err := filepath.Abs(c.Root)
+		if err != nil {
+			return fmt.Errorf("resolve root: %w", err)
+		}
+		mw["root-header"] = func(h http.Handler) http.Handler { return RootHeader(root, h) }
+	}
+	if len(c.Headers) > 0 || len(c.HeaderPaths) > 0 {
+		rules, err := parseHeaderRules(c.Headers, c.HeaderPaths)
+		if err != nil {
+			return fmt.Errorf("parse headers: %w", err)
+		}
+		mw["headers"] = func(h http.Handler) http.Handler { return Headers(rules, h) }
+	}
+	if len(c.Push) > 0 {
+		rules, err := parsePushRules(c.Push)
+		if err != nil {
+			return fmt.Errorf("parse push rules: %w", err)
+		}
+		mw["push"] = func(h http.Handler) http.Handler { return Push(rules, h) }
+	}
+	if exts := splitList(c.RefererProtect); len(exts) > 0 {
+		mw["referer"] = func(h http.Handler) http.Handler {
+			return Referer(splitList(c.RefererAllow), exts, c.RefererAllowEmpty, h)
+		}
+	}
+	if c.StatsPath != "" {
+		mw["stats"] = func(h http.Handler) http.Handler { return Stats(c.StatsPath, handler.stats, h) }
+	}
+	if c.MinBodyRate > 0 {
+		mw["min-body-rate"] = func(h http.Handler) http.Handler { return MinBodyRate(c.MinBodyRate, c.MinBodyRateGrace, h) }
+	}
+	if c.MaxBodySize > 0 {
+		mw["max-body-size"] = func(h http.Handler) http.Handler { return MaxBodySize(c.MaxBodySize, h) }
+	}
+	if methods := parseMethods(c.AllowMethods); len(methods) > 0 {
+		mw["methods"] = func(h http.Handler) http.Handler {
+			checked := Methods(methods, h)
+			if c.AdminPath == "" {
+				return checked
+			}
+			// The admin endpoints, which come after authentication, answer
+			// their own methods.
+			admin := strings.TrimSuffix(c.AdminPath, "/")
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == admin || strings.HasPrefix(r.URL.Path, admin+"/") {
+					h.ServeHTTP(w, r)
+					return
+				}
+				checked.ServeHTTP(w, r)
+			})
+		}
+	}
+	if c.CORS || c.GRPCWebCORS {
+		co := CORSOptions{
+			Origins:     splitList(c.CORSOrigins),
+			Credentials: c.CORSCredentials,
+			Expose:      splitList(c.CORSExpose),
+			Methods:     featureMethods(c),
+		}
+		if c.GRPCWebCORS {
+			co = co.GRPCWeb()
+		}
+		if c.AllowMethodsExplicit {
+			co.Methods = restrictMethods(co.Methods, parseMethods(c.AllowMethods))
+		}
+		if err := co.validate(); err != nil {
+			return fmt.Errorf("cors: %w", err)
+		}
+		mw["cors"] = func(h http.Handler) http.Handler { return CORS(co, h) }
+	}
+	var skipUA *regexp.Regexp
+	if c.GZIPSkipUA != "" {
+		if skipUA, err = regexp.Compile(c.GZIPSkipUA); err != nil {
+			return fmt.Errorf("parse gzip skip user agents: %w", err)
+		}
+	}
+	if c.GZIP {
+		mw["gzip"] = func(h http.Handler) http.Handler { return GZIP(skipUA, h) }
+	}
+	if c.RewriteBase != "" {
+		base, err := parseRewriteBase(c.RewriteBase)
+		if err != nil {
+			return fmt.Errorf("parse rewrite base: %w", err)
+		}
+		mw["rewrite-base"] = func(h http.Handler) http.Handler { return RewriteBase(base, h) }
+	}
+	if c.CompressionDict != "" {
+		d, err := loadCompressionDict(c.CompressionDict, c.CompressionDictPath, c.CompressionDictMatch)
+		if err != nil {
+			return fmt.Errorf("load compression dictionary: %w", err)
+		}
+		mw["compression-dict"] = func(h http.Handler) http.Handler { return CompressionDictionary(fs, d, skipUA, h) }
+	}
+	if c.Precompressed {
+		mw["precompressed"] = func(h http.Handler) http.Handler { return Precompressed(fs, skipUA, h) }
+	}
+	if c.I18N {
+		mw["i18n"] = func(h http.Handler) http.Handler { return I18N(fs, h) }
+	}
+	if c.ImageNegotiation {
+		mw["image-negotiation"] = func(h http.Handler) http.Handler { return ImageNegotiation(fs, h) }
+	}
+	if c.Throttle != "" {
+		bytesPerSec, err := parseByteRate(c.Throttle)
+		if err != nil {
+			return fmt.Errorf("parse throttle: %w", err)
+		}
+		mw["throttle"] = func(h http.Handler) http.Handler { return Throttle(bytesPerSec, c.ThrottlePerIP, h) }
+	}
+	if c.Logger.Enabled(LevelInfo) {
+		mw["log"] = func(h http.Handler) http.Handler { return LogRequests(al, h) }
+	}
+	if c.DumpHeaders || c.Logger.Enabled(LevelDebug) {
+		mw["dump-headers"] = func(h http.Handler) http.Handler { return DumpHeaders(c.DumpHeadersUnsafe, h) }
+	}
+	if c.Delay > 0 || c.DelayJitter > 0 {
+		mw["delay"] = func(h http.Handler) http.Handler {
+			return Delay(c.Delay, c.DelayJitter, splitList(c.DelayPath), h)
+		}
+	}
+	if len(c.Fault) > 0 {
+		rules, err := parseFaultRules(c.Fault)
+		if err != nil {
+			return fmt.Errorf("parse fault rules: %w", err)
+		}
+		mw["fault"] = func(h http.Handler) http.Handler { return Fault(rules, h) }
+	}
+	if c.Once {
+		handler.done = make(chan struct{})
+		mw["once"] = func(h http.Handler) http.Handler { return Once(fs, handler.done, h) }
+	}
+	realms, err := parseAuthRealms(c.AuthFor)
+	if err != nil {
+		return fmt.Errorf("load authenticator: %w", err)
+	}
+	noChallenge, err := parseNoChallenge(c.AuthNoChallenge)
+	if err != nil {
+		return err
+	}
+	if c.Auth != "" {
+		authenticator, err := loadAuthenticator(c.Auth)
+		if err != nil {
+			return fmt.Errorf("load authenticator: %w", err)
+		}
+		realm := authRealm{prefix: "/", authenticator: authenticator}
+		if c.SessionSecret != "" {
+			realm.sessions = &sessions{secret: []byte(c.SessionSecret), ttl: c.SessionTTL}
+		}
+		realms = append(realms, realm)
+	}
+	for i := range realms {
+		realms[i].noChallenge = noChallenge
+	}
+	if len(realms) > 0 {
+		mw["auth"] = func(h http.Handler) http.Handler { return Auth(realms, h) }
+	}
+	if dirRules != nil {
+		mw["htaccess"] = func(h http.Handler) http.Handler { return Htaccess(dirRules, len(realms) > 0, h) }
+	}
+	if c.SignedURLs {
+		if c.SigningKey == "" {
+			return fmt.Errorf("signed urls: no signing key specified")
+		}
+		mw["signed-urls"] = func(h http.Handler) http.Handler { return SignedURLs([]byte(c.SigningKey), h) }
+	}
+	if c.GeoAllow != "" || c.GeoDeny != "" {
+		if c.GeoIPDB == "" {
+			return fmt.Errorf("geo: no geoip database specified")
+		}
+		db, err := openGeoDB(c.GeoIPDB, c.Logger)
+		if err != nil {
+			return fmt.Errorf("open geoip database: %w", err)
+		}
+		mw["geo"] = func(h http.Handler) http.Handler {
+			return Geo(db, splitList(c.GeoAllow), splitList(c.GeoDeny), h)
+		}
+	}
+	if c.MaintenanceFile != "" {
+		opts := maintenanceOptions{
+			file:       c.MaintenanceFile,
+			retryAfter: int(c.MaintenanceRetryAfter.Seconds()),
+		}
+		if c.MaintenancePage != "" {
+			if opts.page, err = parseRetryPage(c.MaintenancePage); err != nil {
+				return fmt.Errorf("load maintenance page: %w", err)
+			}
+		} else {
+			opts.page = env.retryPages[http.StatusServiceUnavailable]
+		}
+		if opts.allow, err = ParseCIDRs(c.MaintenanceAllow); err != nil {
+			return fmt.Errorf("parse maintenance allow: %w", err)
+		}
+		mw["maintenance"] = func(h http.Handler) http.Handler { return Maintenance(opts, h) }
+	}
+	mw["ready"] = func(h http.Handler) http.Handler { return Ready(handler.ready, h) }
+	if c.InfoPath != "" {
+		started := time.Now()
+		mw["info"] = func(h http.Handler) http.Handler { return Info(c.InfoPath, c.Build, started, h) }
+	}
+	if c.AdminPath != "" {
+		if c.Auth == "" && c.AdminToken == "" {
+			return fmt.Errorf("admin: requires -auth or -admin-token")
+		}
+		mw["admin"] = func(h http.Handler) http.Handler { return Admin(c.AdminPath, c.AdminToken, caches, h) }
+	}
+	if c.HealthPath != "" {
+		mw["health"] = func(h http.Handler) http.Handler { return Health(c.HealthPath, handler.ready, h) }
+	}
+	if c.CanonicalHost != "" {
+		mw["canonical-host"] = func(h http.Handler) http.Handler {
+			return CanonicalHost(c.CanonicalHost, c.HealthPath, redirectCode, h)
+		}
+	}
+	if c.DefaultHost != "" || c.StrictHost {
+		mw["default-host"] = func(h http.Handler) http.Handler { return DefaultHost(c.DefaultHost, c.StrictHost, h) }
+	}
+	order := defaultMiddlewareOrder
+	if c.MiddlewareOrder != "" {
+		if order, err = parseMiddlewareOrder(c.MiddlewareOrder); err != nil {
+			return fmt.Errorf("parse middleware order: %w", err)
+		}
+	}
+	if h, err = chain(order, mw, h); err != nil {
+		return fmt.Errorf("assemble middleware: %w", err)
+	}
+	if ffs != nil {
+		// Ahead of all middleware, so that none matches paths differently
+		// cased than the files served.
+		h = CaseFold(ffs, h)
+	}
+	handler.Handler = withEnv(env, stripAuthClaims(h))
+	return nil
+}
@@ -0,0 +1,99 @@
+package serve
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFiles creates the files, by slash separated name, below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// writeHtpasswd writes an htpasswd file of the users and passwords to dir
+// and returns its name.
+func writeHtpasswd(t *testing.T, dir string, users map[string]string) string {
+	t.Helper()
+	var b []byte
+	for user, password := range users {
+		sum := sha1.Sum([]byte(password))
+		b = append(b, user+":{SHA}"+base64.StdEncoding.EncodeToString(sum[:])+"\n"...)
+	}
+	name := filepath.Join(dir, "htpasswd")
+	if err := ioutil.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// newTestHandler returns a ready handler of c serving the files from a
+// temporary directory.
+func newTestHandler(t *testing.T, c Config, files map[string]string) *Handler {
+	t.Helper()
+	h, _ := newTestHandlerRoot(t, c, files)
+	return h
+}
+
+// newTestHandlerRoot is newTestHandler also returning the directory, e.g. to
+// change files while they are served.
+func newTestHandlerRoot(t *testing.T, c Config, files map[string]string) (*Handler, string) {
+	t.Helper()
+	c.Root = t.TempDir()
+	writeFiles(t, c.Root, files)
+	h, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.Close() })
+	h.SetReady()
+	return h, c.Root
+}
+
+// get serves a GET request for the raw, uncleaned path, and sets the
+// header fields given as name and value pairs.
+func get(h http.Handler, path string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.URL.Path = path
+	r.RequestURI = path
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// newRequest parses a GET request for the raw request target, as the server
+// does.
+func newRequest(t *testing.T, target string) *http.Request {
+	t.Helper()
+	r, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET " + target + " HTTP/1.1\r\nHost: example.com\r\n\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.RemoteAddr = "192.0.2.1:1234"
+	return r
+}
+
+func serveRequest(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
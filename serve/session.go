@@ -0,0 +1,73 @@
+package serve
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const sessionCookie = "serve_session"
+
+// sessions issue signed cookies that let clients which have authenticated
+// once skip the auth challenge until the cookie expires. Changing the secret
+// invalidates all issued cookies.
+type sessions struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// valid reports whether r carries an unexpired session cookie signed with the
+// current secret.
+func (s *sessions) valid(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(c.Value, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	user, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	sig, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, s.sign(string(user), expires))
+}
+
+// issue sets a session cookie for user.
+func (s *sessions) issue(w http.ResponseWriter, r *http.Request, user string) {
+	expires := time.Now().Add(s.ttl)
+	value := fmt.Sprintf("%s.%d.%s",
+		base64.RawURLEncoding.EncodeToString([]byte(user)),
+		expires.Unix(),
+		hex.EncodeToString(s.sign(user, expires.Unix())))
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (s *sessions) sign(user string, expires int64) []byte {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%s\n%d", user, expires)
+	return mac.Sum(nil)
+}
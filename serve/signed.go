@@ -0,0 +1,50 @@
+package serve
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SignedURLs only allows requests carrying a valid, unexpired signature in
+// the expires and sig query parameters, see sign.
+func SignedURLs(key []byte, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+		if err != nil || time.Now().Unix() > expires {
+			logOf(r).debugf("signed url expired for %s from %s", r.URL.Path, r.RemoteAddr)
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		sig, err := hex.DecodeString(q.Get("sig"))
+		if err != nil || !hmac.Equal(sig, signature(key, r.URL.Path, expires)) {
+			logOf(r).debugf("invalid signature for %s from %s", r.URL.Path, r.RemoteAddr)
+			httpError(w, r, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// signature is the HMAC-SHA256 of the path and the expiry, separated by a
+// newline, e.g. "/file.txt\n1700000000".
+func signature(key []byte, path string, expires int64) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s\n%d", path, expires)
+	return mac.Sum(nil)
+}
+
+// Sign returns path with the query parameters of a signature valid until
+// expires.
+func Sign(key []byte, path string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("sig", hex.EncodeToString(signature(key, path, expires.Unix())))
+	return (&url.URL{Path: path, RawQuery: q.Encode()}).String()
+}
@@ -0,0 +1,117 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSitemapURLs is the maximum number of URLs of a sitemap by the sitemap
+// protocol. Larger sites are split into several sitemaps listed by an index.
+const maxSitemapURLs = 50000
+
+// sitemap holds the generated sitemap documents by path.
+type sitemap struct {
+	mu   sync.RWMutex
+	docs map[string][]byte
+}
+
+type sitemapURL struct {
+	loc     string
+	lastMod time.Time
+}
+
+// newSitemap walks fs in the background and generates a sitemap of its HTML
+// pages below base, a URL like https://example.com.
+func newSitemap(fs http.FileSystem, base string, opts walkOptions, log *Logger) *sitemap {
+	s := &sitemap{}
+	go func() {
+		start := time.Now()
+		var urls []sitemapURL
+		err := walk(fs, opts, func(name string, fi os.FileInfo) {
+			if ext := path.Ext(name); ext != ".html" && ext != ".htm" {
+				return
+			}
+			if path.Base(name) == "index.html" {
+				name = strings.TrimSuffix(name, "index.html")
+			}
+			urls = append(urls, sitemapURL{loc: base + (&url.URL{Path: name}).String(), lastMod: fi.ModTime()})
+		})
+		if err != nil {
+			log.warnf("sitemap incomplete: %v", err)
+		}
+		docs := buildSitemaps(base, urls)
+		s.mu.Lock()
+		s.docs = docs
+		s.mu.Unlock()
+		log.infof("generated sitemap of %d urls in %s", len(urls), time.Since(start))
+	}()
+	return s
+}
+
+// buildSitemaps returns /sitemap.xml, which is an index of /sitemap-1.xml,
+// /sitemap-2.xml, and so on if there are more than maxSitemapURLs urls.
+func buildSitemaps(base string, urls []sitemapURL) map[string][]byte {
+	sort.Slice(urls, func(i, j int) bool { return urls[i].loc < urls[j].loc })
+	if len(urls) <= maxSitemapURLs {
+		return map[string][]byte{"/sitemap.xml": urlset(urls)}
+	}
+	docs := map[string][]byte{}
+	index := &bytes.Buffer{}
+	index.WriteString(xml.Header + `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for i := 0; i*maxSitemapURLs < len(urls); i++ {
+		end := (i + 1) * maxSitemapURLs
+		if end > len(urls) {
+			end = len(urls)
+		}
+		name := fmt.Sprintf("/sitemap-%d.xml", i+1)
+		docs[name] = urlset(urls[i*maxSitemapURLs : end])
+		index.WriteString("<sitemap><loc>")
+		xml.EscapeText(index, []byte(base+name))
+		index.WriteString("</loc></sitemap>\n")
+	}
+	index.WriteString("</sitemapindex>\n")
+	docs["/sitemap.xml"] = index.Bytes()
+	return docs
+}
+
+func urlset(urls []sitemapURL) []byte {
+	b := &bytes.Buffer{}
+	b.WriteString(xml.Header + `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n")
+	for _, u := range urls {
+		b.WriteString("<url><loc>")
+		xml.EscapeText(b, []byte(u.loc))
+		fmt.Fprintf(b, "</loc><lastmod>%s</lastmod></url>\n", u.lastMod.UTC().Format("2006-01-02"))
+	}
+	b.WriteString("</urlset>\n")
+	return b.Bytes()
+}
+
+// Sitemap serves the sitemap documents of s. Until they are generated,
+// requests for /sitemap.xml are answered with 503.
+func Sitemap(s *sitemap, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		docs := s.docs
+		s.mu.RUnlock()
+		if docs == nil && r.URL.Path == "/sitemap.xml" {
+			retryError(w, r, http.StatusServiceUnavailable, 5)
+			return
+		}
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		http.ServeContent(w, r, r.URL.Path, time.Time{}, bytes.NewReader(doc))
+	})
+}
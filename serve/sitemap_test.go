@@ -0,0 +1,169 @@
+package serve
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// synthFS is a tree of dirs directories /d0, /d1, ... of files HTML pages
+// each, generated without touching the disk.
+type synthFS struct {
+	dirs, files int
+	// block, if not nil, blocks opening directories until closed.
+	block chan struct{}
+}
+
+type synthInfo struct {
+	name string
+	dir  bool
+}
+
+func (fi synthInfo) Name() string { return fi.name }
+func (fi synthInfo) Size() int64  { return 0 }
+func (fi synthInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+func (fi synthInfo) ModTime() time.Time { return time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC) }
+func (fi synthInfo) IsDir() bool        { return fi.dir }
+func (fi synthInfo) Sys() interface{}   { return nil }
+
+type synthDir struct {
+	http.File
+	infos []os.FileInfo
+}
+
+func (d synthDir) Readdir(int) ([]os.FileInfo, error) { return d.infos, nil }
+func (d synthDir) Close() error                       { return nil }
+
+func (fs synthFS) Open(name string) (http.File, error) {
+	if fs.block != nil {
+		<-fs.block
+	}
+	var infos []os.FileInfo
+	switch {
+	case name == "/":
+		for i := 0; i < fs.dirs; i++ {
+			infos = append(infos, synthInfo{name: fmt.Sprintf("d%d", i), dir: true})
+		}
+	case strings.Count(name, "/") == 1:
+		for i := 0; i < fs.files; i++ {
+			infos = append(infos, synthInfo{name: fmt.Sprintf("p%d.html", i)})
+		}
+	default:
+		return nil, os.ErrNotExist
+	}
+	return synthDir{infos: infos}, nil
+}
+
+func TestWalk(t *testing.T) {
+	seen := map[string]bool{}
+	err := walk(synthFS{dirs: 20, files: 50}, walkOptions{workers: 4}, func(name string, fi os.FileInfo) {
+		if seen[name] {
+			t.Errorf("%s walked twice", name)
+		}
+		seen[name] = true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1000 || !seen["/d19/p49.html"] {
+		t.Errorf("got %d files", len(seen))
+	}
+}
+
+func TestWalkTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+	start := time.Now()
+	err := walk(synthFS{dirs: 1, files: 1, block: block}, walkOptions{workers: 1, timeout: 50 * time.Millisecond}, func(string, os.FileInfo) {})
+	if err != errWalkTimeout {
+		t.Errorf("got error %v, want %v", err, errWalkTimeout)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("walk took %s", d)
+	}
+}
+
+func TestSitemapIndex(t *testing.T) {
+	s := newSitemap(synthFS{dirs: 51, files: 1000}, "https://example.com", walkOptions{workers: 8}, &Logger{Level: LevelError})
+	h := Sitemap(s, http.NotFoundHandler())
+	deadline := time.Now().Add(10 * time.Second)
+	w := get(h, "/sitemap.xml")
+	for w.Code == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w = get(h, "/sitemap.xml")
+	}
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d", w.Code)
+	}
+	var index struct {
+		Sitemaps []struct {
+			Loc string `xml:"loc"`
+		} `xml:"sitemap"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Sitemaps) != 2 || index.Sitemaps[0].Loc != "https://example.com/sitemap-1.xml" || index.Sitemaps[1].Loc != "https://example.com/sitemap-2.xml" {
+		t.Fatalf("got index %+v", index)
+	}
+	urls := map[string]bool{}
+	for i, want := range []int{maxSitemapURLs, 51000 - maxSitemapURLs} {
+		w := get(h, fmt.Sprintf("/sitemap-%d.xml", i+1))
+		var set struct {
+			URLs []struct {
+				Loc     string `xml:"loc"`
+				LastMod string `xml:"lastmod"`
+			} `xml:"url"`
+		}
+		if err := xml.Unmarshal(w.Body.Bytes(), &set); err != nil {
+			t.Fatal(err)
+		}
+		if len(set.URLs) != want {
+			t.Errorf("sitemap %d: got %d urls, want %d", i+1, len(set.URLs), want)
+		}
+		for _, u := range set.URLs {
+			urls[u.Loc] = true
+			if u.LastMod != "2024-01-02" {
+				t.Fatalf("got lastmod %q", u.LastMod)
+			}
+		}
+	}
+	if len(urls) != 51000 || !urls["https://example.com/d50/p999.html"] {
+		t.Errorf("got %d distinct urls", len(urls))
+	}
+}
+
+func TestSitemapSingle(t *testing.T) {
+	h := newTestHandler(t, func() Config {
+		c := DefaultConfig()
+		c.Sitemap = "https://example.com/"
+		return c
+	}(), map[string]string{"index.html": "", "a/b.html": "", "a/c.txt": ""})
+	deadline := time.Now().Add(5 * time.Second)
+	w := get(h, "/sitemap.xml")
+	for w.Code == http.StatusServiceUnavailable && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		w = get(h, "/sitemap.xml")
+	}
+	body := w.Body.String()
+	if w.Code != http.StatusOK || !strings.Contains(body, "<urlset") {
+		t.Fatalf("got status %d and body %q", w.Code, body)
+	}
+	for _, want := range []string{"<loc>https://example.com/</loc>", "<loc>https://example.com/a/b.html</loc>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %s in %s", want, body)
+		}
+	}
+	if strings.Contains(body, "c.txt") {
+		t.Errorf("non-HTML file listed: %s", body)
+	}
+}
@@ -0,0 +1,130 @@
+package serve
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// sniffRules map file extensions and magic byte prefixes to content types.
+type sniffRules struct {
+	exts  map[string]string
+	magic []magicRule
+}
+
+type magicRule struct {
+	prefix []byte
+	ctype  string
+}
+
+// parseSniffRules parses rules of the form .ext=type or 0xhex=type, the
+// latter matching files starting with the given bytes.
+func parseSniffRules(specs []string) (sniffRules, error) {
+	rules := sniffRules{exts: map[string]string{}}
+	for _, spec := range specs {
+		i := strings.IndexByte(spec, '=')
+		if i < 0 {
+			return rules, fmt.Errorf("invalid sniff rule: %s", spec)
+		}
+		key, ctype := spec[:i], spec[i+1:]
+		if _, _, err := mime.ParseMediaType(ctype); err != nil {
+			return rules, fmt.Errorf("invalid sniff content type: %s", ctype)
+		}
+		switch {
+		case strings.HasPrefix(key, ".") && len(key) > 1:
+			rules.exts[strings.ToLower(key)] = ctype
+		case strings.HasPrefix(key, "0x"):
+			prefix, err := hex.DecodeString(key[2:])
+			if err != nil || len(prefix) == 0 || len(prefix) > sniffLen {
+				return rules, fmt.Errorf("invalid sniff magic: %s", key)
+			}
+			rules.magic = append(rules.magic, magicRule{prefix: prefix, ctype: ctype})
+		default:
+			return rules, fmt.Errorf("invalid sniff rule: %s", spec)
+		}
+	}
+	return rules, nil
+}
+
+// sniffLen is the number of bytes read to sniff the content type, as by
+// http.DetectContentType.
+const sniffLen = 512
+
+// Sniff sets the content type of files by the rules, extension rules taking
+// precedence over magic rules, which take precedence over the type of the
+// extension. Files of unknown extensions that hold an SVG image, which
+// http.DetectContentType considers text/xml, are served as image/svg+xml.
+func Sniff(fs http.FileSystem, rules sniffRules, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		ext := strings.ToLower(path.Ext(name))
+		if strings.HasSuffix(r.URL.Path, "/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if ctype, ok := rules.exts[ext]; ok {
+			w.Header().Set("Content-Type", ctype)
+			h.ServeHTTP(w, r)
+			return
+		}
+		known := mime.TypeByExtension(ext) != ""
+		if len(rules.magic) == 0 && known {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if head := readHead(fs, name); head != nil {
+			if ctype := sniffHead(rules, head, known); ctype != "" {
+				w.Header().Set("Content-Type", ctype)
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// sniffHead returns the content type of a file starting with head, or "" to
+// keep the served one.
+func sniffHead(rules sniffRules, head []byte, known bool) string {
+	for _, m := range rules.magic {
+		if bytes.HasPrefix(head, m.prefix) {
+			return m.ctype
+		}
+	}
+	if !known && isSVG(head) {
+		return "image/svg+xml"
+	}
+	return ""
+}
+
+// isSVG reports whether head, the start of a file, is an SVG document,
+// possibly preceded by an XML declaration, comments or a doctype.
+func isSVG(head []byte) bool {
+	head = bytes.TrimLeft(head, "\ufeff \t\r\n")
+	for _, p := range []string{"<?xml", "<!--", "<!DOCTYPE svg", "<svg"} {
+		if bytes.HasPrefix(head, []byte(p)) {
+			return bytes.Contains(head, []byte("<svg"))
+		}
+	}
+	return false
+}
+
+// readHead returns the first bytes of the regular file name of fs, or nil if
+// it cannot be read.
+func readHead(fs http.FileSystem, name string) []byte {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	buf := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, buf)
+	return buf[:n]
+}
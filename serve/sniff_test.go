@@ -0,0 +1,63 @@
+package serve
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSniff(t *testing.T) {
+	const svg = `<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"></svg>`
+	c := DefaultConfig()
+	c.Charset = ""
+	c.SniffRules = []string{".model=model/x-custom", "0x4D59464D54=application/x-myformat"}
+	h := newTestHandler(t, c, map[string]string{
+		"icon.svg":      svg,
+		"icon":          svg,
+		"feed":          `<?xml version="1.0"?><rss></rss>`,
+		"scene.model":   "anything",
+		"data.bin":      "MYFMT\x00\x01\x02",
+		"data":          "MYFMT\x00\x01\x02",
+		"other.bin":     "\x00\x01\x02",
+		"report.txt":    "MYFMT but text",
+		"doc.html":      "<p>html</p>",
+		"dir/index.txt": "index",
+	})
+	tests := []struct {
+		path  string
+		ctype string
+	}{
+		{"/icon.svg", "image/svg+xml"},
+		// Without an extension, SVG is not mistaken for XML.
+		{"/icon", "image/svg+xml"},
+		{"/feed", "text/xml; charset=utf-8"},
+		{"/scene.model", "model/x-custom"},
+		// Magic bytes override the type of the extension, or the sniffed one.
+		{"/data.bin", "application/x-myformat"},
+		{"/data", "application/x-myformat"},
+		{"/report.txt", "application/x-myformat"},
+		{"/other.bin", "application/octet-stream"},
+		{"/doc.html", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", tt.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.ctype)
+		}
+	}
+	if w := get(h, "/dir/"); w.Code != http.StatusOK {
+		t.Errorf("directory: got status %d", w.Code)
+	}
+}
+
+func TestParseSniffRules(t *testing.T) {
+	for _, spec := range []string{"svg=image/svg+xml", ".=text/plain", ".x", "0x=a/b", "0xZZ=a/b", ".x=not a type", "magic=a/b"} {
+		if _, err := parseSniffRules([]string{spec}); err == nil {
+			t.Errorf("%s: got no error", spec)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+package serve
+
+import (
+	"container/heap"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxTrackedPaths bounds the number of paths whose timings are kept.
+	maxTrackedPaths = 1000
+	// slowPathsTop is the number of paths listed as slow_paths.
+	slowPathsTop = 10
+)
+
+// durationBuckets are the upper bounds of the histogram buckets from which
+// the p99 of a path is estimated. Longer durations fall into a last bucket.
+var durationBuckets = []time.Duration{
+	time.Millisecond, 2 * time.Millisecond, 5 * time.Millisecond,
+	10 * time.Millisecond, 20 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 200 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2 * time.Second, 5 * time.Second, 10 * time.Second,
+}
+
+// handlerStats are the statistics of a Handler.
+type handlerStats struct {
+	cacheHits   expvar.Int
+	cacheMisses expvar.Int
+	// coalesced counts the requests that waited for the load of another.
+	coalesced expvar.Int
+	slowPaths *pathTimings
+}
+
+func newHandlerStats() *handlerStats {
+	return &handlerStats{slowPaths: newPathTimings(maxTrackedPaths)}
+}
+
+// vars returns the statistics by name.
+func (s *handlerStats) vars() map[string]expvar.Var {
+	return map[string]expvar.Var{
+		"cache_hits":         &s.cacheHits,
+		"cache_misses":       &s.cacheMisses,
+		"coalesced_requests": &s.coalesced,
+		"slow_paths":         expvar.Func(func() interface{} { return s.slowPaths.top(slowPathsTop) }),
+	}
+}
+
+// pathTimings keeps the request durations of up to max paths. Once full,
+// a new path replaces the one with the fewest requests, found in a min-heap
+// by request count, so that adding costs O(log max).
+type pathTimings struct {
+	max int
+
+	mu    sync.Mutex
+	paths map[string]*pathTiming
+	heap  timingHeap
+}
+
+type pathTiming struct {
+	path    string
+	count   int64
+	total   time.Duration
+	buckets []int64
+	// index is the position in the heap.
+	index int
+}
+
+// timingHeap orders path timings by request count, the fewest first.
+type timingHeap []*pathTiming
+
+func (h timingHeap) Len() int           { return len(h) }
+func (h timingHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+
+func (h timingHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timingHeap) Push(x interface{}) {
+	p := x.(*pathTiming)
+	p.index = len(*h)
+	*h = append(*h, p)
+}
+
+func (h *timingHeap) Pop() interface{} {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
+
+// slowPath is the JSON form of the timings of a path.
+type slowPath struct {
+	Path  string  `json:"path"`
+	Count int64   `json:"count"`
+	Avg   float64 `json:"avg_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+func newPathTimings(max int) *pathTimings {
+	return &pathTimings{max: max, paths: map[string]*pathTiming{}}
+}
+
+func (t *pathTimings) add(path string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	p, ok := t.paths[path]
+	if !ok {
+		if len(t.paths) >= t.max {
+			// Evict the path with the fewest requests.
+			victim := heap.Pop(&t.heap).(*pathTiming)
+			delete(t.paths, victim.path)
+		}
+		p = &pathTiming{path: path, buckets: make([]int64, len(durationBuckets)+1)}
+		t.paths[path] = p
+		heap.Push(&t.heap, p)
+	}
+	p.count++
+	p.total += d
+	p.buckets[sort.Search(len(durationBuckets), func(i int) bool { return d <= durationBuckets[i] })]++
+	heap.Fix(&t.heap, p.index)
+}
+
+func (t *pathTimings) reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paths = map[string]*pathTiming{}
+	t.heap = nil
+}
+
+// top returns the n paths with the highest p99, ties broken by the average.
+func (t *pathTimings) top(n int) []slowPath {
+	t.mu.Lock()
+	list := make([]slowPath, 0, len(t.paths))
+	for path, p := range t.paths {
+		list = append(list, slowPath{
+			Path:  path,
+			Count: p.count,
+			Avg:   milliseconds(p.total / time.Duration(p.count)),
+			P99:   milliseconds(p.p99()),
+		})
+	}
+	t.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].P99 != list[j].P99 {
+			return list[i].P99 > list[j].P99
+		}
+		if list[i].Avg != list[j].Avg {
+			return list[i].Avg > list[j].Avg
+		}
+		return list[i].Path < list[j].Path
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// p99 is the upper bound of the bucket holding the 99th percentile. For the
+// last, unbounded bucket it is the lower bound.
+func (p *pathTiming) p99() time.Duration {
+	rank := (p.count*99 + 99) / 100
+	var seen int64
+	for i, n := range p.buckets {
+		seen += n
+		if seen >= rank && i < len(durationBuckets) {
+			return durationBuckets[i]
+		}
+	}
+	return durationBuckets[len(durationBuckets)-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// Stats serves the statistics s along with those published with expvar as
+// JSON at path and passes all other requests to h, timing them per path for
+// the slowest paths. Unlike expvar.Handler the command line is omitted, since
+// it may contain secrets. A reset query parameter clears the path timings
+// before they are served.
+func Stats(path string, s *handlerStats, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			start := time.Now()
+			h.ServeHTTP(w, r)
+			s.slowPaths.add(r.URL.Path, time.Since(start))
+			return
+		}
+		if _, ok := r.URL.Query()["reset"]; ok {
+			s.slowPaths.reset()
+		}
+		vars := s.vars()
+		// The handler's own statistics win over published ones of the same
+		// name, e.g. of another handler.
+		expvar.Do(func(kv expvar.KeyValue) {
+			if _, ok := vars[kv.Key]; !ok && kv.Key != "cmdline" {
+				vars[kv.Key] = kv.Value
+			}
+		})
+		names := make([]string, 0, len(vars))
+		for name := range vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, "{\n")
+		for i, name := range names {
+			if i > 0 {
+				fmt.Fprintf(w, ",\n")
+			}
+			fmt.Fprintf(w, "%q: %s", name, vars[name])
+		}
+		fmt.Fprintf(w, "\n}\n")
+	})
+}
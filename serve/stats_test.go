@@ -0,0 +1,103 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsPerHandler(t *testing.T) {
+	c := DefaultConfig()
+	c.CacheTTL = time.Minute
+	c.StatsPath = "/_stats"
+	files := map[string]string{"a.txt": "a"}
+	// A second handler must not panic by publishing the same names.
+	h1 := newTestHandler(t, c, files)
+	h2 := newTestHandler(t, c, files)
+	for i := 0; i < 3; i++ {
+		if w := get(h1, "/a.txt"); w.Code != http.StatusOK {
+			t.Fatalf("got status %d", w.Code)
+		}
+	}
+	stats := func(h *Handler) map[string]json.RawMessage {
+		t.Helper()
+		w := get(h, "/_stats")
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &m); err != nil {
+			t.Fatalf("%v: %s", err, w.Body)
+		}
+		return m
+	}
+	s1, s2 := stats(h1), stats(h2)
+	if got := string(s1["cache_hits"]); got == "0" || got == "" {
+		t.Errorf("got cache_hits %q of the first handler", got)
+	}
+	if got := string(s2["cache_hits"]); got != "0" {
+		t.Errorf("got cache_hits %q of the second handler, want 0", got)
+	}
+	if _, ok := s1["slow_paths"]; !ok {
+		t.Error("slow_paths missing")
+	}
+	if _, ok := s1["cmdline"]; ok {
+		t.Error("cmdline served")
+	}
+	if h1.Vars()["cache_hits"].String() != string(s1["cache_hits"]) {
+		t.Errorf("got Vars cache_hits %s, served %s", h1.Vars()["cache_hits"], s1["cache_hits"])
+	}
+}
+
+func TestSlowPathsRanking(t *testing.T) {
+	s := newHandlerStats()
+	// Each path falls into another bucket, /d0 into the fastest.
+	for i, d := range durationBuckets {
+		for j := 0; j < 5; j++ {
+			s.slowPaths.add(fmt.Sprintf("/d%d", i), d)
+		}
+	}
+	// Both fall into the last bucket and are ranked by the average.
+	s.slowPaths.add("/slower", 30*time.Second)
+	s.slowPaths.add("/slow", 20*time.Second)
+	var list []slowPath
+	if err := json.Unmarshal([]byte(s.vars()["slow_paths"].String()), &list); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range list {
+		got = append(got, p.Path)
+	}
+	want := "/slower /slow /d12 /d11 /d10 /d9 /d8 /d7 /d6 /d5"
+	if len(list) != slowPathsTop || strings.Join(got, " ") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if p := list[2]; p.Count != 5 || p.Avg != 10000 || p.P99 != 10000 {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestSlowPathsEviction(t *testing.T) {
+	pt := newPathTimings(3)
+	for i := 0; i < 3; i++ {
+		pt.add("/busy", time.Millisecond)
+		pt.add("/less", time.Millisecond)
+	}
+	pt.add("/less", time.Millisecond)
+	pt.add("/once", time.Millisecond)
+	// The path with the fewest requests makes room for a new one.
+	pt.add("/new", time.Millisecond)
+	pt.add("/new", time.Millisecond)
+	got := map[string]int64{}
+	for _, p := range pt.top(10) {
+		got[p.Path] = p.Count
+	}
+	if len(got) != 3 || got["/busy"] != 3 || got["/less"] != 4 || got["/new"] != 2 {
+		t.Errorf("got %v", got)
+	}
+	pt.reset()
+	pt.add("/a", time.Millisecond)
+	if top := pt.top(10); len(top) != 1 || top[0].Path != "/a" {
+		t.Errorf("after reset: got %v", top)
+	}
+}
@@ -0,0 +1,30 @@
+package serve
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Content serves data at / and answers all other paths with 404. The content
+// type is sniffed if ctype is empty.
+func Content(data []byte, ctype string) http.Handler {
+	if ctype == "" {
+		ctype = http.DetectContentType(data)
+	}
+	modTime := time.Now()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			httpError(w, r, "404 page not found", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", ctype)
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(data))
+	})
+}
+
+// emptyFS is a file system without any files.
+type emptyFS struct{}
+
+func (emptyFS) Open(name string) (http.File, error) { return nil, os.ErrNotExist }
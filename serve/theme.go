@@ -0,0 +1,70 @@
+package serve
+
+import (
+	"embed"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"path"
+	"strings"
+)
+
+//go:embed static/listing.css
+var listingCSS string
+
+//go:embed static/icons/*.svg
+var listingIcons embed.FS
+
+// listingStyle is the CSS of directory listings including the icon classes.
+var listingStyle = buildListingStyle()
+
+// listingThemes are the supported themes. The auto theme follows the
+// prefers-color-scheme of the client.
+var listingThemes = map[string]bool{"light": true, "dark": true, "auto": true}
+
+// iconsByExt maps file extensions to icon names.
+var iconsByExt = map[string]string{}
+
+func init() {
+	for icon, exts := range map[string][]string{
+		"image":   {".png", ".jpg", ".jpeg", ".gif", ".svg", ".webp", ".avif", ".bmp", ".ico", ".tif", ".tiff"},
+		"archive": {".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".zst", ".7z", ".rar"},
+		"code":    {".go", ".js", ".mjs", ".ts", ".css", ".html", ".htm", ".json", ".xml", ".yaml", ".yml", ".py", ".rb", ".rs", ".c", ".h", ".cpp", ".java", ".sh"},
+		"text":    {".txt", ".md", ".csv", ".log", ".pdf", ".doc", ".docx", ".rtf"},
+		"audio":   {".mp3", ".wav", ".ogg", ".flac", ".m4a", ".aac"},
+		"video":   {".mp4", ".webm", ".mkv", ".mov", ".avi"},
+	} {
+		for _, ext := range exts {
+			iconsByExt[ext] = icon
+		}
+	}
+}
+
+// iconFor returns the name of the icon of a listing entry.
+func iconFor(name string, dir bool) string {
+	if dir {
+		return "folder"
+	}
+	if icon, ok := iconsByExt[strings.ToLower(path.Ext(name))]; ok {
+		return icon
+	}
+	return "file"
+}
+
+func buildListingStyle() template.CSS {
+	b := &strings.Builder{}
+	b.WriteString(listingCSS)
+	entries, err := listingIcons.ReadDir("static/icons")
+	if err != nil {
+		panic(err)
+	}
+	for _, e := range entries {
+		svg, err := listingIcons.ReadFile("static/icons/" + e.Name())
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(b, "\n.icon-%s {\n\tbackground-image: url(\"data:image/svg+xml;base64,%s\");\n}\n",
+			strings.TrimSuffix(e.Name(), ".svg"), base64.StdEncoding.EncodeToString(svg))
+	}
+	return template.CSS(b.String())
+}
@@ -0,0 +1,66 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestListingTheme(t *testing.T) {
+	files := map[string]string{"photo.JPG": "", "src.tar.gz": "", "main.go": "", "notes": "", "sub/a.txt": ""}
+	for _, theme := range []string{"light", "dark", "auto"} {
+		c := DefaultConfig()
+		c.ListingTheme = theme
+		h := newTestHandler(t, c, files)
+		w := get(h, "/")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d", theme, w.Code)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, `<body class="theme-`+theme+`">`) {
+			t.Errorf("%s: theme class missing", theme)
+		}
+		for _, icon := range []string{"image", "archive", "code", "file", "folder"} {
+			if !strings.Contains(body, `class="icon icon-`+icon+`"`) {
+				t.Errorf("%s: icon %s missing", theme, icon)
+			}
+			if !strings.Contains(body, ".icon-"+icon+" {") {
+				t.Errorf("%s: style of icon %s missing", theme, icon)
+			}
+		}
+		if !strings.Contains(body, "prefers-color-scheme") {
+			t.Errorf("%s: auto theme style missing", theme)
+		}
+	}
+	c := DefaultConfig()
+	c.Root = t.TempDir()
+	c.ListingTheme = "neon"
+	if h, err := New(c); err == nil {
+		h.Close()
+		t.Error("got no error for an unknown theme")
+	}
+}
+
+func TestIconFor(t *testing.T) {
+	tests := []struct {
+		name string
+		dir  bool
+		want string
+	}{
+		{"docs", true, "folder"},
+		{"a.png", false, "image"},
+		{"A.PNG", false, "image"},
+		{"a.tar.gz", false, "archive"},
+		{"a.go", false, "code"},
+		{"a.md", false, "text"},
+		{"a.mp3", false, "audio"},
+		{"a.mkv", false, "video"},
+		{"Makefile", false, "file"},
+		{"a.unknown", false, "file"},
+	}
+	for _, tt := range tests {
+		if got := iconFor(tt.name, tt.dir); got != tt.want {
+			t.Errorf("iconFor(%q, %v) = %q, want %q", tt.name, tt.dir, got, tt.want)
+		}
+	}
+}
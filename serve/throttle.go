@@ -0,0 +1,125 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxThrottleBurst bounds the bytes written at once by a throttled response.
+const maxThrottleBurst = 32 << 10
+
+// parseByteRate parses a rate like "1MB/s", "512KB/s" or "1024" in bytes per
+// second, using base-1024 units. Rates below 1 B/s, like "0.5", are
+// rejected rather than rounded down to no limit at all.
+func parseByteRate(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSuffix(strings.TrimSpace(s), "/s"))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(v, u.suffix) {
+			v, mult = strings.TrimSuffix(v, u.suffix), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+	// The negated comparison also rejects NaN.
+	if err != nil || !(n*float64(mult) < 1<<63) {
+		return 0, fmt.Errorf("invalid rate: %s", s)
+	}
+	if n*float64(mult) < 1 {
+		return 0, fmt.Errorf("rate must be at least 1 B/s: %s", s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// Throttle limits the throughput of each response to bytesPerSec. If perIP
+// is set, all responses to the same client IP share the limit.
+func Throttle(bytesPerSec int64, perIP bool, h http.Handler) http.Handler {
+	burst := int(bytesPerSec)
+	if burst > maxThrottleBurst {
+		burst = maxThrottleBurst
+	}
+	limiters := &ipLimiters{limit: rate.Limit(bytesPerSec), burst: burst, entries: map[string]*ipLimiter{}}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var l *rate.Limiter
+		if perIP {
+			l = limiters.get(remoteIP(r.RemoteAddr).String())
+		} else {
+			l = rate.NewLimiter(rate.Limit(bytesPerSec), burst)
+		}
+		h.ServeHTTP(withOptional(&throttledWriter{ResponseWriter: w, limiter: l, ctx: r.Context()}, w), r)
+	})
+}
+
+// ipLimiters hands out a shared limiter per client IP and forgets limiters
+// that have not been used for a minute.
+type ipLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	entries map[string]*ipLimiter
+	swept   time.Time
+}
+
+type ipLimiter struct {
+	*rate.Limiter
+	lastUsed time.Time
+}
+
+func (ls *ipLimiters) get(ip string) *rate.Limiter {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	now := time.Now()
+	if now.Sub(ls.swept) > time.Minute {
+		for key, e := range ls.entries {
+			if now.Sub(e.lastUsed) > time.Minute {
+				delete(ls.entries, key)
+			}
+		}
+		ls.swept = now
+	}
+	e, ok := ls.entries[ip]
+	if !ok {
+		e = &ipLimiter{Limiter: rate.NewLimiter(ls.limit, ls.burst)}
+		ls.entries[ip] = e
+	}
+	e.lastUsed = now
+	return e.Limiter
+}
+
+// throttledWriter waits for the limiter before writing each chunk.
+type throttledWriter struct {
+	http.ResponseWriter
+	limiter *rate.Limiter
+	ctx     context.Context
+}
+
+func (w *throttledWriter) Write(b []byte) (int, error) {
+	written := 0
+	for len(b) > 0 {
+		n := len(b)
+		if n > w.limiter.Burst() {
+			n = w.limiter.Burst()
+		}
+		if err := w.limiter.WaitN(w.ctx, n); err != nil {
+			return written, err
+		}
+		m, err := w.ResponseWriter.Write(b[:n])
+		written += m
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,59 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseByteRate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"1024", 1024, true},
+		{"1", 1, true},
+		{"1MB/s", 1 << 20, true},
+		{"512KB/s", 512 << 10, true},
+		{"1.5k", 1536, true},
+		{" 2 GB/s ", 2 << 30, true},
+		{"100b/s", 100, true},
+		{"0.5K", 512, true},
+		{"0.5", 0, false},
+		{"0.9B/s", 0, false},
+		{"0", 0, false},
+		{"-1MB/s", 0, false},
+		{"", 0, false},
+		{"MB/s", 0, false},
+		{"fast", 0, false},
+		{"NaN", 0, false},
+		{"Inf", 0, false},
+		{"1e30GB", 0, false},
+	}
+	for _, tt := range tests {
+		got, err := parseByteRate(tt.in)
+		if (err == nil) != tt.ok || got != tt.want {
+			t.Errorf("parseByteRate(%q) = %d, %v, want %d and ok %v", tt.in, got, err, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const rate = 64 << 10
+	body := strings.Repeat("x", rate*3/2)
+	h := Throttle(rate, false, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	start := time.Now()
+	w := get(h, "/")
+	// Beyond the first burst of maxThrottleBurst bytes, the body takes a
+	// second.
+	if d := time.Since(start); d < 900*time.Millisecond {
+		t.Errorf("took %v", d)
+	}
+	if w.Body.Len() != len(body) {
+		t.Errorf("got %d bytes, want %d", w.Body.Len(), len(body))
+	}
+}
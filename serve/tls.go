@@ -0,0 +1,116 @@
+package serve
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// TLSOptions configure TLS serving.
+type TLSOptions struct {
+	// Cert and Key are the paths of the PEM encoded certificate and key.
+	Cert string
+	Key  string
+	// SessionTickets enables session resumption with session tickets.
+	SessionTickets bool
+	// TicketRotation is the interval at which ticket keys are rotated.
+	TicketRotation time.Duration
+	// SelfSigned generates a self-signed certificate for Hosts if Cert or
+	// Key does not exist. SaveSelfSigned writes it to Cert and Key, so it is
+	// reused by later starts.
+	SelfSigned     bool
+	SaveSelfSigned bool
+	Hosts          []string
+	// Logger logs the use of self-signed certificates and key rotations.
+	Logger *Logger
+}
+
+// selfSignedValidity is the validity of generated self-signed certificates.
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// NewTLSConfig loads the certificate and key and configures session
+// resumption. With a ticket rotation interval, a fresh ticket key is generated
+// every interval and the previous one is kept for one more interval to decrypt
+// tickets issued shortly before the rotation.
+func NewTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cert, err := loadCert(opts)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates:           []tls.Certificate{cert},
+		NextProtos:             []string{"h2", "http/1.1"},
+		SessionTicketsDisabled: !opts.SessionTickets,
+	}
+	if opts.SessionTickets && opts.TicketRotation > 0 {
+		if err := rotateTicketKeys(config, opts.TicketRotation, opts.Logger); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+// loadCert loads the certificate and key, or generates a self-signed
+// certificate if enabled and either is missing.
+func loadCert(opts TLSOptions) (tls.Certificate, error) {
+	if !opts.SelfSigned || fileExists(opts.Cert) && fileExists(opts.Key) {
+		return tls.LoadX509KeyPair(opts.Cert, opts.Key)
+	}
+	if opts.SaveSelfSigned && (opts.Cert == "" || opts.Key == "") {
+		return tls.Certificate{}, fmt.Errorf("saving a self-signed certificate requires paths for the certificate and key")
+	}
+	certPEM, keyPEM, err := GenerateCert(opts.Hosts, selfSignedValidity)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if opts.SaveSelfSigned {
+		if err := ioutil.WriteFile(opts.Key, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := ioutil.WriteFile(opts.Cert, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	opts.Logger.warnf("serving a self-signed certificate for %s, which clients do not trust", strings.Join(opts.Hosts, ", "))
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// fileExists reports whether the file name exists.
+func fileExists(name string) bool {
+	if name == "" {
+		return false
+	}
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+func rotateTicketKeys(config *tls.Config, interval time.Duration, log *Logger) error {
+	current, err := newTicketKey()
+	if err != nil {
+		return err
+	}
+	config.SetSessionTicketKeys([][32]byte{current})
+	go func() {
+		for range time.Tick(interval) {
+			next, err := newTicketKey()
+			if err != nil {
+				log.errorf("rotate session ticket key: %v", err)
+				continue
+			}
+			config.SetSessionTicketKeys([][32]byte{next, current})
+			current = next
+			log.debugf("rotated session ticket key")
+		}
+	}()
+	return nil
+}
+
+func newTicketKey() ([32]byte, error) {
+	var key [32]byte
+	_, err := rand.Read(key[:])
+	return key, err
+}
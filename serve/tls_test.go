@@ -0,0 +1,145 @@
+package serve
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// startTLS serves h over TLS with opts and a self-signed certificate, and
+// returns its URL.
+func startTLS(t *testing.T, opts TLSOptions) string {
+	t.Helper()
+	opts.SelfSigned = true
+	opts.Hosts = []string{"127.0.0.1"}
+	opts.Logger = &Logger{Level: LevelError}
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})}
+	go s.Serve(tls.NewListener(ln, config))
+	t.Cleanup(func() { s.Close() })
+	return "https://" + ln.Addr().String() + "/"
+}
+
+// resumed reports whether each of a sequence of connections to url,
+// sharing one client session cache, resumed a session, waiting for wait
+// before each.
+func resumed(t *testing.T, url string, waits ...time.Duration) []bool {
+	t.Helper()
+	client := &http.Client{Transport: &http.Transport{
+		TLSClientConfig: &tls.Config{
+			InsecureSkipVerify: true,
+			ClientSessionCache: tls.NewLRUClientSessionCache(8),
+		},
+		DisableKeepAlives: true,
+	}}
+	var got []bool
+	for _, wait := range waits {
+		time.Sleep(wait)
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		got = append(got, resp.TLS.DidResume)
+	}
+	return got
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	url := startTLS(t, TLSOptions{SessionTickets: true})
+	if got := resumed(t, url, 0, 0); got[0] || !got[1] {
+		t.Errorf("got resumed %v, want [false true]", got)
+	}
+}
+
+func TestTLSSessionTicketsDisabled(t *testing.T) {
+	url := startTLS(t, TLSOptions{SessionTickets: false})
+	if got := resumed(t, url, 0, 0); got[0] || got[1] {
+		t.Errorf("got resumed %v, want [false false]", got)
+	}
+}
+
+func TestTLSTicketRotation(t *testing.T) {
+	const interval = 100 * time.Millisecond
+	url := startTLS(t, TLSOptions{SessionTickets: true, TicketRotation: interval})
+	// A ticket survives one rotation, but not two.
+	if got := resumed(t, url, 0, interval/2+interval/4); got[0] || !got[1] {
+		t.Errorf("within an interval: got resumed %v, want [false true]", got)
+	}
+	if got := resumed(t, url, 0, 3*interval); got[0] || got[1] {
+		t.Errorf("after two rotations: got resumed %v, want [false false]", got)
+	}
+}
+
+func TestTLSSelfSigned(t *testing.T) {
+	dir := t.TempDir()
+	opts := TLSOptions{
+		Cert:           filepath.Join(dir, "cert.pem"),
+		Key:            filepath.Join(dir, "key.pem"),
+		SelfSigned:     true,
+		SaveSelfSigned: true,
+		Hosts:          []string{"localhost", "127.0.0.1"},
+		Logger:         &Logger{Level: LevelError},
+	}
+	config, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("secure")) })}
+	go s.Serve(ln)
+	defer s.Close()
+	// Clients trusting the saved certificate verify it for the hosts.
+	certPEM, err := ioutil.ReadFile(opts.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(certPEM) {
+		t.Fatal("saved certificate not parsed")
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	resp, err := client.Get("https://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "secure" {
+		t.Errorf("got body %q", body)
+	}
+	// A later start reuses the saved certificate.
+	again, err := NewTLSConfig(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again.Certificates[0].Certificate[0], config.Certificates[0].Certificate[0]) {
+		t.Error("saved certificate not reused")
+	}
+}
+
+func TestTLSSelfSignedErrors(t *testing.T) {
+	if _, err := NewTLSConfig(TLSOptions{SelfSigned: true, SaveSelfSigned: true, Hosts: []string{"localhost"}}); err == nil {
+		t.Error("saving without paths: got no error")
+	}
+	dir := t.TempDir()
+	if _, err := NewTLSConfig(TLSOptions{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")}); err == nil {
+		t.Error("missing files without -tls-self-signed: got no error")
+	}
+}
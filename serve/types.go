@@ -0,0 +1,89 @@
+package serve
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// DefaultType serves files without an extension, like LICENSE or Dockerfile,
+// as ctype when their content looks like text. Binary files keep the sniffed
+// type.
+func DefaultType(fs http.FileSystem, ctype string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + r.URL.Path)
+		if path.Ext(name) == "" && !strings.HasSuffix(r.URL.Path, "/") && isTextFile(fs, name) {
+			w.Header().Set("Content-Type", ctype)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isTextFile reports whether the regular file name of fs starts with text.
+func isTextFile(fs http.FileSystem, name string) bool {
+	f, err := fs.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return strings.HasPrefix(http.DetectContentType(buf[:n]), "text/")
+}
+
+// Charset appends a charset parameter to textual content types that lack
+// one.
+func Charset(charset string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(withOptional(&charsetWriter{ResponseWriter: w, charset: charset}, w), r)
+	})
+}
+
+// charsetWriter adds the charset to the content type when the header is
+// written.
+type charsetWriter struct {
+	http.ResponseWriter
+	charset     string
+	wroteHeader bool
+}
+
+func (w *charsetWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if ctype := w.Header().Get("Content-Type"); ctype != "" {
+			w.Header().Set("Content-Type", withCharset(ctype, w.charset))
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *charsetWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// withCharset returns ctype with the charset parameter if it is textual and
+// has none.
+func withCharset(ctype string, charset string) string {
+	mediaType, params, err := mime.ParseMediaType(ctype)
+	if err != nil || params["charset"] != "" || !isTextual(mediaType) {
+		return ctype
+	}
+	return ctype + "; charset=" + charset
+}
+
+func isTextual(mediaType string) bool {
+	switch mediaType {
+	case "application/json", "application/javascript":
+		return true
+	}
+	return strings.HasPrefix(mediaType, "text/")
+}
@@ -0,0 +1,108 @@
+package serve
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestDefaultType(t *testing.T) {
+	c := DefaultConfig()
+	c.DefaultType = "text/plain"
+	c.Charset = ""
+	h := newTestHandler(t, c, map[string]string{
+		"LICENSE":    "MIT License\n\nPermission is hereby granted...\n",
+		"Dockerfile": "FROM golang:1.16\nRUN go build\n",
+		"blob":       "\x00\x01\x02\x03binary",
+		"archive":    "PK\x03\x04zipped",
+		"page.html":  "<p>html</p>",
+		"dir/a.txt":  "a",
+	})
+	tests := []struct {
+		path  string
+		ctype string
+	}{
+		{"/LICENSE", "text/plain"},
+		{"/Dockerfile", "text/plain"},
+		{"/blob", "application/octet-stream"},
+		{"/archive", "application/zip"},
+		{"/page.html", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", tt.path, w.Code)
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.ctype)
+		}
+	}
+	if got := get(h, "/dir/").Header().Get("Content-Type"); got == "text/plain" {
+		t.Errorf("directory served as %q", got)
+	}
+}
+
+func TestCharset(t *testing.T) {
+	c := DefaultConfig()
+	c.DefaultType = "text/plain; charset=utf-8"
+	h := newTestHandler(t, c, map[string]string{
+		"a.txt":     "text",
+		"a.css":     "body {}",
+		"a.json":    `{"a":1}`,
+		"a.js":      "let a",
+		"a.png":     "\x89PNG\r\n\x1a\n",
+		"a.svg":     "<svg xmlns=\"http://www.w3.org/2000/svg\"/>",
+		"README":    "plain text\n",
+		"dir/b.txt": "b",
+	})
+	tests := []struct {
+		path  string
+		ctype string
+	}{
+		{"/a.txt", "text/plain; charset=utf-8"},
+		{"/a.css", "text/css; charset=utf-8"},
+		{"/a.json", "application/json; charset=utf-8"},
+		{"/a.png", "image/png"},
+		// The default type carries the charset already.
+		{"/README", "text/plain; charset=utf-8"},
+		{"/dir/", "text/html; charset=utf-8"},
+	}
+	for _, tt := range tests {
+		w := get(h, tt.path)
+		if got := w.Header().Get("Content-Type"); got != tt.ctype {
+			t.Errorf("%s: got Content-Type %q, want %q", tt.path, got, tt.ctype)
+		}
+	}
+	if got := get(h, "/a.js").Header().Get("Content-Type"); strings.Count(got, "charset=") != 1 {
+		t.Errorf("/a.js: got Content-Type %q", got)
+	}
+	if got := get(h, "/a.svg").Header().Get("Content-Type"); strings.Contains(got, "charset") {
+		t.Errorf("/a.svg: got Content-Type %q", got)
+	}
+}
+
+func TestWithCharset(t *testing.T) {
+	tests := []struct {
+		ctype string
+		want  string
+	}{
+		{"text/plain", "text/plain; charset=utf-8"},
+		{"text/html; charset=iso-8859-1", "text/html; charset=iso-8859-1"},
+		{"text/html; Charset=ISO-8859-1", "text/html; Charset=ISO-8859-1"},
+		{"application/json", "application/json; charset=utf-8"},
+		{"application/octet-stream", "application/octet-stream"},
+		{"image/svg+xml", "image/svg+xml"},
+		{"invalid;;", "invalid;;"},
+	}
+	for _, tt := range tests {
+		got := withCharset(tt.ctype, "utf-8")
+		if got != tt.want {
+			t.Errorf("withCharset(%q) = %q, want %q", tt.ctype, got, tt.want)
+		}
+		// Applying it again changes nothing.
+		if again := withCharset(got, "utf-8"); again != got {
+			t.Errorf("withCharset(%q) = %q, appended again", got, again)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+var errWalkTimeout = errors.New("timeout")
+
+// walkOptions bound walks of the served tree.
+type walkOptions struct {
+	// workers is the number of directories read concurrently.
+	workers int
+	// timeout bounds the duration of the walk. 0 means no limit.
+	timeout time.Duration
+}
+
+type walkResult struct {
+	name string
+	info os.FileInfo
+}
+
+// walk calls fn with the name and info of every regular file of fs, reading
+// up to opts.workers directories concurrently. Results are streamed to fn in
+// no particular order from the calling goroutine. Unreadable directories are
+// skipped and the first error is returned after the walk; a walk exceeding
+// the timeout ends early with errWalkTimeout, even if a read is blocked.
+func walk(fs http.FileSystem, opts walkOptions, fn func(name string, fi os.FileInfo)) error {
+	ctx := context.Background()
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+	workers := opts.workers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	results := make(chan walkResult, 256)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	var visit func(name string)
+	visit = func(name string) {
+		defer wg.Done()
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		infos, err := readDir(fs, name)
+		<-sem
+		if err != nil {
+			mu.Lock()
+			if firstErr == nil {
+				firstErr = err
+			}
+			mu.Unlock()
+			return
+		}
+		for _, fi := range infos {
+			child := path.Join(name, fi.Name())
+			if fi.IsDir() {
+				wg.Add(1)
+				go visit(child)
+				continue
+			}
+			if !fi.Mode().IsRegular() {
+				continue
+			}
+			select {
+			case results <- walkResult{name: child, info: fi}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+	wg.Add(1)
+	go visit("/")
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+	for {
+		select {
+		case r, ok := <-results:
+			if !ok {
+				return firstErr
+			}
+			fn(r.name, r.info)
+		case <-ctx.Done():
+			// Reads still blocked end in the background.
+			return errWalkTimeout
+		}
+	}
+}
+
+func readDir(fs http.FileSystem, name string) ([]os.FileInfo, error) {
+	d, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Readdir(-1)
+}
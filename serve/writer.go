@@ -0,0 +1,185 @@
+package serve
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+)
+
+// isClientDisconnect reports whether err, returned by writing the response
+// to r, was caused by the client going away.
+func isClientDisconnect(err error, r *http.Request) bool {
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// The response writer wrappers delegate the optional http.Flusher,
+// http.Hijacker and http.Pusher interfaces to the writer they wrap, so that
+// streaming, upgrades and server push keep working through the middleware.
+// They are passed on by withOptional, which hides the interfaces the wrapped
+// writer lacks.
+
+// optionalWriter is a response writer wrapper implementing all optional
+// interfaces.
+type optionalWriter interface {
+	http.ResponseWriter
+	http.Flusher
+	http.Hijacker
+	http.Pusher
+}
+
+// withOptional returns wrapper as a writer implementing only those optional
+// interfaces that w, the writer it wraps, implements, so that handlers
+// type-asserting for them learn what the connection supports.
+func withOptional(wrapper optionalWriter, w http.ResponseWriter) http.ResponseWriter {
+	_, f := w.(http.Flusher)
+	_, hj := w.(http.Hijacker)
+	_, p := w.(http.Pusher)
+	switch {
+	case f && hj && p:
+		return wrapper
+	case f && hj:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{wrapper, wrapper, wrapper}
+	case f && p:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{wrapper, wrapper, wrapper}
+	case hj && p:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{wrapper, wrapper, wrapper}
+	case f:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{wrapper, wrapper}
+	case hj:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{wrapper, wrapper}
+	case p:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{wrapper, wrapper}
+	}
+	return struct{ http.ResponseWriter }{wrapper}
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := w.(http.Hijacker); ok {
+		return hj.Hijack()
+	}
+	return nil, nil, fmt.Errorf("hijack: %T does not implement http.Hijacker", w)
+}
+
+func push(w http.ResponseWriter, target string, opts *http.PushOptions) error {
+	if p, ok := w.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+func (w *statusRecorder) Flush() { flush(w.ResponseWriter) }
+
+func (w *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *statusRecorder) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *gzipResponseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *throttledWriter) Flush() { flush(w.ResponseWriter) }
+
+func (w *throttledWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *throttledWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *charsetWriter) Flush() { flush(w.ResponseWriter) }
+
+func (w *charsetWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *charsetWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *errorInterceptor) Flush() { flush(w.ResponseWriter) }
+
+func (w *errorInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *errorInterceptor) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *noChallengeWriter) Flush() { flush(w.ResponseWriter) }
+
+func (w *noChallengeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *noChallengeWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *slowBodyWriter) Flush() { flush(w.ResponseWriter) }
+
+func (w *slowBodyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *slowBodyWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *baseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *baseWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
+
+func (w *redirectCodeWriter) Flush() { flush(w.ResponseWriter) }
+
+func (w *redirectCodeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return hijack(w.ResponseWriter)
+}
+
+func (w *redirectCodeWriter) Push(target string, opts *http.PushOptions) error {
+	return push(w.ResponseWriter, target, opts)
+}
@@ -0,0 +1,255 @@
+package serve
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestWrappersDelegate(t *testing.T) {
+	for _, w := range []http.ResponseWriter{
+		&statusRecorder{},
+		&gzipResponseWriter{},
+		&throttledWriter{},
+		&charsetWriter{},
+		&errorInterceptor{},
+		&noChallengeWriter{},
+		&slowBodyWriter{},
+		&baseWriter{},
+		&redirectCodeWriter{},
+	} {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("%T is no http.Flusher", w)
+		}
+		if _, ok := w.(http.Hijacker); !ok {
+			t.Errorf("%T is no http.Hijacker", w)
+		}
+		if _, ok := w.(http.Pusher); !ok {
+			t.Errorf("%T is no http.Pusher", w)
+		}
+	}
+}
+
+// capabilities reports the optional interfaces of w.
+func capabilities(w http.ResponseWriter) string {
+	var caps []string
+	if _, ok := w.(http.Flusher); ok {
+		caps = append(caps, "flush")
+	}
+	if _, ok := w.(http.Hijacker); ok {
+		caps = append(caps, "hijack")
+	}
+	if _, ok := w.(http.Pusher); ok {
+		caps = append(caps, "push")
+	}
+	return strings.Join(caps, ",")
+}
+
+func TestWrappersExposeOnlyDelegated(t *testing.T) {
+	al, err := NewAccessLog("text", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	al.setOutput(io.Discard)
+	middleware := map[string]func(http.Handler) http.Handler{
+		"log":      func(h http.Handler) http.Handler { return LogRequests(al, h) },
+		"gzip":     func(h http.Handler) http.Handler { return GZIP(nil, h) },
+		"charset":  func(h http.Handler) http.Handler { return Charset("utf-8", h) },
+		"errors":   JSONErrors,
+		"redirect": func(h http.Handler) http.Handler { return RedirectCode(http.StatusFound, h) },
+	}
+	for name, mw := range middleware {
+		var got string
+		h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = capabilities(w)
+		}))
+		// A recorder only flushes.
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		r.Header.Set("Accept", "application/json")
+		h.ServeHTTP(httptest.NewRecorder(), r)
+		if got != "flush" {
+			t.Errorf("%s, recorder: got %q, want %q", name, got, "flush")
+		}
+		// An HTTP/1.1 connection flushes and hijacks, but does not push.
+		s := httptest.NewServer(h)
+		req, _ := http.NewRequest(http.MethodGet, s.URL, nil)
+		req.Header.Set("Accept", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		s.Close()
+		if got != "flush,hijack" {
+			t.Errorf("%s, server: got %q, want %q", name, got, "flush,hijack")
+		}
+	}
+	w := withOptional(&statusRecorder{}, struct{ http.ResponseWriter }{httptest.NewRecorder()})
+	if got := capabilities(w); got != "" {
+		t.Errorf("plain writer: got %q", got)
+	}
+}
+
+// echoUpgrade switches to the echo protocol and echoes the lines it reads.
+func echoUpgrade(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upgrade") != "echo" {
+		http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	rw.Flush()
+	for {
+		line, err := rw.ReadString('\n')
+		if err != nil {
+			return
+		}
+		rw.WriteString(line)
+		rw.Flush()
+	}
+}
+
+func TestHijackThroughChain(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(echoUpgrade))
+	defer upstream.Close()
+	c := DefaultConfig()
+	c.Proxy = []string{"/ws=" + upstream.URL}
+	c.Logger = &Logger{Level: LevelInfo}
+	c.LogOutput = io.Discard
+	c.GZIP = true
+	c.Throttle = "1MB/s"
+	c.ErrorFormat = "json"
+	c.MinBodyRate = 1
+	h := newTestHandler(t, c, nil)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\nAccept-Encoding: gzip\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	for _, msg := range []string{"ping\n", "pong\n"} {
+		io.WriteString(conn, msg)
+		got, err := br.ReadString('\n')
+		if err != nil || got != msg {
+			t.Fatalf("got %q (%v), want %q", got, err, msg)
+		}
+	}
+	if !strings.EqualFold(resp.Header.Get("Upgrade"), "echo") {
+		t.Errorf("got Upgrade %q", resp.Header.Get("Upgrade"))
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use.
+type syncBuffer struct {
+	mu sync.Mutex
+	b  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestIsClientDisconnect(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	ctx, cancel := context.WithCancel(r.Context())
+	cancel()
+	tests := []struct {
+		name string
+		err  error
+		r    *http.Request
+		want bool
+	}{
+		{"broken pipe", &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}, r, true},
+		{"reset", fmt.Errorf("copy: %w", syscall.ECONNRESET), r, true},
+		{"canceled", errors.New("short write"), r.WithContext(ctx), true},
+		{"other", errors.New("disk on fire"), r, false},
+	}
+	for _, tt := range tests {
+		if got := isClientDisconnect(tt.err, tt.r); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestClientDisconnectMidWrite(t *testing.T) {
+	var internal, access syncBuffer
+	log.SetOutput(&internal)
+	defer log.SetOutput(os.Stderr)
+	c := DefaultConfig()
+	c.Logger = &Logger{Level: LevelDebug}
+	c.LogOutput = &access
+	c.LogFormat = "json"
+	c.LogFields = "path,disconnected"
+	c.GZIP = true
+	// Incompressible and larger than the socket buffers.
+	large := make([]byte, 32<<20)
+	rand.Read(large)
+	h := newTestHandler(t, c, map[string]string{"large.bin": string(large)})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /large.bin HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\n\r\n")
+	if _, err := io.ReadFull(conn, make([]byte, 1024)); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for !strings.Contains(access.String(), `"disconnected":true`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("got access log %q", access.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logged := internal.String()
+	if !strings.Contains(logged, "disconnected during GET /large.bin") {
+		t.Errorf("disconnect not logged at debug level: %q", logged)
+	}
+	if strings.Contains(logged, "write response") || strings.Contains(logged, "compress response") {
+		t.Errorf("disconnect logged as a failure: %q", logged)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	"github.com/cognicraft/serve/serve"
+)
+
+// lanIP returns the first non-loopback IPv4 address of an interface that is
+// up, or nil if there is none.
+func lanIP() net.IP {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if n, ok := addr.(*net.IPNet); ok && !n.IP.IsLoopback() {
+				if ip4 := n.IP.To4(); ip4 != nil {
+					return ip4
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// serveURLs returns the URL on localhost and, if the listener accepts
+// connections from other hosts, the URL on the LAN IP of the listening
+// address.
+func serveURLs(addr net.Addr, https bool) (local string, lan string) {
+	scheme := "http://"
+	if https {
+		scheme = "https://"
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return scheme + addr.String(), ""
+	}
+	port := strconv.Itoa(tcp.Port)
+	local = scheme + net.JoinHostPort("localhost", port)
+	if !tcp.IP.IsUnspecified() {
+		if !tcp.IP.IsLoopback() {
+			lan = scheme + net.JoinHostPort(tcp.IP.String(), port)
+		}
+		return local, lan
+	}
+	if ip := lanIP(); ip != nil {
+		lan = scheme + net.JoinHostPort(ip.String(), port)
+	}
+	return local, lan
+}
+
+// onceURL returns the URL to share with -once, on the LAN if there is one,
+// signed with key for ttl if key is set, e.g. for -signed-urls.
+func onceURL(local string, lan string, key []byte, ttl time.Duration) string {
+	base := local
+	if lan != "" {
+		base = lan
+	}
+	if key == nil {
+		return base + "/"
+	}
+	return base + serve.Sign(key, "/", time.Now().Add(ttl))
+}
+
+// openBrowser opens url with the default browser of the platform.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}